@@ -0,0 +1,154 @@
+package lsm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/gptjddldi/lsm/db/encoder"
+)
+
+// batch header: { sequence (8 bytes), count (4 bytes) }
+const batchHeaderSize = 8 + 4
+
+var errInvalidBatch = errors.New("invalid write batch")
+
+// WriteBatch 는 DB.Write 로 한 번에 반영되는 쓰기 묶음.
+// batch 의 쓰기는 연속된 sequence 를 받고, 모두 보이거나 하나도 보이지 않는다.
+// 빈 WriteBatch (zero value) 를 그대로 사용할 수 있다
+type WriteBatch struct {
+	// { header, record... }
+	// record: { opKind, key length, key } 뒤에 Put 이면 { value length, value }
+	rep     []byte
+	memSize int // memtable 에 들어갔을 때 차지하는 크기
+}
+
+func NewWriteBatch() *WriteBatch {
+	b := &WriteBatch{}
+	b.Clear()
+	return b
+}
+
+func (b *WriteBatch) init() {
+	if len(b.rep) < batchHeaderSize {
+		b.rep = make([]byte, batchHeaderSize)
+	}
+}
+
+func (b *WriteBatch) Put(key, val []byte) {
+	b.init()
+	b.setCount(b.Count() + 1)
+	b.rep = append(b.rep, byte(encoder.OpTypeSet))
+	b.rep = binary.AppendUvarint(b.rep, uint64(len(key)))
+	b.rep = append(b.rep, key...)
+	b.rep = binary.AppendUvarint(b.rep, uint64(len(val)))
+	b.rep = append(b.rep, val...)
+	b.memSize += len(key) + len(val) + encoder.TrailerSize
+}
+
+func (b *WriteBatch) Delete(key []byte) {
+	b.init()
+	b.setCount(b.Count() + 1)
+	b.rep = append(b.rep, byte(encoder.OpTypeDelete))
+	b.rep = binary.AppendUvarint(b.rep, uint64(len(key)))
+	b.rep = append(b.rep, key...)
+	b.memSize += len(key) + encoder.TrailerSize
+}
+
+// Clear 는 batch 를 비워 다시 사용할 수 있게 한다.
+// memtable 이 이전 rep 의 key, value 를 그대로 참조하므로 버퍼를 재사용하지 않는다
+func (b *WriteBatch) Clear() {
+	b.rep = make([]byte, batchHeaderSize)
+	b.memSize = 0
+}
+
+func (b *WriteBatch) Count() int {
+	if len(b.rep) < batchHeaderSize {
+		return 0
+	}
+	return int(binary.LittleEndian.Uint32(b.rep[8:]))
+}
+
+// Repr 은 WAL 에 기록되는 batch 의 직렬화된 형태를 반환한다.
+// 반환된 slice 는 batch 가 바뀌면 같이 바뀐다
+func (b *WriteBatch) Repr() []byte {
+	b.init()
+	return b.rep
+}
+
+func (b *WriteBatch) setCount(n int) {
+	binary.LittleEndian.PutUint32(b.rep[8:], uint32(n))
+}
+
+func (b *WriteBatch) sequence() uint64 {
+	return binary.LittleEndian.Uint64(b.rep)
+}
+
+func (b *WriteBatch) setSequence(seq uint64) {
+	b.init()
+	binary.LittleEndian.PutUint64(b.rep, seq)
+}
+
+// newWriteBatchFromRepr 는 WAL 에서 읽은 record 로 batch 를 만든다. rep 을 복사한다
+func newWriteBatchFromRepr(rep []byte) (*WriteBatch, error) {
+	if len(rep) < batchHeaderSize {
+		return nil, fmt.Errorf("%w: %d bytes", errInvalidBatch, len(rep))
+	}
+	b := &WriteBatch{rep: append([]byte(nil), rep...)}
+	count := 0
+	err := b.forEach(func(op encoder.OpType, key, val []byte) {
+		count++
+		b.memSize += len(key) + len(val) + encoder.TrailerSize
+	})
+	if err != nil {
+		return nil, err
+	}
+	if count != b.Count() {
+		return nil, fmt.Errorf("%w: count %d, found %d records", errInvalidBatch, b.Count(), count)
+	}
+	return b, nil
+}
+
+// forEach 는 batch 의 record 를 추가된 순서대로 fn 에 넘긴다
+func (b *WriteBatch) forEach(fn func(op encoder.OpType, key, val []byte)) error {
+	b.init()
+	buf := b.rep[batchHeaderSize:]
+	readSlice := func() ([]byte, bool) {
+		n, size := binary.Uvarint(buf)
+		if size <= 0 || uint64(len(buf)-size) < n {
+			return nil, false
+		}
+		s := buf[size : size+int(n)]
+		buf = buf[size+int(n):]
+		return s, true
+	}
+	for len(buf) > 0 {
+		op := encoder.OpType(buf[0])
+		buf = buf[1:]
+		key, ok := readSlice()
+		if !ok {
+			return fmt.Errorf("%w: bad key", errInvalidBatch)
+		}
+		var val []byte
+		switch op {
+		case encoder.OpTypeSet:
+			if val, ok = readSlice(); !ok {
+				return fmt.Errorf("%w: bad value", errInvalidBatch)
+			}
+		case encoder.OpTypeDelete:
+		default:
+			return fmt.Errorf("%w: unknown op %d", errInvalidBatch, op)
+		}
+		fn(op, key, val)
+	}
+	return nil
+}
+
+// insertInto 는 batch 의 record 에 batch sequence 부터 차례로 번호를 매겨 memtable 에 넣는다
+func (b *WriteBatch) insertInto(m *Memtable) error {
+	seq := b.sequence()
+	return b.forEach(func(op encoder.OpType, key, val []byte) {
+		m.Add(seq, op, key, val)
+		seq++
+	})
+}
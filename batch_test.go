@@ -0,0 +1,95 @@
+package lsm
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gptjddldi/lsm/db/encoder"
+	"github.com/gptjddldi/lsm/db/wal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteBatch_Repr(t *testing.T) {
+	var batch WriteBatch
+	assert.Equal(t, 0, batch.Count())
+	batch.Put([]byte("a"), []byte("1"))
+	batch.Delete([]byte("b"))
+	batch.Put([]byte("c"), nil)
+	batch.setSequence(7)
+	assert.Equal(t, 3, batch.Count())
+
+	decoded, err := newWriteBatchFromRepr(batch.Repr())
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(7), decoded.sequence())
+
+	var got []string
+	assert.NoError(t, decoded.forEach(func(op encoder.OpType, key, val []byte) {
+		got = append(got, fmt.Sprintf("%d:%s=%s", op, key, val))
+	}))
+	assert.Equal(t, []string{"1:a=1", "0:b=", "1:c="}, got)
+
+	_, err = newWriteBatchFromRepr(batch.Repr()[:len(batch.Repr())-1])
+	assert.ErrorIs(t, err, errInvalidBatch)
+
+	batch.Clear()
+	assert.Equal(t, 0, batch.Count())
+	assert.Len(t, batch.Repr(), batchHeaderSize)
+}
+
+func TestDB_WriteBatch(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, &Options{WAL: WALOptions{SyncMode: wal.SyncNone}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, db.Insert([]byte("deleted"), []byte("value")))
+
+	batch := NewWriteBatch()
+	for i := 0; i < 10; i++ {
+		batch.Put([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)))
+	}
+	batch.Delete([]byte("deleted"))
+	assert.NoError(t, db.Write(batch, &WriteOptions{Sync: true}))
+	assert.Equal(t, uint64(12), db.lastSeq.Load())
+	crash(db)
+
+	db, err = Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	assert.Equal(t, uint64(12), db.lastSeq.Load())
+	for i := 0; i < 10; i++ {
+		val, err := db.Get([]byte(fmt.Sprintf("key%d", i)))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("value%d", i)), val)
+	}
+	_, err = db.Get([]byte("deleted"))
+	assert.ErrorIs(t, err, ErrorKeyNotFound)
+}
+
+func TestDB_WriteBatchRotatesMemtableOnce(t *testing.T) {
+	db, err := Open(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.memtables.mutable.sizeLimit = 100
+	assert.NoError(t, db.Insert([]byte("first"), []byte("value")))
+	first := db.memtables.mutable
+
+	batch := NewWriteBatch()
+	for i := 0; i < 20; i++ {
+		batch.Put([]byte(fmt.Sprintf("key%02d", i)), []byte("value"))
+	}
+	assert.NoError(t, db.Write(batch, nil))
+
+	// batch 전체가 새 memtable 하나에 들어간다
+	db.mu.RLock()
+	second := db.memtables.mutable
+	db.mu.RUnlock()
+	assert.NotSame(t, first, second)
+	assert.Equal(t, uint64(21), second.LastSequence())
+	assert.Equal(t, batch.memSize, second.Size())
+}
@@ -0,0 +1,574 @@
+package lsm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/gptjddldi/lsm/db/encoder"
+)
+
+// blockLayout 은 format version 에 따라 정해지는 data block 의 layout
+type blockLayout int
+
+const (
+	// format version 0, 1: { key length, value length, key, value } 의 반복
+	blockLayoutFlat blockLayout = iota
+
+	// format version 2, 3:
+	//
+	//	entry ... | restart offset (4) ... | restart 개수 (4)
+	//	entry: { shared key length, unshared key length, value length, unshared key, value }
+	//
+	// key 는 바로 앞 entry 와 겹치는 prefix 를 빼고 저장한다. restart point 의 entry 는 key 전체를 저장하므로
+	// restart point 로 binary search 한 뒤 짧게 순차 탐색한다
+	blockLayoutRestarts
+
+	// format version 4:
+	//
+	//	entry ... | entry offset (4) ... | restart 간격 (4) | entry 개수 (4)
+	//
+	// entry 는 blockLayoutRestarts 와 같지만 key 는 앞 entry 가 아니라 속한 restart point 의 key 와 겹치는
+	// prefix 를 뺀다. 모든 entry 의 offset 이 있으므로 순차 탐색 없이 entry 단위로 binary search 한다
+	blockLayoutEntryOffsets
+)
+
+func blockLayoutOf(version uint32) blockLayout {
+	switch {
+	case version < formatVersion2:
+		return blockLayoutFlat
+	case version < formatVersion4:
+		return blockLayoutRestarts
+	default:
+		return blockLayoutEntryOffsets
+	}
+}
+
+type blockBuilder struct {
+	layout   blockLayout
+	buf      []byte
+	restarts []uint32
+	offsets  []uint32 // blockLayoutEntryOffsets 의 entry offset
+	counter  int      // 마지막 restart point 이후 entry 수
+	lastKey  []byte   // prefix 를 뺄 key. blockLayoutEntryOffsets 에서는 마지막 restart point 의 key
+
+	restartInterval int // restart point 사이의 entry 수
+}
+
+func newBlockBuilder(layout blockLayout, restartInterval int) *blockBuilder {
+	b := &blockBuilder{layout: layout, restartInterval: restartInterval}
+	b.reset()
+	return b
+}
+
+func (b *blockBuilder) reset() {
+	b.buf = b.buf[:0]
+	b.restarts = append(b.restarts[:0], 0)
+	b.offsets = b.offsets[:0]
+	b.counter = 0
+	b.lastKey = b.lastKey[:0]
+}
+
+func (b *blockBuilder) empty() bool {
+	return len(b.buf) == 0
+}
+
+// add 는 internal key 순서대로 호출되어야 한다
+func (b *blockBuilder) add(key, value []byte) {
+	if b.layout == blockLayoutFlat {
+		b.buf = binary.AppendUvarint(b.buf, uint64(len(key)))
+		b.buf = binary.AppendUvarint(b.buf, uint64(len(value)))
+		b.buf = append(b.buf, key...)
+		b.buf = append(b.buf, value...)
+		return
+	}
+
+	shared := 0
+	if b.counter < b.restartInterval {
+		for shared < len(key) && shared < len(b.lastKey) && key[shared] == b.lastKey[shared] {
+			shared++
+		}
+	} else {
+		b.restarts = append(b.restarts, uint32(len(b.buf)))
+		b.counter = 0
+	}
+	if b.layout == blockLayoutEntryOffsets {
+		b.offsets = append(b.offsets, uint32(len(b.buf)))
+	}
+	b.buf = binary.AppendUvarint(b.buf, uint64(shared))
+	b.buf = binary.AppendUvarint(b.buf, uint64(len(key)-shared))
+	b.buf = binary.AppendUvarint(b.buf, uint64(len(value)))
+	b.buf = append(b.buf, key[shared:]...)
+	b.buf = append(b.buf, value...)
+
+	if b.layout == blockLayoutRestarts || b.counter == 0 {
+		b.lastKey = append(b.lastKey[:0], key...)
+	}
+	b.counter++
+}
+
+// estimatedSize 는 finish 했을 때의 block 크기
+func (b *blockBuilder) estimatedSize() int {
+	switch b.layout {
+	case blockLayoutFlat:
+		return len(b.buf)
+	case blockLayoutEntryOffsets:
+		return len(b.buf) + 4*len(b.offsets) + 8
+	}
+	return len(b.buf) + 4*len(b.restarts) + 4
+}
+
+func (b *blockBuilder) finish() []byte {
+	switch b.layout {
+	case blockLayoutFlat:
+		return b.buf
+	case blockLayoutEntryOffsets:
+		for _, o := range b.offsets {
+			b.buf = binary.LittleEndian.AppendUint32(b.buf, o)
+		}
+		b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(b.restartInterval))
+		return binary.LittleEndian.AppendUint32(b.buf, uint32(len(b.offsets)))
+	}
+	for _, r := range b.restarts {
+		b.buf = binary.LittleEndian.AppendUint32(b.buf, r)
+	}
+	return binary.LittleEndian.AppendUint32(b.buf, uint32(len(b.restarts)))
+}
+
+// blockIterator 는 하나의 data block 을 internal key 순서로 순회한다.
+// 이동 메서드는 이동한 위치에 entry 가 있는지를 반환하고, 깨진 entry 를 만나면 Err 를 남기고 멈춘다
+type blockIterator interface {
+	SeekToFirst() bool
+	SeekToLast() bool
+	Seek(target []byte) bool // target 보다 크거나 같은 첫 internal key 로 이동한다
+	Next() bool
+	Prev() bool
+	Key() []byte // internal key, 다음 이동 후에도 바뀌지 않는다
+	Value() []byte
+	Err() error
+}
+
+func newBlockIterator(buf []byte, layout blockLayout, cmp func(a, b []byte) int) (blockIterator, error) {
+	switch layout {
+	case blockLayoutFlat:
+		return newFlatBlockIter(buf, cmp)
+	case blockLayoutEntryOffsets:
+		return newEntryBlockIter(buf, cmp)
+	}
+	return newPrefixBlockIter(buf, cmp)
+}
+
+type prefixBlockIter struct {
+	data     []byte // restart 배열을 뺀 entry 영역
+	restarts []byte
+	cmp      func(a, b []byte) int
+
+	offset     int // 현재 entry 의 시작, len(data) 면 끝
+	nextOffset int
+	key        []byte
+	value      []byte
+	err        error
+}
+
+func newPrefixBlockIter(buf []byte, cmp func(a, b []byte) int) (*prefixBlockIter, error) {
+	if len(buf) < 4 {
+		return nil, fmt.Errorf("block of %d bytes is too short", len(buf))
+	}
+	numRestarts := int(binary.LittleEndian.Uint32(buf[len(buf)-4:]))
+	if numRestarts == 0 || numRestarts > (len(buf)-4)/4 {
+		return nil, fmt.Errorf("invalid restart count %d", numRestarts)
+	}
+	restartsStart := len(buf) - 4 - 4*numRestarts
+	it := &prefixBlockIter{
+		data:     buf[:restartsStart],
+		restarts: buf[restartsStart : len(buf)-4],
+		cmp:      cmp,
+	}
+	it.offset = len(it.data)
+	return it, nil
+}
+
+func (it *prefixBlockIter) numRestarts() int {
+	return len(it.restarts) / 4
+}
+
+func (it *prefixBlockIter) restartOffset(i int) int {
+	return int(binary.LittleEndian.Uint32(it.restarts[4*i:]))
+}
+
+// seekToRestart 는 i 번째 restart point 의 entry 를 다음에 읽도록 한다
+func (it *prefixBlockIter) seekToRestart(i int) {
+	it.key = nil
+	it.nextOffset = it.restartOffset(i)
+}
+
+// parseNext 는 nextOffset 의 entry 를 읽어 현재 entry 로 만든다
+func (it *prefixBlockIter) parseNext() bool {
+	it.offset = it.nextOffset
+	if it.offset >= len(it.data) {
+		it.offset = len(it.data)
+		it.key, it.value = nil, nil
+		return false
+	}
+	key, value, n, err := decodePrefixEntry(it.data[it.offset:], it.key)
+	if err != nil {
+		return it.corrupt(err.Error())
+	}
+	it.key, it.value = key, value
+	it.nextOffset = it.offset + n
+	return true
+}
+
+// decodePrefixEntry 는 buf 앞의 { shared, unshared, value length, unshared key, value } 를 읽고 entry 의 길이를 반환한다.
+// key 는 prefix 의 앞 shared byte 뒤에 unshared key 를 붙여 새로 할당해서 호출자가 들고 있는 key 가 바뀌지 않게 한다
+func decodePrefixEntry(buf, prefix []byte) ([]byte, []byte, int, error) {
+	var header [3]uint64
+	n := 0
+	for i := range header {
+		v, size := binary.Uvarint(buf[n:])
+		if size <= 0 {
+			return nil, nil, 0, errors.New("invalid entry header")
+		}
+		header[i] = v
+		n += size
+	}
+	shared, unshared, valueLen := header[0], header[1], header[2]
+	if shared+unshared < encoder.TrailerSize || shared > uint64(len(prefix)) || unshared > uint64(len(buf)-n) || valueLen > uint64(len(buf)-n)-unshared {
+		return nil, nil, 0, errors.New("invalid entry lengths")
+	}
+	key := make([]byte, shared+unshared)
+	copy(key, prefix[:shared])
+	copy(key[shared:], buf[n:n+int(unshared)])
+	n += int(unshared)
+	return key, buf[n : n+int(valueLen)], n + int(valueLen), nil
+}
+
+func (it *prefixBlockIter) corrupt(reason string) bool {
+	it.err = fmt.Errorf("%s at block offset %d", reason, it.offset)
+	it.offset = len(it.data)
+	it.key, it.value = nil, nil
+	return false
+}
+
+func (it *prefixBlockIter) valid() bool {
+	return it.err == nil && it.offset < len(it.data)
+}
+
+func (it *prefixBlockIter) SeekToFirst() bool {
+	it.seekToRestart(0)
+	return it.parseNext()
+}
+
+func (it *prefixBlockIter) SeekToLast() bool {
+	it.seekToRestart(it.numRestarts() - 1)
+	for it.parseNext() && it.nextOffset < len(it.data) {
+	}
+	return it.valid()
+}
+
+func (it *prefixBlockIter) Seek(target []byte) bool {
+	// target 보다 작은 key 를 가진 마지막 restart point 를 찾는다
+	low, high := 0, it.numRestarts()-1
+	for low < high {
+		mid := (low + high + 1) / 2
+		it.seekToRestart(mid)
+		if !it.parseNext() {
+			return false
+		}
+		if it.cmp(it.key, target) < 0 {
+			low = mid
+		} else {
+			high = mid - 1
+		}
+	}
+	it.seekToRestart(low)
+	for it.parseNext() {
+		if it.cmp(it.key, target) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (it *prefixBlockIter) Next() bool {
+	if !it.valid() {
+		return false
+	}
+	return it.parseNext()
+}
+
+// Prev 는 현재 entry 앞의 restart point 로 돌아가 현재 entry 직전까지 읽는다
+func (it *prefixBlockIter) Prev() bool {
+	if !it.valid() {
+		return false
+	}
+	current := it.offset
+	r := sort.Search(it.numRestarts(), func(i int) bool {
+		return it.restartOffset(i) >= current
+	}) - 1
+	if r < 0 {
+		it.offset = len(it.data)
+		it.key, it.value = nil, nil
+		return false
+	}
+	it.seekToRestart(r)
+	for it.parseNext() && it.nextOffset < current {
+	}
+	return it.valid()
+}
+
+func (it *prefixBlockIter) Key() []byte {
+	return it.key
+}
+
+func (it *prefixBlockIter) Value() []byte {
+	return it.value
+}
+
+func (it *prefixBlockIter) Err() error {
+	return it.err
+}
+
+// entryBlockIter 는 blockLayoutEntryOffsets 의 data block 을 entry offset 으로 순회한다
+type entryBlockIter struct {
+	data     []byte // entry offset 배열을 뺀 entry 영역
+	offsets  []byte
+	interval int
+	cmp      func(a, b []byte) int
+
+	pos        int // 현재 entry 번호, numEntries 면 끝
+	restartPos int // restartKey 의 entry 번호, 읽은 적이 없으면 -1
+	restartKey []byte
+	key        []byte
+	value      []byte
+	err        error
+}
+
+func newEntryBlockIter(buf []byte, cmp func(a, b []byte) int) (*entryBlockIter, error) {
+	if len(buf) < 8 {
+		return nil, fmt.Errorf("block of %d bytes is too short", len(buf))
+	}
+	numEntries := int(binary.LittleEndian.Uint32(buf[len(buf)-4:]))
+	if numEntries == 0 || numEntries > (len(buf)-8)/4 {
+		return nil, fmt.Errorf("invalid entry count %d", numEntries)
+	}
+	interval := int(binary.LittleEndian.Uint32(buf[len(buf)-8:]))
+	if interval == 0 {
+		return nil, fmt.Errorf("invalid restart interval %d", interval)
+	}
+	offsetsStart := len(buf) - 8 - 4*numEntries
+	return &entryBlockIter{
+		data:       buf[:offsetsStart],
+		offsets:    buf[offsetsStart : len(buf)-8],
+		interval:   interval,
+		cmp:        cmp,
+		pos:        numEntries,
+		restartPos: -1,
+	}, nil
+}
+
+func (it *entryBlockIter) numEntries() int {
+	return len(it.offsets) / 4
+}
+
+// decode 는 i 번째 entry 를 prefix 와 합쳐 읽는다
+func (it *entryBlockIter) decode(i int, prefix []byte) ([]byte, []byte, error) {
+	offset := int(binary.LittleEndian.Uint32(it.offsets[4*i:]))
+	if offset >= len(it.data) {
+		return nil, nil, fmt.Errorf("entry offset %d out of range", offset)
+	}
+	key, value, _, err := decodePrefixEntry(it.data[offset:], prefix)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%v at block offset %d", err, offset)
+	}
+	return key, value, nil
+}
+
+// seekTo 는 i 번째 entry 로 이동한다. 범위를 벗어나면 끝으로 간다
+func (it *entryBlockIter) seekTo(i int) bool {
+	if i < 0 || i >= it.numEntries() {
+		it.pos = it.numEntries()
+		it.key, it.value = nil, nil
+		return false
+	}
+	// 같은 restart point 에 속한 entry 를 연달아 읽을 때는 restart key 를 다시 읽지 않는다
+	r := i - i%it.interval
+	if it.restartPos != r {
+		key, _, err := it.decode(r, nil)
+		if err != nil {
+			return it.corrupt(err)
+		}
+		it.restartPos, it.restartKey = r, key
+	}
+	var prefix []byte
+	if i != r {
+		prefix = it.restartKey
+	}
+	key, value, err := it.decode(i, prefix)
+	if err != nil {
+		return it.corrupt(err)
+	}
+	it.pos, it.key, it.value = i, key, value
+	return true
+}
+
+func (it *entryBlockIter) corrupt(err error) bool {
+	it.err = err
+	it.pos = it.numEntries()
+	it.key, it.value = nil, nil
+	return false
+}
+
+func (it *entryBlockIter) valid() bool {
+	return it.err == nil && it.pos < it.numEntries()
+}
+
+func (it *entryBlockIter) SeekToFirst() bool {
+	return it.seekTo(0)
+}
+
+func (it *entryBlockIter) SeekToLast() bool {
+	return it.seekTo(it.numEntries() - 1)
+}
+
+func (it *entryBlockIter) Seek(target []byte) bool {
+	low, high := 0, it.numEntries()
+	for low < high {
+		mid := (low + high) / 2
+		if !it.seekTo(mid) {
+			return false
+		}
+		if it.cmp(it.key, target) < 0 {
+			low = mid + 1
+		} else {
+			high = mid
+		}
+	}
+	return it.seekTo(low)
+}
+
+func (it *entryBlockIter) Next() bool {
+	if !it.valid() {
+		return false
+	}
+	return it.seekTo(it.pos + 1)
+}
+
+func (it *entryBlockIter) Prev() bool {
+	if !it.valid() {
+		return false
+	}
+	return it.seekTo(it.pos - 1)
+}
+
+func (it *entryBlockIter) Key() []byte {
+	return it.key
+}
+
+func (it *entryBlockIter) Value() []byte {
+	return it.value
+}
+
+func (it *entryBlockIter) Err() error {
+	return it.err
+}
+
+// flatBlockIter 는 format version 0, 1 의 data block 을 한 번에 풀어서 순회한다
+type flatBlockIter struct {
+	keys   [][]byte
+	values [][]byte
+	cmp    func(a, b []byte) int
+	pos    int
+}
+
+func newFlatBlockIter(buf []byte, cmp func(a, b []byte) int) (*flatBlockIter, error) {
+	it := &flatBlockIter{cmp: cmp}
+	var offset int
+	for offset < len(buf) {
+		key, val, next, err := decodeEntry(buf, offset)
+		if err != nil {
+			return nil, err
+		}
+		offset = next
+		it.keys = append(it.keys, key)
+		it.values = append(it.values, val)
+	}
+	it.pos = len(it.keys)
+	return it, nil
+}
+
+func (it *flatBlockIter) valid() bool {
+	return it.pos >= 0 && it.pos < len(it.keys)
+}
+
+func (it *flatBlockIter) SeekToFirst() bool {
+	it.pos = 0
+	return it.valid()
+}
+
+func (it *flatBlockIter) SeekToLast() bool {
+	it.pos = len(it.keys) - 1
+	return it.valid()
+}
+
+func (it *flatBlockIter) Seek(target []byte) bool {
+	it.pos = sort.Search(len(it.keys), func(i int) bool {
+		return it.cmp(it.keys[i], target) >= 0
+	})
+	return it.valid()
+}
+
+func (it *flatBlockIter) Next() bool {
+	if !it.valid() {
+		return false
+	}
+	it.pos++
+	return it.valid()
+}
+
+func (it *flatBlockIter) Prev() bool {
+	if !it.valid() {
+		return false
+	}
+	it.pos--
+	if it.pos < 0 {
+		it.pos = len(it.keys)
+	}
+	return it.valid()
+}
+
+func (it *flatBlockIter) Key() []byte {
+	return it.keys[it.pos]
+}
+
+func (it *flatBlockIter) Value() []byte {
+	return it.values[it.pos]
+}
+
+func (it *flatBlockIter) Err() error {
+	return nil
+}
+
+// decodeEntry 는 buf[offset:] 의 { internal key length, value length, internal key, value } 를 읽고
+// 다음 entry 의 offset 을 반환한다
+func decodeEntry(buf []byte, offset int) ([]byte, []byte, int, error) {
+	keyLen, n := binary.Uvarint(buf[offset:])
+	if n <= 0 {
+		return nil, nil, 0, fmt.Errorf("invalid key length at block offset %d", offset)
+	}
+	offset += n
+	valLen, n := binary.Uvarint(buf[offset:])
+	if n <= 0 || keyLen < encoder.TrailerSize {
+		return nil, nil, 0, fmt.Errorf("invalid entry lengths at block offset %d", offset)
+	}
+	offset += n
+	if keyLen > uint64(len(buf)-offset) || valLen > uint64(len(buf)-offset)-keyLen {
+		return nil, nil, 0, fmt.Errorf("entry at block offset %d exceeds block", offset)
+	}
+	key := buf[offset : offset+int(keyLen)]
+	offset += int(keyLen)
+	val := buf[offset : offset+int(valLen)]
+	offset += int(valLen)
+	return key, val, offset, nil
+}
@@ -0,0 +1,93 @@
+package lsm
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func openCacheTestDB(t *testing.T, opts *Options) *DB {
+	db, err := Open(t.TempDir(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+	for i := 0; i < 1000; i++ {
+		assert.NoError(t, db.Insert([]byte(fmt.Sprintf("key%04d", i)), []byte(fmt.Sprintf("value%04d", i))))
+	}
+	flushMutable(t, db)
+	return db
+}
+
+func TestBlockCache_GetHitsAfterFirstRead(t *testing.T) {
+	db := openCacheTestDB(t, nil)
+
+	val, err := db.Get([]byte("key0500"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value0500"), val)
+	st := db.BlockCacheStats()
+	assert.Equal(t, uint64(0), st.Hits)
+	assert.Equal(t, uint64(1), st.Misses)
+	assert.Equal(t, 1, st.Entries)
+
+	// 돌려받은 value 를 고쳐도 cache 의 block 은 바뀌지 않는다
+	val[0] = 'X'
+	val, err = db.Get([]byte("key0500"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value0500"), val)
+	assert.Equal(t, uint64(1), db.BlockCacheStats().Hits)
+}
+
+func TestBlockCache_ScanWithoutFillCache(t *testing.T) {
+	db := openCacheTestDB(t, nil)
+
+	it := db.NewIterator(&ReadOptions{DontFillCache: true})
+	n := 0
+	for ok := it.SeekToFirst(); ok; ok = it.Next() {
+		n++
+	}
+	assert.NoError(t, it.Close())
+	assert.Equal(t, 1000, n)
+	assert.Equal(t, 0, db.BlockCacheStats().Entries)
+
+	it = db.NewIterator(nil)
+	for ok := it.SeekToFirst(); ok; ok = it.Next() {
+	}
+	assert.NoError(t, it.Close())
+	assert.Greater(t, db.BlockCacheStats().Entries, 1)
+}
+
+func TestBlockCache_SnapshotReadFillsCache(t *testing.T) {
+	db := openCacheTestDB(t, nil)
+	snap := db.NewSnapshot()
+	defer snap.Release()
+
+	// DontFillCache 를 두지 않은 ReadOptions 는 nil 과 같이 block cache 를 채운다
+	opts := &ReadOptions{Snapshot: snap}
+	for i := 0; i < 2; i++ {
+		val, err := db.GetWithOptions([]byte("key0500"), opts)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("value0500"), val)
+	}
+	st := db.BlockCacheStats()
+	assert.Equal(t, 1, st.Entries)
+	assert.Equal(t, uint64(1), st.Hits)
+
+	it := db.NewIterator(opts)
+	for ok := it.SeekToFirst(); ok; ok = it.Next() {
+	}
+	assert.NoError(t, it.Close())
+	assert.Greater(t, db.BlockCacheStats().Entries, 1)
+}
+
+func TestBlockCache_Disabled(t *testing.T) {
+	db := openCacheTestDB(t, &Options{BlockCacheSize: -1})
+
+	for i := 0; i < 2; i++ {
+		val, err := db.Get([]byte("key0001"))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("value0001"), val)
+	}
+	assert.Equal(t, uint64(0), db.BlockCacheStats().Hits+db.BlockCacheStats().Misses)
+}
@@ -0,0 +1,204 @@
+package lsm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/gptjddldi/lsm/db/compare"
+	"github.com/gptjddldi/lsm/db/encoder"
+	"github.com/stretchr/testify/assert"
+)
+
+func compareInternalBytewise(a, b []byte) int {
+	return compare.CompareInternal(compare.Bytewise, a, b)
+}
+
+func buildTestBlock(layout blockLayout, n int) ([]byte, [][]byte) {
+	b := newBlockBuilder(layout, 16)
+	keys := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		key := encoder.MakeInternalKey([]byte(fmt.Sprintf("tenant/0001/user/%06d", i)), uint64(i+1), encoder.OpTypeSet)
+		b.add(key, []byte(fmt.Sprintf("v%d", i)))
+		keys = append(keys, key)
+	}
+	return b.finish(), keys
+}
+
+func TestBlock_RoundTrip(t *testing.T) {
+	buf, keys := buildTestBlock(blockLayoutRestarts, 100)
+	it, err := newBlockIterator(buf, blockLayoutRestarts, compareInternalBytewise)
+	assert.NoError(t, err)
+
+	i := 0
+	for ok := it.SeekToFirst(); ok; ok = it.Next() {
+		assert.Equal(t, keys[i], it.Key())
+		assert.Equal(t, []byte(fmt.Sprintf("v%d", i)), it.Value())
+		i++
+	}
+	assert.NoError(t, it.Err())
+	assert.Equal(t, len(keys), i)
+
+	// 뒤에서부터 restart point 경계를 넘어 거꾸로 읽는다
+	i = len(keys) - 1
+	for ok := it.SeekToLast(); ok; ok = it.Prev() {
+		assert.Equal(t, keys[i], it.Key())
+		i--
+	}
+	assert.Equal(t, -1, i)
+}
+
+func TestBlock_Seek(t *testing.T) {
+	buf, keys := buildTestBlock(blockLayoutRestarts, 100)
+	it, err := newBlockIterator(buf, blockLayoutRestarts, compareInternalBytewise)
+	assert.NoError(t, err)
+
+	for _, i := range []int{0, 15, 16, 17, 50, 99} {
+		assert.True(t, it.Seek(keys[i]))
+		assert.Equal(t, keys[i], it.Key())
+	}
+	assert.True(t, it.Seek(encoder.MakeLookupKey([]byte("tenant/0001/user/000032x"), encoder.MaxSequenceNumber)))
+	assert.Equal(t, keys[33], it.Key())
+	assert.True(t, it.Seek(encoder.MakeLookupKey([]byte("a"), encoder.MaxSequenceNumber)))
+	assert.Equal(t, keys[0], it.Key())
+	assert.False(t, it.Seek(encoder.MakeLookupKey([]byte("z"), encoder.MaxSequenceNumber)))
+}
+
+func TestBlock_EntryOffsets(t *testing.T) {
+	buf, keys := buildTestBlock(blockLayoutEntryOffsets, 100)
+	it, err := newBlockIterator(buf, blockLayoutEntryOffsets, compareInternalBytewise)
+	assert.NoError(t, err)
+	assert.Len(t, it.(*entryBlockIter).offsets, 4*len(keys))
+
+	i := 0
+	for ok := it.SeekToFirst(); ok; ok = it.Next() {
+		assert.Equal(t, keys[i], it.Key())
+		assert.Equal(t, []byte(fmt.Sprintf("v%d", i)), it.Value())
+		i++
+	}
+	assert.NoError(t, it.Err())
+	assert.Equal(t, len(keys), i)
+
+	i = len(keys) - 1
+	for ok := it.SeekToLast(); ok; ok = it.Prev() {
+		assert.Equal(t, keys[i], it.Key())
+		i--
+	}
+	assert.Equal(t, -1, i)
+
+	for _, i := range []int{0, 15, 16, 17, 50, 99} {
+		assert.True(t, it.Seek(keys[i]))
+		assert.Equal(t, keys[i], it.Key())
+	}
+	assert.True(t, it.Seek(encoder.MakeLookupKey([]byte("tenant/0001/user/000032x"), encoder.MaxSequenceNumber)))
+	assert.Equal(t, keys[33], it.Key())
+	assert.True(t, it.Next())
+	assert.Equal(t, keys[34], it.Key())
+	assert.False(t, it.Seek(encoder.MakeLookupKey([]byte("z"), encoder.MaxSequenceNumber)))
+
+	// restart point 의 key 와 prefix 를 나누므로 offset 배열을 더해도 압축하지 않은 block 보다 작다
+	flat, _ := buildTestBlock(blockLayoutFlat, 100)
+	assert.Less(t, len(buf), len(flat))
+}
+
+func TestBlock_EntryOffsetsDetectCorruption(t *testing.T) {
+	buf, _ := buildTestBlock(blockLayoutEntryOffsets, 100)
+	it, err := newBlockIterator(buf, blockLayoutEntryOffsets, compareInternalBytewise)
+	assert.NoError(t, err)
+	// 17 번째 entry 는 restart point 라서 shared 길이가 0 이어야 한다
+	offset := binary.LittleEndian.Uint32(it.(*entryBlockIter).offsets[4*16:])
+	buf[offset] = 1
+
+	it, err = newBlockIterator(buf, blockLayoutEntryOffsets, compareInternalBytewise)
+	assert.NoError(t, err)
+	assert.True(t, it.SeekToFirst())
+	assert.False(t, it.Seek(encoder.MakeLookupKey([]byte("tenant/0001/user/000020"), encoder.MaxSequenceNumber)))
+	assert.Error(t, it.Err())
+
+	// entry offset 이 entry 영역을 벗어난다
+	buf, _ = buildTestBlock(blockLayoutEntryOffsets, 100)
+	binary.LittleEndian.PutUint32(buf[len(buf)-12:], uint32(len(buf)))
+	it, err = newBlockIterator(buf, blockLayoutEntryOffsets, compareInternalBytewise)
+	assert.NoError(t, err)
+	assert.False(t, it.SeekToLast())
+	assert.Error(t, it.Err())
+
+	binary.LittleEndian.PutUint32(buf[len(buf)-8:], 0)
+	_, err = newBlockIterator(buf, blockLayoutEntryOffsets, compareInternalBytewise)
+	assert.Error(t, err)
+	_, err = newBlockIterator([]byte{1, 2, 3, 4}, blockLayoutEntryOffsets, compareInternalBytewise)
+	assert.Error(t, err)
+}
+
+func TestBlock_PrefixCompressionShrinksBlock(t *testing.T) {
+	prefixed, _ := buildTestBlock(blockLayoutRestarts, 100)
+	flat, _ := buildTestBlock(blockLayoutFlat, 100)
+	assert.Less(t, len(prefixed), len(flat)/2)
+}
+
+func TestBlock_DetectsCorruptEntry(t *testing.T) {
+	buf, _ := buildTestBlock(blockLayoutRestarts, 100)
+	it, err := newBlockIterator(buf, blockLayoutRestarts, compareInternalBytewise)
+	assert.NoError(t, err)
+	assert.True(t, it.SeekToFirst())
+	// 두 번째 entry 의 shared 길이를 앞 key 보다 길게 만든다
+	buf[it.(*prefixBlockIter).nextOffset] = 0x7f
+
+	it, err = newBlockIterator(buf, blockLayoutRestarts, compareInternalBytewise)
+	assert.NoError(t, err)
+	assert.True(t, it.SeekToFirst())
+	assert.False(t, it.Next())
+	assert.Error(t, it.Err())
+
+	_, err = newBlockIterator([]byte{1, 2}, blockLayoutRestarts, compareInternalBytewise)
+	assert.Error(t, err)
+}
+
+func TestSSTable_ReadsFormatVersion1Blocks(t *testing.T) {
+	sst, err := openTestSSTable(t, writeTestSSTableVersion(t, formatVersion1))
+	assert.NoError(t, err)
+
+	it := sst.newIterator(blockReadOptions{verifyChecksums: true})
+	n := 0
+	for ok, err := it.Next(); ok; ok, err = it.Next() {
+		assert.NoError(t, err)
+		n++
+	}
+	assert.Equal(t, 101, n)
+
+	ok, err := it.Seek([]byte("key0050"))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("key0050"), it.Key())
+}
+
+func TestSSTable_PrefixBlocksAreSmaller(t *testing.T) {
+	v1, err := os.Stat(writeTestSSTableVersion(t, formatVersion1))
+	assert.NoError(t, err)
+	v2, err := os.Stat(writeTestSSTableVersion(t, formatVersion2))
+	assert.NoError(t, err)
+	assert.Less(t, v2.Size(), v1.Size())
+}
+
+func TestSSTable_ReadsEntryOffsetBlocks(t *testing.T) {
+	sst, err := openTestSSTable(t, writeTestSSTableVersion(t, formatVersion4))
+	assert.NoError(t, err)
+	assert.Equal(t, formatVersion4, sst.reader.footer.version)
+
+	for i := 1; i < 100; i++ {
+		val, err := sst.Get([]byte(fmt.Sprintf("key%04d", i)))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("value%04d", i)), val.Value())
+	}
+	val, err := sst.Get([]byte("key0000"))
+	assert.NoError(t, err)
+	assert.True(t, val.IsTombstone())
+	assert.NoError(t, sst.verifyChecksums())
+
+	it := sst.newIterator(blockReadOptions{verifyChecksums: true})
+	ok, err := it.Seek([]byte("key0050"))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("key0050"), it.Key())
+}
@@ -1,8 +1,10 @@
 package lsm
 
 import (
-	"github.com/bits-and-blooms/bloom/v3"
+	"math"
 	"sync"
+
+	"github.com/bits-and-blooms/bloom/v3"
 )
 
 type BloomFilter struct {
@@ -10,15 +12,26 @@ type BloomFilter struct {
 	filter *bloom.BloomFilter
 }
 
-func NewBloomFilter() *BloomFilter {
+// NewBloomFilter 는 numKeys 개의 key 에 key 당 bitsPerKey bit 를 쓰는 filter 를 만든다.
+// bitsPerKey 가 10 이면 false positive 비율은 1% 정도다
+func NewBloomFilter(numKeys uint, bitsPerKey int) *BloomFilter {
+	// hash 개수는 false positive 비율이 가장 낮은 bitsPerKey * ln2
+	k := uint(math.Round(float64(bitsPerKey) * math.Ln2))
+	k = max(1, min(k, 30))
+	// key 가 아주 적을 때 false positive 비율이 튀지 않도록 최소 크기를 둔다
+	m := max(numKeys*uint(bitsPerKey), 64)
 	return &BloomFilter{
 		mutex:  &sync.RWMutex{},
-		filter: bloom.NewWithEstimates(1000000, 0.01), // 1% false positive rate
+		filter: bloom.New(m, k),
 	}
 }
 
+// LoadBloomFilter 는 크기와 hash 개수도 data 에서 읽으므로 크기가 다른 이전 파일의 filter 도 읽을 수 있다
 func LoadBloomFilter(data []byte) (*BloomFilter, error) {
-	bf := NewBloomFilter()
+	bf := &BloomFilter{
+		mutex:  &sync.RWMutex{},
+		filter: &bloom.BloomFilter{},
+	}
 	err := bf.Load(data)
 	if err != nil {
 		return nil, err
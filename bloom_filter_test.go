@@ -0,0 +1,64 @@
+package lsm
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/bits-and-blooms/bloom/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeBloomTestSSTable(t *testing.T, numKeys int, opts *Options, level int) *SSTable {
+	path := writeTestSSTable(t, testSSTableSpec{
+		opts:    opts,
+		level:   level,
+		numKeys: numKeys,
+		value:   func(int) []byte { return []byte("v") },
+		// 같은 key 의 여러 version 은 filter 크기에 한 번만 센다
+		extra: func(m *Memtable) { m.Insert([]byte("key0000"), []byte("v2")) },
+	})
+	sst, err := openTestSSTable(t, path)
+	assert.NoError(t, err)
+	return sst
+}
+
+func TestBloomFilter_SizedToKeyCount(t *testing.T) {
+	small := writeBloomTestSSTable(t, 10, nil, 0)
+	large := writeBloomTestSSTable(t, 10000, nil, 0)
+
+	assert.Equal(t, uint64(10), small.reader.props.filterBits)
+	assert.Less(t, small.reader.props.filterSize, uint64(200))
+	assert.Equal(t, small.reader.footer.filter.length, small.reader.props.filterSize)
+	// key 당 10 bit = 1.25 byte
+	assert.InDelta(t, 12500, float64(large.reader.props.filterSize), 200)
+	assert.Equal(t, uint(10000*10), large.reader.bloomFilter.filter.Cap())
+}
+
+func TestBloomFilter_BitsPerKeyPerLevel(t *testing.T) {
+	opts, err := (&Options{BloomBitsPerKey: []int{5, 20}}).withDefaults()
+	assert.NoError(t, err)
+	l0 := writeBloomTestSSTable(t, 1000, opts, 0)
+	l3 := writeBloomTestSSTable(t, 1000, opts, 3)
+	assert.Equal(t, uint64(5), l0.reader.props.filterBits)
+	assert.Equal(t, uint64(20), l3.reader.props.filterBits)
+	assert.Equal(t, uint(1000*20), l3.reader.bloomFilter.filter.Cap())
+
+	for i := 0; i < 1000; i++ {
+		assert.True(t, l3.Contains([]byte(fmt.Sprintf("key%04d", i))))
+	}
+}
+
+func TestLoadBloomFilter_FixedSizeFilter(t *testing.T) {
+	// 이전 버전은 key 개수와 상관없이 1,000,000 key, 1% 크기로 filter 를 만들었다
+	old := bloom.NewWithEstimates(1000000, 0.01)
+	old.Add([]byte("key"))
+	data, err := old.GobEncode()
+	assert.NoError(t, err)
+
+	bf, err := LoadBloomFilter(data)
+	assert.NoError(t, err)
+	assert.Equal(t, old.Cap(), bf.filter.Cap())
+	assert.Equal(t, old.K(), bf.filter.K())
+	assert.True(t, bf.Contains([]byte("key")))
+	assert.False(t, bf.Contains([]byte("other")))
+}
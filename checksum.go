@@ -0,0 +1,150 @@
+package lsm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/gptjddldi/lsm/db/compression"
+)
+
+// 모든 SSTable block 뒤에는 block 내용의 CRC32C 가 붙는다
+const blockTrailerSize = 4
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrCorruption 은 디스크의 데이터가 깨졌을 때 반환된다. errors.Is 로 확인한다
+var ErrCorruption = errors.New("corruption")
+
+// CorruptionError 는 깨진 데이터가 있는 파일과 위치를 담는다
+type CorruptionError struct {
+	File   string
+	Offset int64
+	Reason string
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("corruption in %s at offset %d: %s", e.File, e.Offset, e.Reason)
+}
+
+func (e *CorruptionError) Is(target error) bool {
+	return target == ErrCorruption
+}
+
+func blockChecksum(contents []byte) uint32 {
+	return crc32.Checksum(contents, crcTable)
+}
+
+func newCorruptionError(file string, offset uint64, format string, args ...any) error {
+	return &CorruptionError{File: file, Offset: int64(offset), Reason: fmt.Sprintf(format, args...)}
+}
+
+func (r *tableReader) corruption(offset uint64, format string, args ...any) error {
+	return newCorruptionError(r.file.Name(), offset, format, args...)
+}
+
+// readBlock 은 offset 에서 length 만큼의 block 내용을 읽는다.
+// verify 가 true 면 block 뒤의 checksum 을 확인한다
+func (r *tableReader) readBlock(offset, length uint64, verify bool) ([]byte, error) {
+	buf := make([]byte, length+blockTrailerSize)
+	_, err := r.file.ReadAt(buf, int64(offset))
+	if err == io.EOF {
+		return nil, r.corruption(offset, "block of %d bytes exceeds file", length)
+	}
+	if err != nil {
+		return nil, err
+	}
+	contents := buf[:length]
+	if verify {
+		expected := binary.LittleEndian.Uint32(buf[length:])
+		if actual := blockChecksum(contents); actual != expected {
+			return nil, r.corruption(offset, "block checksum mismatch: expected %08x, got %08x", expected, actual)
+		}
+	}
+	return contents, nil
+}
+
+// readDataBlock 은 data block 을 읽고, format version 3 이상이면 compression type 에 따라 압축을 푼다
+func (r *tableReader) readDataBlock(offset, length uint64, verify bool) ([]byte, error) {
+	if r.footer.version < formatVersion3 {
+		return r.readBlock(offset, length, verify)
+	}
+	// compression type 을 내용에 붙여 읽으면 checksum 이 둘을 함께 덮는다
+	buf, err := r.readBlock(offset, length+1, verify)
+	if err != nil {
+		return nil, err
+	}
+	contents, typ := buf[:length], compression.Type(buf[length])
+	codec, ok := compression.Lookup(typ)
+	if !ok {
+		return nil, r.corruption(offset, "unknown compression type %v", typ)
+	}
+	if codec == nil {
+		return contents, nil
+	}
+	raw, err := codec.Decompress(contents)
+	if err != nil {
+		return nil, r.corruption(offset, "decompress %v block: %v", typ, err)
+	}
+	return raw, nil
+}
+
+// verifyChecksums 는 모든 meta block 과 data block 의 checksum 을 확인한다
+func (s *SSTable) verifyChecksums() error {
+	r, err := s.acquireReader()
+	if err != nil {
+		return err
+	}
+	defer r.unref()
+	return r.verifyChecksums()
+}
+
+func (r *tableReader) verifyChecksums() error {
+	meta := []blockHandle{r.footer.index, r.footer.filter}
+	if r.footer.version >= formatVersion1 {
+		meta = append(meta, r.footer.properties, r.footer.metaindex)
+	}
+	for _, h := range r.metaindex {
+		meta = append(meta, h)
+	}
+	for _, h := range meta {
+		if _, err := r.readBlock(h.offset, h.length, true); err != nil {
+			return err
+		}
+	}
+	for _, ie := range (*r.index).Entries() {
+		offset, length := ie.blockHandle()
+		// cache 를 거치지 않고 디스크의 block 을 확인한다
+		buf, err := r.readDataBlock(offset, length, true)
+		if err != nil {
+			return err
+		}
+		block, err := r.newBlockIterator(offset, buf)
+		if err != nil {
+			return err
+		}
+		for ok := block.SeekToFirst(); ok; ok = block.Next() {
+		}
+		if err = block.Err(); err != nil {
+			return r.corruption(offset, "%v", err)
+		}
+	}
+	return nil
+}
+
+// VerifyChecksums 는 현재 version 의 모든 SSTable 을 끝까지 읽어 checksum 을 확인한다
+func (db *DB) VerifyChecksums() error {
+	v := db.currentVersion()
+	defer v.unref()
+
+	for _, l := range v.levels {
+		for _, sst := range l.sstables {
+			if err := sst.verifyChecksums(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package lsm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gptjddldi/lsm/db/encoder"
+	"github.com/stretchr/testify/assert"
+)
+
+// testSSTableSpec 은 writeTestSSTable 로 쓸 SSTable. zero value 는 기본 설정으로 쓴 key0000 ~ key0099
+type testSSTableSpec struct {
+	opts    *Options           // withDefaults 를 거친 설정, nil 이면 DefaultOptions
+	version *uint32            // nil 이 아니면 opts 와 상관없이 이 format version 으로 쓴다
+	level   int                // 압축 방식과 bloom filter 설정을 고를 level
+	numKeys int                // key%04d 형식의 key 개수, 0 이면 100
+	value   func(i int) []byte // nil 이면 value%04d
+	extra   func(m *Memtable)  // key 를 넣은 뒤 memtable 에 더 넣을 entry
+}
+
+// writeTestSSTable 은 spec 의 SSTable 을 flush 와 같은 방식으로 써서 경로를 반환한다
+func writeTestSSTable(t *testing.T, spec testSSTableSpec) string {
+	opts := spec.opts
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+	if spec.numKeys == 0 {
+		spec.numKeys = 100
+	}
+	if spec.value == nil {
+		spec.value = func(i int) []byte { return []byte(fmt.Sprintf("value%04d", i)) }
+	}
+	memtable := NewMemtable(1<<30, opts.Comparator)
+	for i := 0; i < spec.numKeys; i++ {
+		memtable.Insert([]byte(fmt.Sprintf("key%04d", i)), spec.value(i))
+	}
+	if spec.extra != nil {
+		spec.extra(memtable)
+	}
+
+	path := filepath.Join(t.TempDir(), "0_000001.sst")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	writer := NewTempWriter(f, opts, spec.level)
+	if spec.version != nil {
+		writer.formatVersion = *spec.version
+	}
+	assert.NoError(t, writer.Write(memtableEntries(memtable)))
+	return path
+}
+
+func versionPtr(version uint32) *uint32 {
+	return &version
+}
+
+// smallBlockOptions 는 SSTable 이 여러 data block 으로 나뉘도록 block 크기를 줄인 설정
+func smallBlockOptions() *Options {
+	opts := DefaultOptions()
+	opts.BlockSize = 512
+	return opts
+}
+
+func flipByte(t *testing.T, path string, offset int64) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b := make([]byte, 1)
+	_, err = f.ReadAt(b, offset)
+	assert.NoError(t, err)
+	b[0] ^= 0xff
+	_, err = f.WriteAt(b, offset)
+	assert.NoError(t, err)
+}
+
+func openTestSSTable(t *testing.T, path string) (*SSTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return NewSSTable(f, nil)
+}
+
+func TestSSTable_DataBlockChecksum(t *testing.T) {
+	path := writeTestSSTable(t, testSSTableSpec{opts: smallBlockOptions(), numKeys: 500})
+	sst, err := openTestSSTable(t, path)
+	assert.NoError(t, err)
+	assert.NoError(t, sst.verifyChecksums())
+
+	// 두 번째 data block 의 첫 byte 를 깨뜨린다
+	entries := (*sst.reader.index).Entries()
+	offset, _ := entries[1].blockHandle()
+	lastKeyOfSecondBlock := encoder.UserKey(entries[1].key)
+	flipByte(t, path, int64(offset))
+
+	sst, err = openTestSSTable(t, path)
+	assert.NoError(t, err)
+
+	_, err = sst.getAt(lastKeyOfSecondBlock, encoder.MaxSequenceNumber, blockReadOptions{verifyChecksums: true})
+	assert.ErrorIs(t, err, ErrCorruption)
+	var corruption *CorruptionError
+	assert.True(t, errors.As(err, &corruption))
+	assert.Equal(t, path, corruption.File)
+	assert.Equal(t, int64(offset), corruption.Offset)
+
+	err = sst.verifyChecksums()
+	assert.ErrorIs(t, err, ErrCorruption)
+
+	it := sst.newIterator(blockReadOptions{verifyChecksums: true})
+	var iterErr error
+	for ok := true; ok && iterErr == nil; ok, iterErr = it.Next() {
+	}
+	assert.ErrorIs(t, iterErr, ErrCorruption)
+}
+
+func TestSSTable_IndexBlockChecksum(t *testing.T) {
+	path := writeTestSSTable(t, testSSTableSpec{opts: smallBlockOptions(), numKeys: 500})
+	sst, err := openTestSSTable(t, path)
+	assert.NoError(t, err)
+	flipByte(t, path, int64(sst.reader.footer.index.offset))
+	_, err = openTestSSTable(t, path)
+	assert.ErrorIs(t, err, ErrCorruption)
+}
+
+func TestDB_VerifyChecksums(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, &Options{BlockSize: 512})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 500; i++ {
+		assert.NoError(t, db.Insert([]byte(fmt.Sprintf("key%04d", i)), []byte(fmt.Sprintf("value%04d", i))))
+	}
+	db.Close()
+
+	db, err = Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, db.VerifyChecksums())
+	v := db.currentVersion()
+	sst := v.levels[0].sstables[0]
+	r, err := sst.acquireReader()
+	assert.NoError(t, err)
+	offset, _ := (*r.index).Entries()[1].blockHandle()
+	r.unref()
+	v.unref()
+	db.Close()
+
+	flipByte(t, sst.meta.Path(), int64(offset)+10)
+	db, err = Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	err = db.VerifyChecksums()
+	assert.ErrorIs(t, err, ErrCorruption)
+	assert.ErrorContains(t, err, sst.meta.Path())
+}
@@ -28,7 +28,7 @@ func (c *CLI) Start() {
 }
 
 func (c *CLI) printHelp() {
-	fmt.Println(`
+	fmt.Print(`
 DB CLI
 
 Available Commands:
@@ -71,7 +71,10 @@ func (c *CLI) processSetCommand(args []string) {
 		fmt.Println("Usage: SET <key> <value>")
 		return
 	}
-	c.db.Insert([]byte(args[0]), []byte(args[1]))
+	if err := c.db.Insert([]byte(args[0]), []byte(args[1])); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
 	fmt.Println("OK.")
 }
 
@@ -80,7 +83,10 @@ func (c *CLI) processDeleteCommand(args []string) {
 		fmt.Println("Usage: DEL <key>")
 		return
 	}
-	c.db.Delete([]byte(args[0]))
+	if err := c.db.Delete([]byte(args[0])); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
 	fmt.Println("OK.")
 }
 
@@ -1,121 +1,233 @@
 package lsm
 
-import (
-	"fmt"
-)
+import "sort"
 
-func (db *DB) compactLevel(level int) error {
-	if level == 0 {
-		return db.compactLevel0()
-	} else {
-		return db.compactLevelN(level)
+// compaction 은 깨진 데이터를 다음 level 로 옮기지 않도록 항상 checksum 을 확인하고,
+// 한 번 읽고 지울 input block 으로 block cache 를 밀어내지 않는다
+var compactionReadOptions = blockReadOptions{verifyChecksums: true}
+
+// compactionInput 은 한 level 에서 compaction 에 들어가는 SSTable 들
+type compactionInput struct {
+	level    int
+	sstables []*SSTable
+}
+
+// compaction 은 inputs 를 합쳐 outputLevel 에 쓴다
+type compaction struct {
+	inputs      []compactionInput
+	outputLevel int
+	cursor      []byte   // nil 이 아니면 inputs[0] level 의 compact cursor 를 옮긴다
+	version     *version // input 을 고른 version
+}
+
+func (c *compaction) iterators() []*SSTableIterator {
+	var iterators []*SSTableIterator
+	for _, in := range c.inputs {
+		for _, sst := range in.sstables {
+			iterators = append(iterators, sst.newIterator(compactionReadOptions))
+		}
 	}
+	return iterators
 }
 
-func (db *DB) compactLevel0() error {
-	iterators0, err := db.getIteratorsForLevel(0)
-	if err != nil {
-		return err
+// compactLevel 은 level 에서 진행 중인 compaction 과 겹치지 않는 input 을 골라 level+1 로 합친다.
+// UniversalCompaction 이면 level 과 상관없이 합칠 sorted run 을 고른다.
+// 고를 input 이 없으면 아무것도 하지 않는다
+func (db *DB) compactLevel(level int) error {
+	c := db.pickCompaction(level)
+	if c == nil {
+		return nil
 	}
+	defer db.releaseCompaction(c)
 
-	iterators1, err := db.getIteratorsForLevel(1)
+	sstList, err := db.mergeIterators(c.version, c.iterators(), c.outputLevel)
 	if err != nil {
 		return err
 	}
-
-	iterators := append(iterators0, iterators1...)
-	sstList, err := db.mergeIterators(iterators, 1)
-	if err != nil {
+	if err = db.installCompaction(c, sstList); err != nil {
 		return err
 	}
 
-	db.deleteSStableAtLevel(0, iterators0)
-	db.deleteSStableAtLevel(1, iterators1)
-	db.levels[1].sstables = append(db.levels[1].sstables, sstList...)
-
-	db.compactionChan <- 1
-	fmt.Println("Compaction END")
-
+	db.maybeScheduleCompaction()
 	return nil
 }
 
-func (db *DB) getIteratorsForLevel(level int) ([]*SSTableIterator, error) {
-	iterators := make([]*SSTableIterator, 0, len(db.levels[level].sstables))
-	for _, sstable := range db.levels[level].sstables {
-		iter, err := sstable.Iterator()
-		if err != nil {
-			return nil, err
+// installCompaction 은 input 삭제, output 추가, compact cursor 이동을 하나의 version edit 로 기록한다
+func (db *DB) installCompaction(c *compaction, outputs []*SSTable) error {
+	edit := &versionEdit{}
+	for _, in := range c.inputs {
+		for _, sst := range in.sstables {
+			edit.deleteTable(in.level, sst)
 		}
-		iterators = append(iterators, iter)
 	}
-	return iterators, nil
+	for _, sst := range outputs {
+		edit.addTable(c.outputLevel, sst)
+	}
+	if c.cursor != nil {
+		edit.setCompactCursor(c.inputs[0].level, c.cursor)
+	}
+	return db.logAndApply(edit)
 }
 
-func (db *DB) compactLevelN(level int) error {
-	fmt.Printf("level: %d, Compaction Start\n", level)
-
-	targetSst := db.LeastSstableAtLevel(level)
-	minKey, maxKey := targetSst.minKey, targetSst.maxKey
-
-	iterators, err := db.getCompactionIterators(level, targetSst, minKey, maxKey)
-	if err != nil {
-		return err
+// pickCompaction 은 level 의 input 을 골라 compaction 중으로 표시한다.
+// L0 는 SSTable 끼리 key 범위가 겹치므로 전부를, 다른 level 은 compact cursor 다음의 SSTable 하나를 고른다.
+// 진행 중인 compaction 의 input 과 겹치는 SSTable 은 고르지 않는다
+func (db *DB) pickCompaction(level int) *compaction {
+	db.compactionMu.Lock()
+	defer db.compactionMu.Unlock()
+
+	// 진행 중인 compaction 이 끝나며 지운 SSTable 을 고르지 않도록 lock 을 잡은 뒤 version 을 가져온다
+	v := db.currentVersion()
+	var c *compaction
+	switch {
+	case db.opts.CompactionStyle == UniversalCompaction:
+		c = db.pickUniversalCompaction(v)
+	case level == 0:
+		c = db.pickLevel0Compaction(v)
+	default:
+		c = db.pickLevelNCompaction(v, level)
 	}
-
-	sstList, err := db.mergeIterators(iterators, level+1)
-	if err != nil {
-		return err
+	if c == nil {
+		v.unref()
+		return nil
 	}
+	c.version = v
+	for _, in := range c.inputs {
+		for _, sst := range in.sstables {
+			db.compactingFiles[sst.meta.FileNum()] = true
+		}
+	}
+	return c
+}
 
-	db.deleteSStableAtLevel(level, iterators[:1])   // Delete from current level
-	db.deleteSStableAtLevel(level+1, iterators[1:]) // Delete from next level
-
-	db.levels[level+1].sstables = append(db.levels[level+1].sstables, sstList...)
-
-	db.compactionChan <- level + 1
-	fmt.Printf("level: %d, Compaction END\n", level)
+func (db *DB) pickLevel0Compaction(v *version) *compaction {
+	inputs := v.levels[0].sstables
+	if len(inputs) == 0 || db.anyCompacting(inputs) {
+		return nil
+	}
+	minKey, maxKey := inputs[0].minKey, inputs[0].maxKey
+	for _, sst := range inputs[1:] {
+		if db.opts.Comparator.Compare(sst.minKey, minKey) < 0 {
+			minKey = sst.minKey
+		}
+		if db.opts.Comparator.Compare(sst.maxKey, maxKey) > 0 {
+			maxKey = sst.maxKey
+		}
+	}
+	next := v.overlappingTables(1, minKey, maxKey)
+	if db.anyCompacting(next) {
+		return nil
+	}
+	return &compaction{
+		inputs:      []compactionInput{{0, inputs}, {1, next}},
+		outputLevel: 1,
+	}
+}
 
+// pickLevelNCompaction 은 key 순서로 compact cursor 다음부터 한 바퀴 돌며 처음으로 고를 수 있는 SSTable 을 고른다
+func (db *DB) pickLevelNCompaction(v *version, level int) *compaction {
+	sstables := append([]*SSTable(nil), v.levels[level].sstables...)
+	if len(sstables) == 0 {
+		return nil
+	}
+	cmp := db.opts.Comparator
+	sort.Slice(sstables, func(i, j int) bool {
+		return cmp.Compare(sstables[i].minKey, sstables[j].minKey) < 0
+	})
+
+	start := 0
+	if cursor := v.compactCursors[level]; cursor != nil {
+		start = sort.Search(len(sstables), func(i int) bool {
+			return cmp.Compare(sstables[i].minKey, cursor) > 0
+		})
+	}
+	for i := 0; i < len(sstables); i++ {
+		sst := sstables[(start+i)%len(sstables)]
+		if db.compactingFiles[sst.meta.FileNum()] {
+			continue
+		}
+		next := v.overlappingTables(level+1, sst.minKey, sst.maxKey)
+		if db.anyCompacting(next) {
+			continue
+		}
+		return &compaction{
+			inputs:      []compactionInput{{level, []*SSTable{sst}}, {level + 1, next}},
+			outputLevel: level + 1,
+			cursor:      sst.maxKey,
+		}
+	}
 	return nil
 }
 
-func (db *DB) getCompactionIterators(level int, targetSst *SSTable, minKey, maxKey []byte) ([]*SSTableIterator, error) {
-	iter, err := targetSst.Iterator()
-	if err != nil {
-		return nil, err
+// releaseCompaction 은 compaction 이 끝난 input 의 표시를 지운다
+func (db *DB) releaseCompaction(c *compaction) {
+	db.compactionMu.Lock()
+	for _, in := range c.inputs {
+		for _, sst := range in.sstables {
+			delete(db.compactingFiles, sst.meta.FileNum())
+		}
 	}
+	db.compactionMu.Unlock()
+	c.version.unref()
+}
 
-	involvedIter, err := db.involvedIterators(level+1, minKey, maxKey)
-	if err != nil {
-		return nil, err
+// anyCompacting 은 compactionMu 를 잡은 상태에서 호출된다
+func (db *DB) anyCompacting(sstables []*SSTable) bool {
+	for _, sst := range sstables {
+		if db.compactingFiles[sst.meta.FileNum()] {
+			return true
+		}
 	}
+	return false
+}
 
-	return append([]*SSTableIterator{iter}, involvedIter...), nil
+// compactionScore 는 level 이 compaction 을 얼마나 필요로 하는지 나타낸다. 1 이상이면 compaction 한다.
+// L0 는 파일 수를 L0CompactionTrigger 로, 나머지 level 은 크기를 목표 크기로 나눈 값이다.
+// UniversalCompaction 은 sorted run 수를 L0CompactionTrigger 로 나눈 값을 L0 의 score 로 쓴다
+func (v *version) compactionScore(level int, opts *Options) float64 {
+	if opts.CompactionStyle == UniversalCompaction {
+		if level > 0 {
+			return 0
+		}
+		return float64(len(v.sortedRuns())) / float64(opts.L0CompactionTrigger)
+	}
+	// 마지막 level 은 내려보낼 곳이 없다
+	if level >= opts.MaxLevels-1 {
+		return 0
+	}
+	if level == 0 {
+		return float64(len(v.levels[0].sstables)) / float64(opts.L0CompactionTrigger)
+	}
+	return float64(v.levels[level].TotalSize()) / float64(opts.levelMaxBytes(level))
 }
 
-func (db *DB) needLevelNCompaction(level int) bool {
+// pickCompactionLevel 은 compaction 중이 아닌 level 중 score 가 1 이상이면서 가장 높은 level 을 고른다. 없으면 -1
+func (db *DB) pickCompactionLevel() int {
 	db.compactionMu.RLock()
 	defer db.compactionMu.RUnlock()
 
-	if db.isCompacting[level] {
-		return false
-	}
+	v := db.currentVersion()
+	defer v.unref()
 
-	if level == 0 {
-		return len(db.levels[0].sstables) >= l0Capacity
+	best, bestScore := -1, 0.0
+	for level := 0; level < db.opts.MaxLevels; level++ {
+		if db.isCompacting[level] {
+			continue
+		}
+		if score := v.compactionScore(level, db.opts); score >= 1 && score > bestScore {
+			best, bestScore = level, score
+		}
 	}
-
-	return db.levels[level].TotalSize() > calculateLevelSize(level)
+	return best
 }
-func (db *DB) involvedIterators(level int, minKey, maxKey []byte) ([]*SSTableIterator, error) {
-	iterators := make([]*SSTableIterator, 0)
-	for _, sstable := range db.levels[level].sstables {
-		if sstable.IsInKeyRange(minKey, maxKey) {
-			iter, err := sstable.Iterator()
-			if err != nil {
-				return nil, err
-			}
-			iterators = append(iterators, iter)
+
+// overlappingTables 는 level 에서 [minKey, maxKey] 와 key 범위가 겹치는 SSTable 들
+func (v *version) overlappingTables(level int, minKey, maxKey []byte) []*SSTable {
+	var sstables []*SSTable
+	for _, sst := range v.levels[level].sstables {
+		if sst.IsInKeyRange(minKey, maxKey) {
+			sstables = append(sstables, sst)
 		}
 	}
-	return iterators, nil
+	return sstables
 }
@@ -0,0 +1,131 @@
+package lsm
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_PickCompactionLevelByScore(t *testing.T) {
+	opts, err := (&Options{MemtableSize: 100, MaxLevels: 4, L0CompactionTrigger: 2, LevelSizeMultiplier: 10}).withDefaults()
+	assert.NoError(t, err)
+	levels := emptyLevels(4)
+	db := &DB{opts: opts, isCompacting: make([]bool, 4), current: newVersion(levels)}
+
+	// L1 목표 크기 2000, L2 목표 크기 20000
+	levels[0].sstables = []*SSTable{{}}
+	levels[1].sstables = []*SSTable{{size: 1000}}
+	levels[3].sstables = []*SSTable{{size: 1 << 30}}
+	assert.Equal(t, -1, db.pickCompactionLevel())
+
+	levels[0].sstables = append(levels[0].sstables, &SSTable{}, &SSTable{})
+	levels[1].sstables = append(levels[1].sstables, &SSTable{size: 2000})
+	assert.Equal(t, 1.5, db.current.compactionScore(0, opts))
+	assert.Equal(t, 1.5, db.current.compactionScore(1, opts))
+	assert.Equal(t, 0.0, db.current.compactionScore(3, opts))
+	// score 가 같으면 위 level 이 먼저다
+	assert.Equal(t, 0, db.pickCompactionLevel())
+
+	levels[2].sstables = []*SSTable{{size: 40000}}
+	assert.Equal(t, 2, db.pickCompactionLevel())
+	db.isCompacting[2] = true
+	assert.Equal(t, 0, db.pickCompactionLevel())
+}
+
+// buildLevel1 은 겹치지 않는 key 범위의 SSTable n 개를 L1 에 만든다
+func buildLevel1(t *testing.T, db *DB, n int) {
+	for i := 0; i < n; i++ {
+		for j := 0; j < 10; j++ {
+			assert.NoError(t, db.Insert([]byte(fmt.Sprintf("key%02d-%02d", i, j)), []byte("value")))
+		}
+		flushMutable(t, db)
+		assert.NoError(t, db.compactLevel(0))
+	}
+}
+
+func compactionTestOptions() *Options {
+	return &Options{MaxLevels: 3, L0CompactionTrigger: 100}
+}
+
+func TestDB_CompactionCursorRoundRobin(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, compactionTestOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	buildLevel1(t, db, 3)
+
+	v := db.currentVersion()
+	assert.Len(t, v.levels[1].sstables, 3)
+	assert.Nil(t, v.compactCursors[1])
+	v.unref()
+
+	for _, want := range []string{"key00-09", "key01-09"} {
+		assert.NoError(t, db.compactLevel(1))
+		v = db.currentVersion()
+		assert.Equal(t, []byte(want), v.compactCursors[1])
+		v.unref()
+	}
+	db.Close()
+
+	// 다시 열어도 cursor 가 남아 있어서 아직 내려가지 않은 마지막 SSTable 을 고른다
+	db, err = Open(dir, compactionTestOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	v = db.currentVersion()
+	assert.Equal(t, []byte("key01-09"), v.compactCursors[1])
+	v.unref()
+
+	c := db.pickCompaction(1)
+	if assert.NotNil(t, c) {
+		assert.Equal(t, []byte("key02-00"), c.inputs[0].sstables[0].minKey)
+		db.releaseCompaction(c)
+	}
+
+	// 한 바퀴 돌면 처음으로 돌아간다
+	assert.NoError(t, db.compactLevel(1))
+	assert.NoError(t, db.Insert([]byte("key00-05"), []byte("value")))
+	flushMutable(t, db)
+	assert.NoError(t, db.compactLevel(0))
+	c = db.pickCompaction(1)
+	if assert.NotNil(t, c) {
+		assert.Equal(t, []byte("key00-05"), c.inputs[0].sstables[0].minKey)
+		db.releaseCompaction(c)
+	}
+}
+
+func TestDB_PickCompactionSkipsCompactingFiles(t *testing.T) {
+	db, err := Open(t.TempDir(), compactionTestOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	buildLevel1(t, db, 3)
+
+	first := db.pickCompaction(1)
+	if !assert.NotNil(t, first) {
+		return
+	}
+	second := db.pickCompaction(1)
+	if assert.NotNil(t, second) {
+		assert.NotEqual(t, first.inputs[0].sstables, second.inputs[0].sstables)
+	}
+
+	// L0 의 key 범위가 compaction 중인 L1 SSTable 과 겹치면 L0 compaction 을 고르지 않는다
+	assert.NoError(t, db.Insert(first.inputs[0].sstables[0].minKey, []byte("value")))
+	flushMutable(t, db)
+	assert.Nil(t, db.pickCompaction(0))
+
+	db.releaseCompaction(first)
+	c := db.pickCompaction(0)
+	if assert.NotNil(t, c) {
+		assert.Equal(t, first.inputs[0].sstables, c.inputs[1].sstables)
+		db.releaseCompaction(c)
+	}
+	if second != nil {
+		db.releaseCompaction(second)
+	}
+}
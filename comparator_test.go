@@ -0,0 +1,91 @@
+package lsm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/gptjddldi/lsm/db/compare"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComparator_ReverseOrder(t *testing.T) {
+	opts := &Options{Comparator: compare.ReverseBytewise, L0CompactionTrigger: 2}
+	db, err := Open(t.TempDir(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for f := 0; f < 3; f++ {
+		for i := f; i < 30; i += 3 {
+			assert.NoError(t, db.Insert([]byte(fmt.Sprintf("key%02d", i)), []byte(fmt.Sprintf("value%02d", i))))
+		}
+		flushMutable(t, db)
+	}
+	assert.NoError(t, db.compactLevel(0))
+	assert.NoError(t, db.Insert([]byte("key15"), []byte("new")))
+
+	val, err := db.Get([]byte("key15"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("new"), val)
+	val, err = db.Get([]byte("key07"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value07"), val)
+
+	it := db.NewIterator(&ReadOptions{LowerBound: []byte("key20"), UpperBound: []byte("key10")})
+	defer it.Close()
+	var got []string
+	for ok := it.SeekToFirst(); ok; ok = it.Next() {
+		got = append(got, string(it.Key()))
+	}
+	assert.NoError(t, it.Error())
+	assert.Len(t, got, 10)
+	assert.Equal(t, "key20", got[0])
+	assert.Equal(t, "key11", got[9])
+}
+
+func TestComparator_BigEndianUint64(t *testing.T) {
+	db, err := Open(t.TempDir(), &Options{Comparator: compare.BigEndianUint64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, v := range []uint64{300, 1, 1 << 40, 2} {
+		key := binary.BigEndian.AppendUint64(nil, v)
+		assert.NoError(t, db.Insert(key, key))
+	}
+	flushMutable(t, db)
+
+	it := db.NewIterator(nil)
+	defer it.Close()
+	var got []uint64
+	for ok := it.SeekToFirst(); ok; ok = it.Next() {
+		got = append(got, binary.BigEndian.Uint64(it.Key()))
+	}
+	assert.Equal(t, []uint64{1, 2, 300, 1 << 40}, got)
+}
+
+func TestComparator_MismatchAtOpen(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, &Options{Comparator: compare.ReverseBytewise})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, db.Insert([]byte("key"), []byte("value")))
+	flushMutable(t, db)
+	db.Close()
+
+	_, err = Open(dir, nil)
+	assert.ErrorIs(t, err, ErrComparatorMismatch)
+
+	db, err = Open(dir, &Options{Comparator: compare.ReverseBytewise})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	val, err := db.Get([]byte("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value"), val)
+}
@@ -0,0 +1,90 @@
+package lsm
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/gptjddldi/lsm/db/compression"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeCompressedSSTable(t *testing.T, typ compression.Type, value func(i int) []byte) *SSTable {
+	opts := DefaultOptions()
+	opts.Compression = []compression.Type{typ}
+	sst, err := openTestSSTable(t, writeTestSSTable(t, testSSTableSpec{opts: opts, numKeys: 300, value: value}))
+	assert.NoError(t, err)
+	return sst
+}
+
+// dataBlockBytes 는 data block 이 디스크에서 차지하는 크기의 합
+func dataBlockBytes(sst *SSTable) uint64 {
+	var total uint64
+	for _, ie := range (*sst.reader.index).Entries() {
+		_, length := ie.blockHandle()
+		total += length
+	}
+	return total
+}
+
+func jsonValue(i int) []byte {
+	return []byte(fmt.Sprintf(`{"id":%d,"status":"active","plan":"enterprise","region":"ap-northeast-2"}`, i))
+}
+
+// dataBlockCompression 은 첫 data block 에 기록된 compression type 을 읽는다
+func dataBlockCompression(t *testing.T, sst *SSTable) compression.Type {
+	offset, length := (*sst.reader.index).FirstEntry().blockHandle()
+	buf, err := sst.reader.readBlock(offset, length+1, true)
+	assert.NoError(t, err)
+	return compression.Type(buf[length])
+}
+
+func TestSSTable_CompressedBlocks(t *testing.T) {
+	rawSize := dataBlockBytes(writeCompressedSSTable(t, compression.None, jsonValue))
+	for _, typ := range []compression.Type{compression.Flate, compression.LZ} {
+		sst := writeCompressedSSTable(t, typ, jsonValue)
+		assert.Equal(t, typ, dataBlockCompression(t, sst))
+		assert.Less(t, dataBlockBytes(sst), rawSize/2, "%v", typ)
+
+		val, err := sst.Get([]byte("key0123"))
+		assert.NoError(t, err)
+		assert.Equal(t, jsonValue(123), val.Value())
+		assert.NoError(t, sst.verifyChecksums())
+
+		it := sst.newIterator(blockReadOptions{verifyChecksums: true})
+		n := 0
+		for ok, err := it.Next(); ok; ok, err = it.Next() {
+			assert.NoError(t, err)
+			assert.Equal(t, jsonValue(n), it.Value())
+			n++
+		}
+		assert.Equal(t, 300, n)
+	}
+}
+
+func TestSSTable_IncompressibleBlocksStoredRaw(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	values := make([][]byte, 300)
+	for i := range values {
+		values[i] = make([]byte, 100)
+		rnd.Read(values[i])
+	}
+	sst := writeCompressedSSTable(t, compression.Flate, func(i int) []byte { return values[i] })
+	assert.Equal(t, compression.None, dataBlockCompression(t, sst))
+
+	val, err := sst.Get([]byte("key0007"))
+	assert.NoError(t, err)
+	assert.Equal(t, values[7], val.Value())
+}
+
+func TestOptions_CompressionPerLevel(t *testing.T) {
+	opts, err := (&Options{Compression: []compression.Type{compression.None, compression.LZ, compression.Flate}}).withDefaults()
+	assert.NoError(t, err)
+	assert.Equal(t, compression.None, opts.compression(0))
+	assert.Equal(t, compression.LZ, opts.compression(1))
+	assert.Equal(t, compression.Flate, opts.compression(2))
+	assert.Equal(t, compression.Flate, opts.compression(6))
+
+	_, err = (&Options{Compression: []compression.Type{42}}).withDefaults()
+	assert.Error(t, err)
+}
@@ -1,55 +1,84 @@
 package lsm
 
 import (
-	"bufio"
 	"context"
-	"encoding/binary"
 	"errors"
 	"fmt"
-	"io"
 	"log"
-	"math/rand"
-	"os"
 	"sync"
-	"time"
+	"sync/atomic"
 
+	"github.com/gptjddldi/lsm/db/cache"
 	"github.com/gptjddldi/lsm/db/encoder"
 	"github.com/gptjddldi/lsm/db/storage"
 )
 
-const (
-	memtableSizeLimitBytes = 10 << 20 // 10MB
-)
-
 var ErrorKeyNotFound = errors.New("key not found")
 
 type DataEntry struct {
-	key    []byte
+	key    []byte // user key
 	value  []byte
 	opType encoder.OpType
+	seq    uint64
+}
+
+func (de *DataEntry) internalKey() []byte {
+	return encoder.MakeInternalKey(de.key, de.seq, de.opType)
 }
 
+// DB 는 여러 goroutine 에서 동시에 사용할 수 있다.
+//
+//   - Insert / Delete / Write 는 writeMu 로 직렬화되고, 1 씩 증가하는 sequence 를 받아
+//     WAL 과 memtable 에 순서대로 반영된다.
+//   - Get 은 mu 를 잠깐 잡고 memtable 목록과 현재 version 을 가져온 뒤, 잠금 없이 읽는다.
+//     version 은 바뀌지 않으므로 읽는 도중 flush / compaction 이 끝나도
+//     반쯤 바뀐 level 을 보지 않는다.
+//   - flush / compaction 은 manifest 에 edit 를 기록하고 (versionMu),
+//     mu 를 잡은 상태에서 새 version 을 설치한다.
+//   - 지워진 SSTable 파일은 그 파일을 참조하는 마지막 version 이 해제될 때 삭제된다.
+//   - compaction 은 살아있는 Snapshot 에 보이는 entry 를 지우지 않는다.
+//
+// Close 이후에는 어떤 메서드도 호출하면 안 된다.
 type DB struct {
 	dataStorage *storage.Provider
-	memtables   struct {
+
+	mu        sync.RWMutex // memtables, current 를 보호한다
+	memtables struct {
 		mutable *Memtable
 		queue   []*Memtable // to be flushed
 	}
+	current   *version
+	snapshots snapshotList // mu 로 보호된다
+
+	writeMu   sync.Mutex
+	lastSeq   atomic.Uint64 // 마지막으로 쓰인 sequence. writeMu 를 잡고 증가시킨다
+	versionMu sync.Mutex    // manifest 기록과 version 설치를 직렬화한다
+
 	flushingChan chan *Memtable
-	levels       []*level
 
-	compactionChan chan int
+	compactionChan chan struct{} // level 의 score 를 다시 볼 때가 되었다는 신호
 
-	wg           sync.WaitGroup
-	ctx          context.Context
-	cancel       context.CancelFunc
-	isCompacting []bool
-	compactionMu sync.RWMutex
+	wg              sync.WaitGroup
+	ctx             context.Context
+	cancel          context.CancelFunc
+	isCompacting    []bool
+	compactingFiles map[int]bool // 진행 중인 compaction 의 input file number
+	compactionMu    sync.RWMutex
 
-	useLearnedIndex bool
+	manifest *manifest
+
+	blockCache *cache.Cache // nil 이면 block cache 를 쓰지 않는다
+	tables     *tableCache
+
+	opts *Options
 }
 
-func Open(dirname string, useLearnedIndex bool) (*DB, error) {
+// Open 은 dirname 의 DB 를 연다. opts 가 nil 이면 DefaultOptions 를 사용한다
+func Open(dirname string, opts *Options) (*DB, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 
 	dataStorage, err := storage.NewProvider(dirname)
@@ -60,26 +89,32 @@ func Open(dirname string, useLearnedIndex bool) (*DB, error) {
 
 	db := &DB{
 		dataStorage:     dataStorage,
-		compactionChan:  make(chan int, 1000),
+		compactionChan:  make(chan struct{}, 1),
 		flushingChan:    make(chan *Memtable, 1000),
 		ctx:             ctx,
 		cancel:          cancel,
-		isCompacting:    make([]bool, maxLevel),
-		useLearnedIndex: useLearnedIndex,
+		isCompacting:    make([]bool, opts.MaxLevels),
+		compactingFiles: make(map[int]bool),
+		opts:            opts,
+	}
+	db.snapshots.init()
+	if opts.BlockCacheSize > 0 {
+		db.blockCache = cache.New(opts.BlockCacheSize)
 	}
+	db.tables = newTableCache(opts.MaxOpenFiles, db.blockCache, opts)
 
-	levels := make([]*level, maxLevel)
-	for i := 0; i < maxLevel; i++ {
-		levels[i] = &level{
-			sstables: make([]*SSTable, 0),
-		}
+	err = db.recoverVersion()
+	if err != nil {
+		return nil, err
 	}
-	db.levels = levels
-	err = db.loadSSTFilesFromDisk()
+	err = db.recoverWALs()
+	if err != nil {
+		return nil, err
+	}
+	db.memtables.mutable, err = db.newMemtable()
 	if err != nil {
 		return nil, err
 	}
-	db.memtables.mutable = NewMemtable(memtableSizeLimitBytes, useLearnedIndex)
 
 	db.wg.Add(2)
 	go db.doCompaction()
@@ -89,10 +124,22 @@ func Open(dirname string, useLearnedIndex bool) (*DB, error) {
 }
 
 func (db *DB) Close() {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
 	// Flush the current mutable memtable
-	if db.memtables.mutable.Size() > 0 {
-		db.flushingChan <- db.memtables.mutable
-		db.memtables.mutable = NewMemtable(memtableSizeLimitBytes, db.useLearnedIndex)
+	db.mu.Lock()
+	m := db.memtables.mutable
+	if m.Size() > 0 {
+		db.memtables.queue = append(db.memtables.queue, m)
+	}
+	db.memtables.mutable = NewMemtable(db.opts.MemtableSize, db.opts.Comparator)
+	db.mu.Unlock()
+
+	if m.Size() > 0 {
+		db.flushingChan <- m
+	} else if err := db.removeWAL(m); err != nil {
+		log.Printf("Error removing wal: %v", err)
 	}
 
 	// Trigger final compactions
@@ -107,6 +154,12 @@ func (db *DB) Close() {
 	// Close channels
 	close(db.flushingChan)
 	close(db.compactionChan)
+
+	if err := db.manifest.writer.Close(); err != nil {
+		log.Printf("Error closing manifest: %v", err)
+	}
+
+	db.tables.close()
 }
 
 func (db *DB) doCompaction() {
@@ -116,20 +169,32 @@ func (db *DB) doCompaction() {
 		case <-db.ctx.Done():
 			db.processRemainingCompactions()
 			return
-		case l := <-db.compactionChan:
-			if db.needLevelNCompaction(l) {
-				db.processCompaction(l)
-			}
+		case <-db.compactionChan:
+			db.maybeCompact()
 		}
 	}
 }
 
 func (db *DB) processRemainingCompactions() {
 	for len(db.compactionChan) > 0 {
-		l := <-db.compactionChan
-		if db.needLevelNCompaction(l) {
-			db.processCompaction(l)
-		}
+		<-db.compactionChan
+		db.maybeCompact()
+	}
+}
+
+// maybeCompact 는 score 가 가장 높은 level 을 compaction 한다.
+// compaction 이 끝나면 다시 신호를 보내므로 score 가 1 아래로 내려갈 때까지 이어진다
+func (db *DB) maybeCompact() {
+	if l := db.pickCompactionLevel(); l >= 0 {
+		db.processCompaction(l)
+	}
+}
+
+// maybeScheduleCompaction 은 compaction goroutine 에 신호를 보낸다. 이미 보낸 신호가 남아 있으면 그것으로 충분하다
+func (db *DB) maybeScheduleCompaction() {
+	select {
+	case db.compactionChan <- struct{}{}:
+	default:
 	}
 }
 
@@ -180,44 +245,124 @@ func (db *DB) processFlush(m *Memtable) {
 }
 
 func (db *DB) checkAndTriggerCompaction() bool {
-	readyToExit := true
+	if db.pickCompactionLevel() < 0 {
+		return true
+	}
+	db.maybeScheduleCompaction()
+	return false
+}
+
+// Insert 는 WAL 에 기록된 뒤에 memtable 에 반영된다
+func (db *DB) Insert(key, val []byte) error {
+	batch := NewWriteBatch()
+	batch.Put(key, val)
+	return db.Write(batch, nil)
+}
+
+type WriteOptions struct {
+	Sync bool // true 면 WALOptions.SyncMode 와 상관없이 WAL 을 fsync 한 뒤 반환한다
+}
+
+// Write 는 batch 를 하나의 WAL record 로 기록하고 memtable 에 반영한다.
+// batch 의 쓰기는 연속된 sequence 를 받고, 마지막 sequence 가 공개되기 전까지는 읽기에 보이지 않는다
+func (db *DB) Write(batch *WriteBatch, opts *WriteOptions) error {
+	if batch.Count() == 0 {
+		return nil
+	}
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
 
-	for idx := range db.levels {
-		if db.needLevelNCompaction(idx) {
-			db.compactionChan <- idx
-			readyToExit = false
+	m, err := db.prepMemtableForBatch(batch)
+	if err != nil {
+		return err
+	}
+	seq := db.lastSeq.Load() + 1
+	batch.setSequence(seq)
+	if m.wal != nil {
+		if err := m.wal.Append(batch.Repr()); err != nil {
+			return err
 		}
+		if opts != nil && opts.Sync {
+			if err := m.wal.Sync(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := batch.insertInto(m); err != nil {
+		return err
 	}
-	return readyToExit
+	db.lastSeq.Store(seq + uint64(batch.Count()) - 1)
+	return nil
 }
 
-func (db *DB) Insert(key, val []byte) {
-	db.prepMemtableForKV(key, val)
-	db.memtables.mutable.Insert(key, val)
+// readState 는 Get 이 읽는 memtable 과 version 의 묶음
+type readState struct {
+	mutable *Memtable
+	queue   []*Memtable
+	version *version
+}
+
+func (db *DB) getReadState() *readState {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	db.current.ref()
+	return &readState{
+		mutable: db.memtables.mutable,
+		queue:   append([]*Memtable(nil), db.memtables.queue...),
+		version: db.current,
+	}
 }
 
 func (db *DB) Get(key []byte) ([]byte, error) {
-	encodedValue, err := db.memtables.mutable.Get(key)
-	if err != nil && errors.Is(err, ErrorKeyNotFound) {
-		return nil, err
-	} else if err == nil {
+	return db.GetWithOptions(key, nil)
+}
+
+// GetWithOptions 는 opts.Snapshot 이 있으면 그 snapshot 시점의 값을 반환한다
+func (db *DB) GetWithOptions(key []byte, opts *ReadOptions) ([]byte, error) {
+	ro := newBlockReadOptions(opts)
+	// read state 를 먼저 잡아야 그 사이에 끝난 flush / compaction 이 이 sequence 에 필요한 entry 를 지우지 않는다
+	rs := db.getReadState()
+	defer rs.version.unref()
+	// 진행 중인 batch 가 일부만 보이지 않도록 공개된 sequence 까지만 읽는다
+	seq := db.lastSeq.Load()
+	if opts != nil && opts.Snapshot != nil {
+		seq = opts.Snapshot.seq
+	}
+
+	encodedValue, err := rs.mutable.get(key, seq)
+	if err == nil {
 		return db.handleEncodedValue(encodedValue)
 	}
-	for i := len(db.memtables.queue) - 1; i >= 0; i-- {
-		m := db.memtables.queue[i]
-		encodedValue, err = m.Get(key)
+	for i := len(rs.queue) - 1; i >= 0; i-- {
+		m := rs.queue[i]
+		encodedValue, err = m.get(key, seq)
 		if err != nil {
 			continue
 		} // Only NotFound error is expected
 		return db.handleEncodedValue(encodedValue)
 	}
-	for level := range db.levels {
-		for _, sstable := range db.levels[level].sstables {
-			encodedValue, err := sstable.Get(key)
+	for level, l := range rs.version.levels {
+		// L0 의 SSTable 은 key 범위가 겹치므로 sequence 가 가장 큰 entry 를 고른다.
+		// 그 아래 level 은 key 범위가 겹치지 않으므로 처음 찾은 entry 가 가장 최근 값이다
+		var newest *encoder.EncodedValue
+		for _, sstable := range l.sstables {
+			encodedValue, err := sstable.getAt(key, seq, ro)
+			if errors.Is(err, ErrorKeyNotFound) {
+				continue
+			}
 			if err != nil {
-				continue // Only NotFound error is expected
+				return nil, err
+			}
+			if newest == nil || encodedValue.Seq > newest.Seq {
+				newest = encodedValue
+			}
+			if level > 0 {
+				break
 			}
-			return db.handleEncodedValue(encodedValue)
+		}
+		if newest != nil {
+			return db.handleEncodedValue(newest)
 		}
 	}
 	return nil, ErrorKeyNotFound
@@ -230,21 +375,34 @@ func (db *DB) handleEncodedValue(encodedValue *encoder.EncodedValue) ([]byte, er
 	return encodedValue.Value(), nil
 }
 
-func (db *DB) Delete(key []byte) {
-	db.prepMemtableForKV(key, nil)
-	db.memtables.mutable.InsertTombstone(key)
+func (db *DB) Delete(key []byte) error {
+	batch := NewWriteBatch()
+	batch.Delete(key)
+	return db.Write(batch, nil)
 }
 
-func (db *DB) prepMemtableForKV(key, val []byte) {
-	if !db.memtables.mutable.HasRoomForWrite(key, val) {
-		db.memtables.queue = append(db.memtables.queue, db.memtables.mutable)
-		db.flushingChan <- db.memtables.queue[0]
+// prepMemtableForBatch 는 writeMu 를 잡은 상태에서 호출되고, batch 전체를 받을 memtable 을 반환한다.
+// memtable 크기보다 큰 batch 는 빈 memtable 에 통째로 들어간다
+func (db *DB) prepMemtableForBatch(batch *WriteBatch) (*Memtable, error) {
+	db.mu.RLock()
+	mutable := db.memtables.mutable
+	db.mu.RUnlock()
 
-		if len(db.memtables.queue) > 1 {
-			db.memtables.queue = db.memtables.queue[1:]
-		}
-		db.memtables.mutable = NewMemtable(memtableSizeLimitBytes, db.useLearnedIndex)
+	if mutable.Size() == 0 || mutable.hasRoomFor(batch.memSize) {
+		return mutable, nil
 	}
+	m, err := db.newMemtable()
+	if err != nil {
+		return nil, err
+	}
+	// queue 에 남아있는 memtable 은 flush 가 끝나면 flushMemtable 에서 빠진다
+	db.mu.Lock()
+	db.memtables.queue = append(db.memtables.queue, mutable)
+	db.memtables.mutable = m
+	db.mu.Unlock()
+
+	db.flushingChan <- mutable
+	return m, nil
 }
 
 func (db *DB) flushMemtable(m *Memtable) error {
@@ -253,25 +411,51 @@ func (db *DB) flushMemtable(m *Memtable) error {
 	if err != nil {
 		return err
 	}
+	defer f.Close()
 
-	flusher := NewFlusher(m, f)
+	flusher := NewFlusher(m, f, db.opts)
 	if err = flusher.Flush(); err != nil {
 		return err
 	}
+	// WAL 을 지우기 전에 SSTable 이 디스크에 내려가 있어야 한다
+	if err = f.Sync(); err != nil {
+		return err
+	}
 
-	sst, err := db.OpenSSTable(f)
+	sst, err := db.openSSTable(meta)
 	if err != nil {
 		return err
 	}
 
-	db.levels[0].sstables = append(db.levels[0].sstables, sst)
+	edit := &versionEdit{flushedMemtable: m}
+	edit.addTable(0, sst)
+	if m.walFileNum > 0 {
+		edit.setLogNumber(m.walFileNum + 1)
+	}
+	if err = db.logAndApply(edit); err != nil {
+		return err
+	}
+
+	if err = db.removeWAL(m); err != nil {
+		return err
+	}
 
-	db.compactionChan <- 0
+	db.maybeScheduleCompaction()
 
 	return nil
 }
 
-func (db *DB) loadSSTFilesFromDisk() error {
+// removeFlushedMemtable 은 db.mu 를 잡은 상태에서 호출된다
+func (db *DB) removeFlushedMemtable(m *Memtable) {
+	for i, queued := range db.memtables.queue {
+		if queued == m {
+			db.memtables.queue = append(db.memtables.queue[:i:i], db.memtables.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+func (db *DB) loadSSTFilesFromDisk(levels []*level) error {
 	files, err := db.dataStorage.ListFiles()
 	if err != nil {
 		return err
@@ -280,106 +464,50 @@ func (db *DB) loadSSTFilesFromDisk() error {
 		if !f.IsSSTable() {
 			continue
 		}
-		sst, err := db.OpenSSTableByFileName(f.Path())
+		sst, err := db.openSSTable(f)
 		if err != nil {
 			return err
 		}
-		db.levels[f.Level()].sstables = append(db.levels[f.Level()].sstables, sst)
-	}
-	return nil
-}
-
-func (db *DB) OpenSSTable(file *os.File) (*SSTable, error) {
-	return NewSSTable(file, db.useLearnedIndex)
-}
-
-// todo: can be improved
-func (db *DB) deleteSStableAtLevel(level int, iterators []*SSTableIterator) {
-	iteratorMap := make(map[*SSTable]bool)
-	for _, iterator := range iterators {
-		iteratorMap[iterator.sstable] = true
-	}
-
-	newSSTables := make([]*SSTable, 0)
-	for _, sstable := range db.levels[level].sstables {
-		if iteratorMap[sstable] {
-			if err := os.Remove(sstable.file.Name()); err != nil {
-				log.Printf("Error deleting file: %v", err)
-			}
-		} else {
-			newSSTables = append(newSSTables, sstable)
+		if f.Level() >= len(levels) {
+			return fmt.Errorf("%s is at level %d but Options.MaxLevels is %d", f.Name(), f.Level(), len(levels))
 		}
+		levels[f.Level()].sstables = append(levels[f.Level()].sstables, sst)
 	}
-
-	db.levels[level].sstables = newSSTables
+	return nil
 }
 
-func (db *DB) LeastSstableAtLevel(level int) *SSTable {
-	if len(db.levels[level].sstables) == 0 {
-		return nil
-	}
-
-	if level == 1 {
-		// For level 1, pick a random SSTable
-		r := rand.New(rand.NewSource(time.Now().UnixNano()))
-		randomIndex := r.Intn(len(db.levels[level].sstables))
-		return db.levels[level].sstables[randomIndex]
-	}
-
-	// For other levels, keep the existing logic
-	least := db.levels[level].sstables[0]
-	for _, sstable := range db.levels[level].sstables {
-		if sstable.file.Name() < least.file.Name() {
-			least = sstable
-		}
+// openSSTable 은 meta 의 SSTable 을 table cache 로 열어 key 범위와 크기를 읽는다
+func (db *DB) openSSTable(meta *storage.FileMetadata) (*SSTable, error) {
+	r, err := db.tables.find(meta)
+	if err != nil {
+		return nil, err
 	}
-	return least
+	defer r.unref()
+	return r.newSSTable(db.tables), nil
 }
 
-func readEntry(reader *bufio.Reader) (*DataEntry, uint64, error) {
-	keyLen, valLen := readEntryLengths(reader)
-	if keyLen == 0 {
-		return nil, 0, fmt.Errorf("invalid key length: 0")
-	}
-
-	key := make([]byte, keyLen)
-	opType := make([]byte, 1)
-	value := make([]byte, valLen-1)
-
-	if _, err := io.ReadFull(reader, key); err != nil {
-		return nil, 0, fmt.Errorf("error reading key: %w", err)
-	}
-	if _, err := io.ReadFull(reader, opType); err != nil {
-		return nil, 0, fmt.Errorf("error reading opType: %w", err)
-	}
-	if _, err := io.ReadFull(reader, value); err != nil {
-		return nil, 0, fmt.Errorf("error reading value: %w", err)
-	}
-
-	de := &DataEntry{
-		key:    key,
-		value:  value,
-		opType: encoder.OpType(opType[0]),
+// newSSTable 은 manifest 에 기록된 key 범위와 크기로 SSTable 을 만든다. 파일은 처음 읽을 때 table cache 가 연다
+func (db *DB) newSSTable(meta *storage.FileMetadata, fm *fileMeta) *SSTable {
+	return &SSTable{
+		meta:       meta,
+		size:       fm.size,
+		minKey:     fm.smallest,
+		maxKey:     fm.largest,
+		largestSeq: fm.largestSeq,
+		cmp:        db.opts.Comparator,
+		tables:     db.tables,
 	}
-
-	totalBytes := keyLen + valLen
-	keyLenBytes := binary.PutUvarint(make([]byte, binary.MaxVarintLen64), keyLen)
-	valLenBytes := binary.PutUvarint(make([]byte, binary.MaxVarintLen64), valLen)
-	totalBytes += uint64(keyLenBytes + valLenBytes)
-
-	return de, totalBytes, nil
 }
 
-func readEntryLengths(reader *bufio.Reader) (uint64, uint64) {
-	keyLen, _ := binary.ReadUvarint(reader)
-	valLen, _ := binary.ReadUvarint(reader)
-	return keyLen, valLen
+// IndexStats 는 SSTable index 종류별 lookup 수와 평균 탐색 범위를 반환한다
+func (db *DB) IndexStats() IndexStats {
+	return db.tables.indexStats.stats()
 }
 
-func (db *DB) OpenSSTableByFileName(fileName string) (*SSTable, error) {
-	file, err := os.Open(fileName)
-	if err != nil {
-		return nil, err
+// BlockCacheStats 는 block cache 의 hit / miss 횟수와 사용량을 반환한다
+func (db *DB) BlockCacheStats() cache.Stats {
+	if db.blockCache == nil {
+		return cache.Stats{}
 	}
-	return db.OpenSSTable(file)
+	return db.blockCache.Stats()
 }
@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// 잠금 경쟁을 줄이기 위해 key 의 hash 로 나눈 shard 마다 따로 LRU 를 관리한다
+const numShards = 16
+
+// Key 는 SSTable 파일 번호와 그 파일 안의 block offset
+type Key struct {
+	FileNum uint64
+	Offset  uint64
+}
+
+func (k Key) shard() int {
+	h := k.FileNum*0x9e3779b97f4a7c15 ^ k.Offset*0xbf58476d1ce4e5b9
+	return int(h >> 60 % numShards)
+}
+
+type Stats struct {
+	Hits     uint64
+	Misses   uint64
+	Entries  int
+	Size     int64 // 들어있는 block 크기의 합
+	Capacity int64
+}
+
+// Cache 는 용량이 정해진 LRU block cache. 여러 goroutine 에서 동시에 사용할 수 있다.
+// 들어있는 value 는 여러 읽기가 공유하므로 수정하면 안 된다
+type Cache struct {
+	shards   [numShards]shard
+	capacity int64
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+type shard struct {
+	mu       sync.Mutex
+	capacity int64
+	size     int64
+	lru      *list.List // 앞쪽이 가장 최근에 쓰인 entry
+	entries  map[Key]*list.Element
+}
+
+type entry struct {
+	key   Key
+	value []byte
+}
+
+// New 는 전체 capacity (bytes) 를 shard 에 나눠 가지는 cache 를 만든다
+func New(capacity int64) *Cache {
+	c := &Cache{capacity: capacity}
+	for i := range c.shards {
+		c.shards[i] = shard{
+			capacity: (capacity + numShards - 1) / numShards,
+			lru:      list.New(),
+			entries:  make(map[Key]*list.Element),
+		}
+	}
+	return c
+}
+
+func (c *Cache) Get(k Key) ([]byte, bool) {
+	v, ok := c.shards[k.shard()].get(k)
+	if ok {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+	return v, ok
+}
+
+// Set 은 value 를 len(value) 만큼의 크기로 넣는다. shard 용량을 넘으면 오래된 entry 부터 내보낸다
+func (c *Cache) Set(k Key, value []byte) {
+	c.shards[k.shard()].set(k, value)
+}
+
+func (c *Cache) Stats() Stats {
+	st := Stats{
+		Hits:     c.hits.Load(),
+		Misses:   c.misses.Load(),
+		Capacity: c.capacity,
+	}
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		st.Entries += len(s.entries)
+		st.Size += s.size
+		s.mu.Unlock()
+	}
+	return st
+}
+
+func (s *shard) get(k Key) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[k]
+	if !ok {
+		return nil, false
+	}
+	s.lru.MoveToFront(e)
+	return e.Value.(*entry).value, true
+}
+
+func (s *shard) set(k Key, value []byte) {
+	charge := int64(len(value))
+	if charge > s.capacity {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[k]; ok {
+		s.size += charge - int64(len(e.Value.(*entry).value))
+		e.Value.(*entry).value = value
+		s.lru.MoveToFront(e)
+	} else {
+		s.entries[k] = s.lru.PushFront(&entry{key: k, value: value})
+		s.size += charge
+	}
+	for s.size > s.capacity {
+		oldest := s.lru.Back()
+		old := oldest.Value.(*entry)
+		s.lru.Remove(oldest)
+		delete(s.entries, old.key)
+		s.size -= int64(len(old.value))
+	}
+}
@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache_GetSet(t *testing.T) {
+	c := New(1 << 20)
+	_, ok := c.Get(Key{FileNum: 1, Offset: 0})
+	assert.False(t, ok)
+
+	c.Set(Key{FileNum: 1, Offset: 0}, []byte("block"))
+	v, ok := c.Get(Key{FileNum: 1, Offset: 0})
+	assert.True(t, ok)
+	assert.Equal(t, []byte("block"), v)
+
+	// 다른 파일의 같은 offset 은 다른 block 이다
+	_, ok = c.Get(Key{FileNum: 2, Offset: 0})
+	assert.False(t, ok)
+
+	st := c.Stats()
+	assert.Equal(t, uint64(1), st.Hits)
+	assert.Equal(t, uint64(2), st.Misses)
+	assert.Equal(t, 1, st.Entries)
+	assert.Equal(t, int64(5), st.Size)
+}
+
+func TestCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	c := New(numShards * 100)
+	block := make([]byte, 40)
+	// 같은 shard 에 들어가는 key 를 모은다
+	var keys []Key
+	for off := uint64(0); len(keys) < 3; off++ {
+		k := Key{FileNum: 7, Offset: off}
+		if k.shard() == 0 {
+			keys = append(keys, k)
+		}
+	}
+	c.Set(keys[0], block)
+	c.Set(keys[1], block)
+	_, ok := c.Get(keys[0])
+	assert.True(t, ok)
+
+	c.Set(keys[2], block)
+	_, ok = c.Get(keys[1])
+	assert.False(t, ok, "least recently used block should be evicted")
+	_, ok = c.Get(keys[0])
+	assert.True(t, ok)
+	_, ok = c.Get(keys[2])
+	assert.True(t, ok)
+	assert.LessOrEqual(t, c.Stats().Size, int64(100))
+}
+
+func TestCache_SkipsBlocksLargerThanShard(t *testing.T) {
+	c := New(numShards * 10)
+	c.Set(Key{FileNum: 1}, make([]byte, 11))
+	assert.Equal(t, 0, c.Stats().Entries)
+}
+
+func TestCache_Concurrent(t *testing.T) {
+	c := New(1 << 16)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				k := Key{FileNum: uint64(g), Offset: uint64(i % 50)}
+				if _, ok := c.Get(k); !ok {
+					c.Set(k, []byte(fmt.Sprint(i)))
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	st := c.Stats()
+	assert.Equal(t, uint64(8000), st.Hits+st.Misses)
+	assert.LessOrEqual(t, st.Size, st.Capacity)
+}
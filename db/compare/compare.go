@@ -2,34 +2,115 @@ package compare
 
 import (
 	"bytes"
+	"encoding/binary"
+
+	"github.com/gptjddldi/lsm/db/encoder"
 )
 
-func Compare(a, b []byte, number bool) int {
-	if !number {
-		return bytes.Compare(a, b)
-	}
+// Comparator 는 user key 의 순서를 정한다.
+// Name 은 SSTable 에 기록되어, 다른 순서로 쓰인 파일을 여는 것을 막는다. 순서가 바뀌면 이름도 바꿔야 한다
+type Comparator interface {
+	Compare(a, b []byte) int
+	Name() string
+}
+
+var (
+	// Bytewise 는 bytes.Compare 순서
+	Bytewise Comparator = bytewise{}
+
+	// ReverseBytewise 는 Bytewise 의 역순
+	ReverseBytewise Comparator = reverseBytewise{}
+
+	// NumericString 은 0 으로 시작하지 않는 10 진수 문자열을 수의 크기 순서로 비교한다. 짧은 key 가 작다
+	NumericString Comparator = numericString{}
+
+	// BigEndianUint64 는 8 byte big-endian unsigned 정수 key 를 비교한다
+	BigEndianUint64 Comparator = bigEndianUint64{}
+
+	// BigEndianInt64 는 8 byte big-endian 2 의 보수 정수 key 를 비교한다
+	BigEndianInt64 Comparator = bigEndianInt64{}
+)
+
+type bytewise struct{}
+
+func (bytewise) Compare(a, b []byte) int { return bytes.Compare(a, b) }
+func (bytewise) Name() string            { return "lsm.BytewiseComparator" }
 
+type reverseBytewise struct{}
+
+func (reverseBytewise) Compare(a, b []byte) int { return bytes.Compare(b, a) }
+func (reverseBytewise) Name() string            { return "lsm.ReverseBytewiseComparator" }
+
+type numericString struct{}
+
+func (numericString) Compare(a, b []byte) int {
 	if len(a) < len(b) {
 		return -1
 	} else if len(a) > len(b) {
 		return 1
 	}
+	return bytes.Compare(a, b)
+}
 
-	for i := 0; i < len(a); i++ {
-		if a[i] < b[i] {
-			return -1
-		} else if a[i] > b[i] {
-			return 1
-		}
+func (numericString) Name() string { return "lsm.NumericStringComparator" }
+
+type bigEndianUint64 struct{}
+
+// Compare 는 8 byte 가 아닌 key 를 8 byte key 보다 뒤에 bytewise 순서로 둔다
+func (bigEndianUint64) Compare(a, b []byte) int {
+	if len(a) != 8 || len(b) != 8 {
+		return compareMalformed(a, b)
 	}
+	x, y := binary.BigEndian.Uint64(a), binary.BigEndian.Uint64(b)
+	if x < y {
+		return -1
+	} else if x > y {
+		return 1
+	}
+	return 0
+}
+
+func (bigEndianUint64) Name() string { return "lsm.BigEndianUint64Comparator" }
+
+type bigEndianInt64 struct{}
 
+func (bigEndianInt64) Compare(a, b []byte) int {
+	if len(a) != 8 || len(b) != 8 {
+		return compareMalformed(a, b)
+	}
+	x, y := int64(binary.BigEndian.Uint64(a)), int64(binary.BigEndian.Uint64(b))
+	if x < y {
+		return -1
+	} else if x > y {
+		return 1
+	}
 	return 0
 }
 
-func ByteToInt(b []byte) int {
-	result := 0
-	for _, v := range b {
-		result = result*10 + int(v-'0')
+func (bigEndianInt64) Name() string { return "lsm.BigEndianInt64Comparator" }
+
+// compareMalformed 는 둘 중 하나 이상이 8 byte 가 아닐 때의 순서
+func compareMalformed(a, b []byte) int {
+	if (len(a) == 8) != (len(b) == 8) {
+		if len(a) == 8 {
+			return -1
+		}
+		return 1
+	}
+	return bytes.Compare(a, b)
+}
+
+// CompareInternal 은 internal key 를 user key 는 cmp 순서, 같은 user key 면 sequence 내림차순으로 비교한다
+func CompareInternal(cmp Comparator, a, b []byte) int {
+	if c := cmp.Compare(encoder.UserKey(a), encoder.UserKey(b)); c != 0 {
+		return c
 	}
-	return result
+	at := binary.LittleEndian.Uint64(a[len(a)-encoder.TrailerSize:])
+	bt := binary.LittleEndian.Uint64(b[len(b)-encoder.TrailerSize:])
+	if at > bt {
+		return -1
+	} else if at < bt {
+		return 1
+	}
+	return 0
 }
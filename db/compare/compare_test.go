@@ -0,0 +1,61 @@
+package compare
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+	"testing"
+
+	"github.com/gptjddldi/lsm/db/encoder"
+	"github.com/stretchr/testify/assert"
+)
+
+func sorted(cmp Comparator, keys ...string) []string {
+	sort.Slice(keys, func(i, j int) bool {
+		return cmp.Compare([]byte(keys[i]), []byte(keys[j])) < 0
+	})
+	return keys
+}
+
+func uint64Key(v uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, v)
+}
+
+func TestComparator_Order(t *testing.T) {
+	assert.Equal(t, []string{"1", "10", "2", "a"}, sorted(Bytewise, "10", "a", "2", "1"))
+	assert.Equal(t, []string{"a", "2", "10", "1"}, sorted(ReverseBytewise, "10", "a", "2", "1"))
+	assert.Equal(t, []string{"1", "2", "10", "100"}, sorted(NumericString, "100", "10", "2", "1"))
+
+	assert.Equal(t, -1, BigEndianUint64.Compare(uint64Key(1), uint64Key(256)))
+	assert.Equal(t, 1, BigEndianUint64.Compare(uint64Key(1<<63), uint64Key(1)))
+	assert.Equal(t, 0, BigEndianUint64.Compare(uint64Key(7), uint64Key(7)))
+
+	// MaxUint64 는 int64 로 -1
+	assert.Equal(t, -1, BigEndianInt64.Compare(uint64Key(math.MaxUint64), uint64Key(0)))
+	assert.Equal(t, 1, BigEndianInt64.Compare(uint64Key(1), uint64Key(1<<63)))
+
+	// 8 byte 가 아닌 key 는 뒤로 간다
+	assert.Equal(t, -1, BigEndianUint64.Compare(uint64Key(1<<63), []byte("a")))
+	assert.Equal(t, 1, BigEndianInt64.Compare([]byte("a"), uint64Key(1)))
+}
+
+func TestComparator_NamesAreUnique(t *testing.T) {
+	names := make(map[string]bool)
+	for _, cmp := range []Comparator{Bytewise, ReverseBytewise, NumericString, BigEndianUint64, BigEndianInt64} {
+		assert.False(t, names[cmp.Name()], cmp.Name())
+		names[cmp.Name()] = true
+	}
+}
+
+func TestCompareInternal(t *testing.T) {
+	a1 := encoder.MakeInternalKey([]byte("a"), 1, encoder.OpTypeSet)
+	a2 := encoder.MakeInternalKey([]byte("a"), 2, encoder.OpTypeSet)
+	b1 := encoder.MakeInternalKey([]byte("b"), 1, encoder.OpTypeSet)
+
+	assert.Equal(t, -1, CompareInternal(Bytewise, a1, b1))
+	assert.Equal(t, 1, CompareInternal(ReverseBytewise, a1, b1))
+	// 같은 user key 는 sequence 가 큰 쪽이 먼저 온다
+	assert.Equal(t, -1, CompareInternal(Bytewise, a2, a1))
+	assert.Equal(t, -1, CompareInternal(ReverseBytewise, a2, a1))
+	assert.Equal(t, 0, CompareInternal(Bytewise, a1, a1))
+}
@@ -0,0 +1,74 @@
+package compression
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Type 은 SSTable block 뒤에 1 byte 로 기록되는 압축 방식
+type Type byte
+
+const (
+	None  Type = 0
+	Flate Type = 1 // compress/flate
+	LZ    Type = 2 // 이 패키지의 LZ77 계열 codec, 압축률보다 속도를 우선한다
+)
+
+var ErrCorrupt = errors.New("compression: corrupt input")
+
+// Codec 은 block 하나를 압축하고 푼다
+type Codec interface {
+	// Compress 는 src 를 압축해 dst 뒤에 붙여 반환한다
+	Compress(dst, src []byte) ([]byte, error)
+	// Decompress 는 Compress 의 결과를 원래 내용으로 되돌린다
+	Decompress(src []byte) ([]byte, error)
+}
+
+var (
+	mu     sync.RWMutex
+	codecs = map[Type]Codec{
+		Flate: flateCodec{},
+		LZ:    lzCodec{},
+	}
+	names = map[Type]string{
+		None:  "none",
+		Flate: "flate",
+		LZ:    "lz",
+	}
+)
+
+// Register 는 t 에 codec 을 등록한다. None 과 이미 등록된 type 은 바꿀 수 없다
+func Register(t Type, name string, c Codec) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if t == None {
+		return fmt.Errorf("compression: type %d is reserved for uncompressed blocks", t)
+	}
+	if _, ok := codecs[t]; ok {
+		return fmt.Errorf("compression: type %d is already registered as %s", t, names[t])
+	}
+	codecs[t] = c
+	names[t] = name
+	return nil
+}
+
+// Lookup 은 t 의 codec 을 반환한다. None 이면 nil codec 과 true 를 반환한다
+func Lookup(t Type) (Codec, bool) {
+	if t == None {
+		return nil, true
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	c, ok := codecs[t]
+	return c, ok
+}
+
+func (t Type) String() string {
+	mu.RLock()
+	defer mu.RUnlock()
+	if name, ok := names[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", byte(t))
+}
@@ -0,0 +1,72 @@
+package compression
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testInputs() map[string][]byte {
+	var json bytes.Buffer
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&json, `{"id":%d,"status":"active","tags":["a","b"],"owner":"tenant-0001"}`, i)
+	}
+	random := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(random)
+	return map[string][]byte{
+		"empty":  {},
+		"short":  []byte("abc"),
+		"run":    bytes.Repeat([]byte{'x'}, 10000),
+		"json":   json.Bytes(),
+		"random": random,
+	}
+}
+
+func TestCodecs_RoundTrip(t *testing.T) {
+	for _, typ := range []Type{Flate, LZ} {
+		codec, ok := Lookup(typ)
+		assert.True(t, ok)
+		for name, input := range testInputs() {
+			compressed, err := codec.Compress(nil, input)
+			assert.NoError(t, err)
+			out, err := codec.Decompress(compressed)
+			assert.NoError(t, err, "%s/%s", typ, name)
+			assert.Equal(t, len(input), len(out), "%s/%s", typ, name)
+			assert.True(t, bytes.Equal(input, out), "%s/%s", typ, name)
+		}
+	}
+}
+
+func TestCodecs_ShrinkRepetitiveJSON(t *testing.T) {
+	json := testInputs()["json"]
+	for _, typ := range []Type{Flate, LZ} {
+		codec, _ := Lookup(typ)
+		compressed, err := codec.Compress(nil, json)
+		assert.NoError(t, err)
+		assert.Less(t, len(compressed), len(json)/3, "%s", typ)
+	}
+}
+
+func TestLZ_RejectsCorruptInput(t *testing.T) {
+	codec, _ := Lookup(LZ)
+	compressed, err := codec.Compress(nil, testInputs()["json"])
+	assert.NoError(t, err)
+
+	_, err = codec.Decompress(compressed[:len(compressed)/2])
+	assert.ErrorIs(t, err, ErrCorrupt)
+
+	// 출력 앞을 가리키는 match
+	_, err = codec.Decompress([]byte{8, 1, 5})
+	assert.ErrorIs(t, err, ErrCorrupt)
+}
+
+func TestRegister(t *testing.T) {
+	assert.Error(t, Register(None, "none", nil))
+	assert.Error(t, Register(LZ, "lz", nil))
+	_, ok := Lookup(Type(200))
+	assert.False(t, ok)
+	assert.Equal(t, "unknown(200)", Type(200).String())
+}
@@ -0,0 +1,104 @@
+package compression
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// lz layout: { 원래 길이 (uvarint), op ... }
+//
+//	literal: { length<<1 (uvarint), bytes }
+//	match:   { (length-lzMinMatch)<<1 | 1 (uvarint), offset (uvarint) }
+//
+// match 는 출력에서 offset 만큼 앞의 내용을 length 만큼 복사한다. offset 이 length 보다 작으면 반복된다
+const (
+	lzMinMatch  = 4
+	lzHashBits  = 14
+	lzMaxOffset = 1 << 16
+	lzMaxSize   = 1 << 30
+)
+
+type lzCodec struct{}
+
+func lzHash(v uint32) uint32 {
+	return (v * 0x1e35a7bd) >> (32 - lzHashBits)
+}
+
+func (lzCodec) Compress(dst, src []byte) ([]byte, error) {
+	dst = binary.AppendUvarint(dst, uint64(len(src)))
+
+	var table [1 << lzHashBits]int32 // 위치 + 1, 0 이면 비어있다
+	literalStart := 0
+	i := 0
+	for i+lzMinMatch <= len(src) {
+		cur := binary.LittleEndian.Uint32(src[i:])
+		h := lzHash(cur)
+		candidate := int(table[h]) - 1
+		table[h] = int32(i + 1)
+		if candidate < 0 || i-candidate > lzMaxOffset || binary.LittleEndian.Uint32(src[candidate:]) != cur {
+			i++
+			continue
+		}
+
+		length := lzMinMatch
+		for i+length < len(src) && src[candidate+length] == src[i+length] {
+			length++
+		}
+		dst = appendLiteral(dst, src[literalStart:i])
+		dst = binary.AppendUvarint(dst, uint64(length-lzMinMatch)<<1|1)
+		dst = binary.AppendUvarint(dst, uint64(i-candidate))
+		i += length
+		literalStart = i
+	}
+	return appendLiteral(dst, src[literalStart:]), nil
+}
+
+func appendLiteral(dst, lit []byte) []byte {
+	if len(lit) == 0 {
+		return dst
+	}
+	dst = binary.AppendUvarint(dst, uint64(len(lit))<<1)
+	return append(dst, lit...)
+}
+
+func (lzCodec) Decompress(src []byte) ([]byte, error) {
+	size, n := binary.Uvarint(src)
+	if n <= 0 || size > lzMaxSize {
+		return nil, fmt.Errorf("%w: invalid lz length", ErrCorrupt)
+	}
+	src = src[n:]
+	// 깨진 길이로 큰 메모리를 잡지 않도록 미리 잡는 크기는 입력에 비례하게 제한한다
+	out := make([]byte, 0, min(size, uint64(len(src))*8))
+	for len(src) > 0 {
+		op, n := binary.Uvarint(src)
+		if n <= 0 {
+			return nil, fmt.Errorf("%w: invalid lz op", ErrCorrupt)
+		}
+		src = src[n:]
+
+		if op&1 == 0 {
+			length := op >> 1
+			if length > uint64(len(src)) || length > size-uint64(len(out)) {
+				return nil, fmt.Errorf("%w: lz literal exceeds input", ErrCorrupt)
+			}
+			out = append(out, src[:length]...)
+			src = src[length:]
+			continue
+		}
+
+		length := op>>1 + lzMinMatch
+		offset, n := binary.Uvarint(src)
+		if n <= 0 || offset == 0 || offset > uint64(len(out)) || length > size-uint64(len(out)) {
+			return nil, fmt.Errorf("%w: invalid lz match", ErrCorrupt)
+		}
+		src = src[n:]
+		start := len(out) - int(offset)
+		for k := 0; k < int(length); k++ {
+			out = append(out, out[start+k])
+		}
+	}
+	if uint64(len(out)) != size {
+		return nil, fmt.Errorf("%w: lz output is %d bytes, expected %d", ErrCorrupt, len(out), size)
+	}
+	return out, nil
+}
@@ -24,9 +24,18 @@ func Decode(buf []byte) *EncodedValue {
 	}
 }
 
+func NewEncodedValue(op OpType, val []byte, seq uint64) *EncodedValue {
+	return &EncodedValue{
+		val:    val,
+		OpType: op,
+		Seq:    seq,
+	}
+}
+
 type EncodedValue struct {
 	val    []byte
 	OpType OpType
+	Seq    uint64
 }
 
 func (ev *EncodedValue) Value() []byte {
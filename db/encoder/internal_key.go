@@ -0,0 +1,36 @@
+package encoder
+
+import "encoding/binary"
+
+// internal key: { user key, trailer (8) }
+// trailer 는 (sequence << 8 | opType) 을 little endian 으로 저장한다
+const TrailerSize = 8
+
+// MaxSequenceNumber 는 trailer 에 들어갈 수 있는 가장 큰 sequence
+const MaxSequenceNumber = (1 << 56) - 1
+
+// opTypeForSeek 은 같은 sequence 의 어떤 opType 보다 trailer 가 크도록 해서
+// lookup key 가 그 sequence 의 entry 앞에 정렬되게 한다
+const opTypeForSeek OpType = 0xff
+
+func MakeInternalKey(userKey []byte, seq uint64, op OpType) []byte {
+	buf := make([]byte, len(userKey)+TrailerSize)
+	copy(buf, userKey)
+	binary.LittleEndian.PutUint64(buf[len(userKey):], seq<<8|uint64(op))
+	return buf
+}
+
+// MakeLookupKey 는 sequence 가 seq 이하인 userKey 의 entry 중 가장 최근 것 앞에 정렬되는 key 를 만든다
+func MakeLookupKey(userKey []byte, seq uint64) []byte {
+	return MakeInternalKey(userKey, seq, opTypeForSeek)
+}
+
+func ParseInternalKey(internalKey []byte) ([]byte, uint64, OpType) {
+	n := len(internalKey) - TrailerSize
+	trailer := binary.LittleEndian.Uint64(internalKey[n:])
+	return internalKey[:n], trailer >> 8, OpType(trailer & 0xff)
+}
+
+func UserKey(internalKey []byte) []byte {
+	return internalKey[:len(internalKey)-TrailerSize]
+}
@@ -1,5 +1,7 @@
 package regression
 
+import "math"
+
 type LinearRegression struct {
 	Slope     float64
 	Intercept float64
@@ -13,13 +15,42 @@ func (lr *LinearRegression) Predict(x uint64) uint64 {
 	return uint64(lr.Slope*float64(x) + lr.Intercept)
 }
 
+func (lr *LinearRegression) Estimate(x uint64) float64 {
+	return lr.Slope*float64(x) + lr.Intercept
+}
+
+// LinearRegression encoding: { slope (8), intercept (8) }
+func (lr *LinearRegression) MarshalBinary() ([]byte, error) {
+	buf := appendFloat(nil, lr.Slope)
+	return appendFloat(buf, lr.Intercept), nil
+}
+
+func (lr *LinearRegression) UnmarshalBinary(data []byte) error {
+	d := &decoder{buf: data}
+	slope, intercept := d.float(), d.float()
+	if err := d.finish(); err != nil {
+		return err
+	}
+	lr.Slope, lr.Intercept = slope, intercept
+	return nil
+}
+
 // Train y = alpha + beta*x
 func (lr *LinearRegression) Train(x, y []uint64) {
 	xu, xv := MeanVariance(x)
 	yu := Mean(y)
+	if xv == 0 {
+		// x 가 모두 같으면 y 의 평균으로 예측한다
+		lr.Slope, lr.Intercept = 0, yu
+		return
+	}
 	cov := covarianceMeans(x, y, xu, yu)
 
 	beta := cov / xv
+	if beta < 0 || math.IsNaN(beta) {
+		// 정렬된 x 의 기울기는 음수가 아니지만 float 오차로 음수가 될 수 있다
+		beta = 0
+	}
 	alpha := yu - beta*xu
 
 	lr.Slope = beta
@@ -0,0 +1,94 @@
+package regression
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Model 은 정렬된 key x 로 위치 y 를 예측한다.
+// Train 에는 감소하지 않는 x 와 증가하는 y 를 넘기고, 학습한 뒤 Estimate 는 x 에 대해 감소하지 않아야 한다.
+// MarshalBinary 로 저장한 모델은 UnmarshalBinary 로 다시 학습하지 않고 읽을 수 있다
+type Model interface {
+	Train(x, y []uint64)
+	Estimate(x uint64) float64
+	MarshalBinary() ([]byte, error)
+	UnmarshalBinary(data []byte) error
+}
+
+// ErrInvalidModel 은 UnmarshalBinary 가 읽을 수 없는 데이터를 받았을 때 반환된다
+var ErrInvalidModel = errors.New("regression: invalid model encoding")
+
+var (
+	_ Model = (*LinearRegression)(nil)
+	_ Model = (*PiecewiseLinear)(nil)
+	_ Model = (*RadixSpline)(nil)
+)
+
+// dedup 은 같은 x 가 여러 번 나오면 가장 작은 y 만 남긴다
+func dedup(x, y []uint64) ([]uint64, []uint64) {
+	dx := make([]uint64, 0, len(x))
+	dy := make([]uint64, 0, len(y))
+	for i := range x {
+		if i > 0 && x[i] == x[i-1] {
+			continue
+		}
+		dx = append(dx, x[i])
+		dy = append(dy, y[i])
+	}
+	return dx, dy
+}
+
+func appendFloat(buf []byte, f float64) []byte {
+	return binary.LittleEndian.AppendUint64(buf, math.Float64bits(f))
+}
+
+// decoder 는 모델 encoding 을 앞에서부터 읽는다. 읽다가 실패하면 err 를 남기고 이후 값은 0 이다
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) uint64() uint64 {
+	if d.err != nil || len(d.buf) < 8 {
+		d.err = ErrInvalidModel
+		return 0
+	}
+	v := binary.LittleEndian.Uint64(d.buf)
+	d.buf = d.buf[8:]
+	return v
+}
+
+func (d *decoder) float() float64 {
+	return math.Float64frombits(d.uint64())
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = ErrInvalidModel
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+// count 는 원소 하나가 size byte 인 배열의 길이를 읽는다
+func (d *decoder) count(size int) int {
+	n := d.uvarint()
+	if d.err == nil && n > uint64(len(d.buf)/size) {
+		d.err = ErrInvalidModel
+	}
+	return int(n)
+}
+
+// finish 는 남은 byte 가 없는지 확인한다
+func (d *decoder) finish() error {
+	if d.err == nil && len(d.buf) != 0 {
+		d.err = ErrInvalidModel
+	}
+	return d.err
+}
@@ -0,0 +1,121 @@
+package regression
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func lognormalKeys(n int) []uint64 {
+	rnd := rand.New(rand.NewSource(1))
+	x := make([]uint64, n)
+	for i := range x {
+		x[i] = uint64(math.Exp(rnd.NormFloat64()*2) * 1e9)
+	}
+	sort.Slice(x, func(i, j int) bool { return x[i] < x[j] })
+	return x
+}
+
+func positions(n int) []uint64 {
+	y := make([]uint64, n)
+	for i := range y {
+		y[i] = uint64(i)
+	}
+	return y
+}
+
+func TestModel_ErrorBound(t *testing.T) {
+	x := lognormalKeys(10000)
+	y := positions(len(x))
+	for name, m := range map[string]Model{
+		"piecewise linear": NewPiecewiseLinear(8),
+		"radix spline":     NewRadixSpline(8, 12),
+	} {
+		t.Run(name, func(t *testing.T) {
+			m.Train(x, y)
+			for i := range x {
+				// 같은 x 가 여러 번 나오면 첫 위치로 예측한다
+				first := sort.Search(len(x), func(j int) bool { return x[j] >= x[i] })
+				assert.InDelta(t, float64(first), m.Estimate(x[i]), 8+1e-6)
+			}
+		})
+	}
+}
+
+func TestModel_Monotonic(t *testing.T) {
+	x := lognormalKeys(2000)
+	y := positions(len(x))
+	rnd := rand.New(rand.NewSource(2))
+	probes := make([]uint64, 0, 10000)
+	for i := 0; i < cap(probes); i++ {
+		probes = append(probes, uint64(rnd.Int63n(int64(x[len(x)-1])*2)))
+	}
+	probes = append(probes, 0, math.MaxUint64)
+	probes = append(probes, x...)
+	sort.Slice(probes, func(i, j int) bool { return probes[i] < probes[j] })
+
+	for name, m := range map[string]Model{
+		"linear":           NewRegression(),
+		"piecewise linear": NewPiecewiseLinear(4),
+		"radix spline":     NewRadixSpline(4, 8),
+	} {
+		t.Run(name, func(t *testing.T) {
+			m.Train(x, y)
+			prev := math.Inf(-1)
+			for _, p := range probes {
+				e := m.Estimate(p)
+				assert.GreaterOrEqual(t, e, prev, "x=%d", p)
+				prev = e
+			}
+		})
+	}
+}
+
+func TestPiecewiseLinear_Segments(t *testing.T) {
+	// 기울기가 다른 두 직선은 구간 두 개로 정확히 맞춘다
+	x := make([]uint64, 0, 200)
+	for i := 0; i < 100; i++ {
+		x = append(x, uint64(i*2))
+	}
+	for i := 0; i < 100; i++ {
+		x = append(x, uint64(1000+i*50))
+	}
+	pl := NewPiecewiseLinear(1)
+	pl.Train(x, positions(len(x)))
+	assert.Equal(t, 2, pl.Segments())
+	assert.InDelta(t, 150, pl.Estimate(1000+50*50), 1e-9)
+
+	rs := NewRadixSpline(1, 8)
+	rs.Train(x, positions(len(x)))
+	assert.LessOrEqual(t, rs.Points(), 4)
+	assert.InDelta(t, 150, rs.Estimate(1000+50*50), 1e-9)
+}
+
+func TestModel_MarshalBinary(t *testing.T) {
+	x := lognormalKeys(3000)
+	y := positions(len(x))
+	for name, newModel := range map[string]func() Model{
+		"linear":           func() Model { return NewRegression() },
+		"piecewise linear": func() Model { return NewPiecewiseLinear(8) },
+		"radix spline":     func() Model { return NewRadixSpline(8, 12) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			m := newModel()
+			m.Train(x, y)
+			buf, err := m.MarshalBinary()
+			assert.NoError(t, err)
+
+			loaded := newModel()
+			assert.NoError(t, loaded.UnmarshalBinary(buf))
+			for _, xi := range x {
+				assert.Equal(t, m.Estimate(xi), loaded.Estimate(xi))
+			}
+
+			assert.ErrorIs(t, newModel().UnmarshalBinary(buf[:len(buf)-1]), ErrInvalidModel)
+			assert.ErrorIs(t, newModel().UnmarshalBinary(append(buf, 0)), ErrInvalidModel)
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package regression
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+)
+
+// PiecewiseLinear 는 학습한 모든 점의 오차가 Epsilon 이하가 되도록 x 를 여러 구간으로 나눠 구간마다 직선을 둔다.
+// PGM index 처럼 앞에서부터 욕심껏 구간을 늘리고, 구간의 기울기가 모든 점을 오차 안에 두지 못하면 새 구간을 시작한다
+type PiecewiseLinear struct {
+	Epsilon  float64
+	segments []segment
+	end      float64 // 마지막 위치 + 1
+}
+
+type segment struct {
+	key   uint64  // 구간의 첫 x
+	pos   float64 // 구간의 첫 y
+	slope float64
+}
+
+func NewPiecewiseLinear(epsilon float64) *PiecewiseLinear {
+	return &PiecewiseLinear{Epsilon: epsilon}
+}
+
+func (pl *PiecewiseLinear) Train(x, y []uint64) {
+	x, y = dedup(x, y)
+	pl.segments = pl.segments[:0]
+	if len(x) == 0 {
+		return
+	}
+	pl.end = float64(y[len(y)-1] + 1)
+
+	// 구간의 첫 점에서 시작하는 직선 중 지금까지의 점을 모두 오차 안에 두는 기울기 범위 [lo, hi]
+	start := 0
+	lo, hi := 0.0, math.Inf(1)
+	closeSegment := func(end int) {
+		slope := 0.0
+		if !math.IsInf(hi, 1) {
+			slope = (lo + hi) / 2
+		}
+		pl.segments = append(pl.segments, segment{key: x[start], pos: float64(y[start]), slope: slope})
+		start, lo, hi = end, 0, math.Inf(1)
+	}
+	for i := 1; i < len(x); i++ {
+		dx := float64(x[i] - x[start])
+		dy := float64(y[i] - y[start])
+		l, h := (dy-pl.Epsilon)/dx, (dy+pl.Epsilon)/dx
+		if l > hi || h < lo {
+			closeSegment(i)
+			continue
+		}
+		lo, hi = math.Max(lo, l), math.Min(hi, h)
+	}
+	closeSegment(len(x))
+}
+
+// PiecewiseLinear encoding: { epsilon (8), end (8), 구간 수 (uvarint), { key (8), pos (8), slope (8) } ... }
+func (pl *PiecewiseLinear) MarshalBinary() ([]byte, error) {
+	buf := appendFloat(nil, pl.Epsilon)
+	buf = appendFloat(buf, pl.end)
+	buf = binary.AppendUvarint(buf, uint64(len(pl.segments)))
+	for _, s := range pl.segments {
+		buf = binary.LittleEndian.AppendUint64(buf, s.key)
+		buf = appendFloat(buf, s.pos)
+		buf = appendFloat(buf, s.slope)
+	}
+	return buf, nil
+}
+
+func (pl *PiecewiseLinear) UnmarshalBinary(data []byte) error {
+	d := &decoder{buf: data}
+	epsilon, end := d.float(), d.float()
+	segments := make([]segment, d.count(24))
+	for i := range segments {
+		segments[i] = segment{key: d.uint64(), pos: d.float(), slope: d.float()}
+	}
+	if err := d.finish(); err != nil {
+		return err
+	}
+	pl.Epsilon, pl.end, pl.segments = epsilon, end, segments
+	return nil
+}
+
+// Estimate 는 x 가 속한 구간의 직선으로 예측하고, 구간의 위치 범위를 넘지 않게 자른다
+func (pl *PiecewiseLinear) Estimate(x uint64) float64 {
+	if len(pl.segments) == 0 {
+		return 0
+	}
+	i := sort.Search(len(pl.segments), func(i int) bool { return pl.segments[i].key > x }) - 1
+	if i < 0 {
+		return pl.segments[0].pos
+	}
+	s := pl.segments[i]
+	next := pl.end
+	if i+1 < len(pl.segments) {
+		next = pl.segments[i+1].pos
+	}
+	return math.Min(s.pos+s.slope*float64(x-s.key), next)
+}
+
+// Segments 는 학습한 구간의 개수
+func (pl *PiecewiseLinear) Segments() int {
+	return len(pl.segments)
+}
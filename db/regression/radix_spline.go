@@ -0,0 +1,155 @@
+package regression
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// RadixSpline 은 학습한 점의 오차가 Epsilon 이하인 linear spline 으로 예측한다.
+// spline 점은 GreedySplineCorridor 로 고르고, x 의 상위 RadixBits bit 로 만든 radix table 로
+// x 가 속한 spline 구간을 좁힌 뒤 binary search 한다
+type RadixSpline struct {
+	Epsilon   float64
+	RadixBits int
+
+	points []splinePoint
+	minX   uint64
+	shift  int
+	table  []uint32 // radix prefix -> prefix 가 그 값 이상인 첫 spline 점
+}
+
+type splinePoint struct {
+	x uint64
+	y float64
+}
+
+func NewRadixSpline(epsilon float64, radixBits int) *RadixSpline {
+	return &RadixSpline{Epsilon: epsilon, RadixBits: radixBits}
+}
+
+func (rs *RadixSpline) Train(x, y []uint64) {
+	x, y = dedup(x, y)
+	rs.points = rs.points[:0]
+	if len(x) == 0 {
+		return
+	}
+	rs.buildSpline(x, y)
+	rs.buildRadixTable()
+}
+
+func slope(a, b splinePoint) float64 {
+	return (b.y - a.y) / float64(b.x-a.x)
+}
+
+// buildSpline 은 마지막 spline 점에서 본 기울기 통로 (corridor) 를 벗어나는 점이 나오면
+// 그 직전 점을 spline 점으로 추가한다
+func (rs *RadixSpline) buildSpline(x, y []uint64) {
+	base := splinePoint{x[0], float64(y[0])}
+	rs.points = append(rs.points, base)
+	if len(x) == 1 {
+		return
+	}
+	prev := splinePoint{x[1], float64(y[1])}
+	upper := slope(base, splinePoint{prev.x, prev.y + rs.Epsilon})
+	lower := slope(base, splinePoint{prev.x, prev.y - rs.Epsilon})
+	for i := 2; i < len(x); i++ {
+		p := splinePoint{x[i], float64(y[i])}
+		if s := slope(base, p); s > upper || s < lower {
+			rs.points = append(rs.points, prev)
+			base = prev
+			upper = slope(base, splinePoint{p.x, p.y + rs.Epsilon})
+			lower = slope(base, splinePoint{p.x, p.y - rs.Epsilon})
+		} else {
+			upper = math.Min(upper, slope(base, splinePoint{p.x, p.y + rs.Epsilon}))
+			lower = math.Max(lower, slope(base, splinePoint{p.x, p.y - rs.Epsilon}))
+		}
+		prev = p
+	}
+	rs.points = append(rs.points, prev)
+}
+
+func (rs *RadixSpline) buildRadixTable() {
+	rs.minX = rs.points[0].x
+	span := rs.points[len(rs.points)-1].x - rs.minX
+	// spline 점보다 훨씬 큰 table 은 탐색 범위를 더 좁히지 못하므로 bit 수를 점의 개수에 맞춘다
+	radixBits := min(rs.RadixBits, bits.Len(uint(len(rs.points)))+1)
+	rs.shift = max(bits.Len64(span)-radixBits, 0)
+
+	size := int(span>>rs.shift) + 2
+	rs.table = make([]uint32, size)
+	next := 0
+	for p := range rs.table {
+		for next < len(rs.points) && rs.prefix(rs.points[next].x) < uint64(p) {
+			next++
+		}
+		rs.table[p] = uint32(next)
+	}
+}
+
+func (rs *RadixSpline) prefix(x uint64) uint64 {
+	return (x - rs.minX) >> rs.shift
+}
+
+// RadixSpline encoding: { epsilon (8), radix bits (uvarint), 점 수 (uvarint), { x (8), y (8) } ... }.
+// radix table 은 저장하지 않고 읽을 때 spline 점으로 다시 만든다
+func (rs *RadixSpline) MarshalBinary() ([]byte, error) {
+	buf := appendFloat(nil, rs.Epsilon)
+	buf = binary.AppendUvarint(buf, uint64(rs.RadixBits))
+	buf = binary.AppendUvarint(buf, uint64(len(rs.points)))
+	for _, p := range rs.points {
+		buf = binary.LittleEndian.AppendUint64(buf, p.x)
+		buf = appendFloat(buf, p.y)
+	}
+	return buf, nil
+}
+
+func (rs *RadixSpline) UnmarshalBinary(data []byte) error {
+	d := &decoder{buf: data}
+	epsilon, radixBits := d.float(), d.uvarint()
+	points := make([]splinePoint, d.count(16))
+	for i := range points {
+		points[i] = splinePoint{x: d.uint64(), y: d.float()}
+		if i > 0 && points[i].x <= points[i-1].x {
+			d.err = ErrInvalidModel
+		}
+	}
+	if err := d.finish(); err != nil {
+		return err
+	}
+	if radixBits > 64 {
+		return ErrInvalidModel
+	}
+	rs.Epsilon, rs.RadixBits, rs.points = epsilon, int(radixBits), points
+	if len(points) > 0 {
+		rs.buildRadixTable()
+	}
+	return nil
+}
+
+// Estimate 는 x 를 감싸는 두 spline 점 사이를 선형 보간한다
+func (rs *RadixSpline) Estimate(x uint64) float64 {
+	if len(rs.points) == 0 {
+		return 0
+	}
+	last := rs.points[len(rs.points)-1]
+	if x <= rs.minX {
+		return rs.points[0].y
+	}
+	if x >= last.x {
+		return last.y
+	}
+
+	// x 보다 큰 첫 spline 점은 prefix 가 같은 점들 또는 그 다음 점이다
+	p := rs.prefix(x)
+	begin, end := int(rs.table[p]), int(rs.table[p+1])
+	i := begin + sort.Search(end-begin, func(i int) bool { return rs.points[begin+i].x > x })
+	left, right := rs.points[i-1], rs.points[i]
+	return left.y + slope(left, right)*float64(x-left.x)
+}
+
+// Points 는 spline 점의 개수
+func (rs *RadixSpline) Points() int {
+	return len(rs.points)
+}
@@ -23,3 +23,51 @@ func (it *Iterator) Next() ([]byte, []byte) {
 	}
 	return it.current.key, it.current.val
 }
+
+// SeekGE 는 key 보다 크거나 같은 첫 번째 entry 를 반환한다
+func (sl *SkipList) SeekGE(key []byte) ([]byte, []byte, bool) {
+	_, journey := sl.search(key)
+	return entryOf(journey[0].tower[0])
+}
+
+// SeekGT 는 key 보다 큰 첫 번째 entry 를 반환한다
+func (sl *SkipList) SeekGT(key []byte) ([]byte, []byte, bool) {
+	found, journey := sl.search(key)
+	if found != nil {
+		return entryOf(found.tower[0])
+	}
+	return entryOf(journey[0].tower[0])
+}
+
+// SeekLT 는 key 보다 작은 마지막 entry 를 반환한다
+func (sl *SkipList) SeekLT(key []byte) ([]byte, []byte, bool) {
+	_, journey := sl.search(key)
+	if journey[0] == sl.head {
+		return nil, nil, false
+	}
+	return entryOf(journey[0])
+}
+
+func (sl *SkipList) First() ([]byte, []byte, bool) {
+	return entryOf(sl.head.tower[0])
+}
+
+func (sl *SkipList) Last() ([]byte, []byte, bool) {
+	prev := sl.head
+	for level := sl.height - 1; level >= 0; level-- {
+		for next := prev.tower[level]; next != nil; next = prev.tower[level] {
+			prev = next
+		}
+	}
+	if prev == sl.head {
+		return nil, nil, false
+	}
+	return entryOf(prev)
+}
+
+func entryOf(n *node) ([]byte, []byte, bool) {
+	if n == nil {
+		return nil, nil, false
+	}
+	return n.key, n.val, true
+}
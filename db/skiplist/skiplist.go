@@ -40,14 +40,16 @@ func randomHeight() int {
 	return height
 }
 
+// SkipList 는 internal key (user key + trailer) 를 정렬해서 저장한다
 type SkipList struct {
-	head            *node
-	height          int
-	useLearnedIndex bool
+	head   *node
+	height int
+	cmp    compare.Comparator
 }
 
-func NewSkipList(useLearnedIndex bool) (sl *SkipList) {
-	sl = &SkipList{useLearnedIndex: useLearnedIndex}
+// NewSkipList 는 user key 를 cmp 순서로 정렬하는 SkipList 를 만든다
+func NewSkipList(cmp compare.Comparator) (sl *SkipList) {
+	sl = &SkipList{cmp: cmp}
 	sl.head = &node{}
 	sl.height = 1
 	return
@@ -60,7 +62,7 @@ func (sl *SkipList) search(key []byte) (*node, [MaxHeight]*node) {
 	prev := sl.head
 	for level := sl.height - 1; level >= 0; level-- {
 		for next = prev.tower[level]; next != nil; next = prev.tower[level] {
-			if compare.Compare(next.key, key, sl.useLearnedIndex) >= 0 {
+			if compare.CompareInternal(sl.cmp, next.key, key) >= 0 {
 				break
 			}
 			prev = next
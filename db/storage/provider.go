@@ -4,11 +4,17 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 )
 
+const currentFileName = "CURRENT"
+
 type Provider struct {
 	dataDir string
-	fileNum map[int]int
+
+	mu      sync.Mutex
+	fileNum int // sstable, wal, manifest 가 하나의 번호 공간을 공유한다
 }
 
 type FileType int
@@ -16,6 +22,8 @@ type FileType int
 const (
 	FileTypeUnknown FileType = iota
 	fileTypeSSTable
+	fileTypeWAL
+	fileTypeManifest
 )
 
 type FileMetadata struct {
@@ -30,6 +38,14 @@ func (f *FileMetadata) IsSSTable() bool {
 	return f.fileType == fileTypeSSTable
 }
 
+func (f *FileMetadata) IsWAL() bool {
+	return f.fileType == fileTypeWAL
+}
+
+func (f *FileMetadata) IsManifest() bool {
+	return f.fileType == fileTypeManifest
+}
+
 func (f *FileMetadata) FileNum() int {
 	return f.fileNum
 }
@@ -47,7 +63,7 @@ func (f *FileMetadata) Path() string {
 }
 
 func NewProvider(dataDir string) (*Provider, error) {
-	s := &Provider{dataDir: dataDir, fileNum: make(map[int]int)}
+	s := &Provider{dataDir: dataDir}
 	err := s.ensureDataDirExists()
 	if err != nil {
 		return nil, err
@@ -63,60 +79,126 @@ func (s *Provider) ensureDataDirExists() error {
 	return nil
 }
 
-// directory + "/" + level (single digit) + _ + file number (6 digits) + .sst
+// sstable:  directory + "/" + level (single digit) + _ + file number (6 digits) + .sst
+// wal:      directory + "/" + file number (6 digits) + .log
+// manifest: directory + "/" + MANIFEST- + file number (6 digits)
 func (s *Provider) ListFiles() ([]*FileMetadata, error) {
 	files, err := os.ReadDir(s.dataDir)
 	if err != nil {
 		return nil, err
 	}
 	var meta []*FileMetadata
-	var fileNumber int
-	var fileLevel int
-	var fileExtension string
 
 	for _, f := range files {
-		_, err = fmt.Sscanf(f.Name(), "%1d_%06d.%s", &fileLevel, &fileNumber, &fileExtension)
-		if err != nil {
-			return nil, err
-		}
-		if fileExtension != "sst" {
+		if f.IsDir() {
 			continue
 		}
-		meta = append(meta, &FileMetadata{
-			fileNum:  fileNumber,
-			level:    fileLevel,
-			fileType: fileTypeSSTable,
-			name:     f.Name(),
-			path:     filepath.Join(s.dataDir, f.Name()),
-		})
-		// 각 레벨의 최대 파일 번호 업데이트
-		if currentMax, exists := s.fileNum[fileLevel]; !exists || fileNumber > currentMax {
-			s.fileNum[fileLevel] = fileNumber
+		m, ok := parseFileName(f.Name())
+		if !ok {
+			continue
 		}
+		m.path = filepath.Join(s.dataDir, f.Name())
+		meta = append(meta, m)
+
+		s.MarkFileNumUsed(m.fileNum)
 	}
 	return meta, nil
 }
 
-func (s *Provider) nextFileNum(level int) int {
-	s.fileNum[level]++
-	return s.fileNum[level]
+func parseFileName(name string) (*FileMetadata, bool) {
+	var fileNumber, fileLevel int
+	if strings.HasPrefix(name, "MANIFEST-") {
+		if _, err := fmt.Sscanf(name, "MANIFEST-%06d", &fileNumber); err != nil {
+			return nil, false
+		}
+		return &FileMetadata{fileNum: fileNumber, fileType: fileTypeManifest, name: name}, true
+	}
+	switch filepath.Ext(name) {
+	case ".sst":
+		if _, err := fmt.Sscanf(name, "%1d_%06d.sst", &fileLevel, &fileNumber); err != nil {
+			return nil, false
+		}
+		return &FileMetadata{fileNum: fileNumber, level: fileLevel, fileType: fileTypeSSTable, name: name}, true
+	case ".log":
+		if _, err := fmt.Sscanf(name, "%06d.log", &fileNumber); err != nil {
+			return nil, false
+		}
+		return &FileMetadata{fileNum: fileNumber, fileType: fileTypeWAL, name: name}, true
+	}
+	return nil, false
+}
+
+// MarkFileNumUsed 는 manifest 에 기록된 번호가 다시 쓰이지 않도록 한다
+func (s *Provider) MarkFileNumUsed(fileNum int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if fileNum > s.fileNum {
+		s.fileNum = fileNum
+	}
 }
 
-func (s *Provider) generateFileName(level, seq int) string {
-	return fmt.Sprintf("%1d_%06d.sst", level, seq)
+// NextFileNum 은 다음에 할당될 파일 번호를 반환한다
+func (s *Provider) NextFileNum() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.fileNum + 1
 }
 
-func (s *Provider) PrepareNewFile(level int) *FileMetadata {
-	return &FileMetadata{
-		fileNum:  s.nextFileNum(level),
+func (s *Provider) nextFileNum() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.fileNum++
+	return s.fileNum
+}
+
+func (s *Provider) generateFileName(meta *FileMetadata) string {
+	switch meta.fileType {
+	case fileTypeWAL:
+		return fmt.Sprintf("%06d.log", meta.fileNum)
+	case fileTypeManifest:
+		return fmt.Sprintf("MANIFEST-%06d", meta.fileNum)
+	}
+	return fmt.Sprintf("%1d_%06d.sst", meta.level, meta.fileNum)
+}
+
+func (s *Provider) newFileMetadata(fileType FileType, level, fileNum int) *FileMetadata {
+	meta := &FileMetadata{
+		fileNum:  fileNum,
 		level:    level,
-		fileType: fileTypeSSTable,
+		fileType: fileType,
 	}
+	meta.name = s.generateFileName(meta)
+	meta.path = filepath.Join(s.dataDir, meta.name)
+	return meta
+}
+
+func (s *Provider) PrepareNewFile(level int) *FileMetadata {
+	return s.newFileMetadata(fileTypeSSTable, level, s.nextFileNum())
+}
+
+func (s *Provider) PrepareNewWALFile() *FileMetadata {
+	return s.newFileMetadata(fileTypeWAL, 0, s.nextFileNum())
+}
+
+func (s *Provider) PrepareNewManifestFile() *FileMetadata {
+	return s.newFileMetadata(fileTypeManifest, 0, s.nextFileNum())
+}
+
+// SSTableFile 은 manifest 에 기록된 (level, file number) 의 metadata 를 만든다
+func (s *Provider) SSTableFile(level, fileNum int) *FileMetadata {
+	return s.newFileMetadata(fileTypeSSTable, level, fileNum)
+}
+
+func (s *Provider) ManifestFile(fileNum int) *FileMetadata {
+	return s.newFileMetadata(fileTypeManifest, 0, fileNum)
 }
 
 func (s *Provider) OpenFileForWriting(meta *FileMetadata) (*os.File, error) {
 	const openFlags = os.O_RDWR | os.O_CREATE | os.O_EXCL
-	filename := s.generateFileName(meta.level, meta.fileNum)
+	filename := s.generateFileName(meta)
 	file, err := os.OpenFile(filepath.Join(s.dataDir, filename), openFlags, 0644)
 	if err != nil {
 		return nil, err
@@ -126,10 +208,64 @@ func (s *Provider) OpenFileForWriting(meta *FileMetadata) (*os.File, error) {
 
 func (s *Provider) OpenFileForReading(meta *FileMetadata) (*os.File, error) {
 	const openFlags = os.O_RDONLY
-	filename := s.generateFileName(meta.level, meta.fileNum)
+	filename := s.generateFileName(meta)
 	file, err := os.OpenFile(filepath.Join(s.dataDir, filename), openFlags, 0644)
 	if err != nil {
 		return nil, err
 	}
 	return file, nil
 }
+
+func (s *Provider) RemoveFile(meta *FileMetadata) error {
+	return os.Remove(filepath.Join(s.dataDir, s.generateFileName(meta)))
+}
+
+// CurrentManifest 는 CURRENT 가 가리키는 manifest 를 반환한다.
+// CURRENT 가 없으면 (nil, nil)
+func (s *Provider) CurrentManifest() (*FileMetadata, error) {
+	data, err := os.ReadFile(filepath.Join(s.dataDir, currentFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	meta, ok := parseFileName(strings.TrimSpace(string(data)))
+	if !ok || !meta.IsManifest() {
+		return nil, fmt.Errorf("CURRENT points to an invalid manifest: %q", data)
+	}
+	return s.ManifestFile(meta.fileNum), nil
+}
+
+// SetCurrentManifest 는 임시 파일에 쓰고 rename 하는 방식으로 CURRENT 를 원자적으로 교체한다
+func (s *Provider) SetCurrentManifest(meta *FileMetadata) error {
+	tmpPath := filepath.Join(s.dataDir, fmt.Sprintf("%06d.dbtmp", meta.fileNum))
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.WriteString(s.generateFileName(meta) + "\n"); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err = os.Rename(tmpPath, filepath.Join(s.dataDir, currentFileName)); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return s.syncDir()
+}
+
+func (s *Provider) syncDir() error {
+	dir, err := os.Open(s.dataDir)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
@@ -0,0 +1,101 @@
+package wal
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeRecords(t *testing.T, path string, records []string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewWriter(f, SyncEveryWrite, 0)
+	for _, r := range records {
+		assert.NoError(t, w.Append([]byte(r)))
+	}
+	assert.NoError(t, w.Close())
+}
+
+func readRecords(t *testing.T, path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []string
+	for {
+		record, err := r.Next()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, string(record))
+	}
+}
+
+func TestWAL_AppendAndRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "000001.log")
+	writeRecords(t, path, []string{"a", "bb", "", "dddd"})
+
+	records, err := readRecords(t, path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "bb", "", "dddd"}, records)
+}
+
+func TestWAL_TornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "000001.log")
+	writeRecords(t, path, []string{"first", "second", "third"})
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for cut := int64(1); cut < headerSize+int64(len("third")); cut++ {
+		assert.NoError(t, os.Truncate(path, info.Size()-cut))
+		records, err := readRecords(t, path)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"first", "second"}, records)
+		writeRecords(t, path, []string{"first", "second", "third"})
+	}
+}
+
+func TestWAL_CorruptedMiddleRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "000001.log")
+	writeRecords(t, path, []string{"first", "second", "third"})
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[headerSize] ^= 0xff // payload 의 첫 byte
+	assert.NoError(t, os.WriteFile(path, data, 0644))
+
+	_, err = readRecords(t, path)
+	assert.ErrorIs(t, err, ErrCorruption)
+}
+
+func TestWAL_SyncInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "000001.log")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewWriter(f, SyncInterval, 1)
+	assert.NoError(t, w.Append([]byte("record")))
+	assert.NoError(t, w.Close())
+
+	records, err := readRecords(t, path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"record"}, records)
+}
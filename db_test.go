@@ -0,0 +1,110 @@
+package lsm
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_ConcurrentReadersAndWriters(t *testing.T) {
+	db, err := Open(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	const writers, keysPerWriter = 4, 500
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < keysPerWriter; i++ {
+				key := []byte(fmt.Sprintf("w%d-key%04d", w, i))
+				assert.NoError(t, db.Insert(key, key))
+			}
+		}(w)
+	}
+	for r := 0; r < writers; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for i := 0; i < keysPerWriter; i++ {
+				key := []byte(fmt.Sprintf("w%d-key%04d", r, i))
+				val, err := db.Get(key)
+				if err == nil {
+					assert.Equal(t, key, val)
+				}
+			}
+		}(r)
+	}
+	wg.Wait()
+
+	for w := 0; w < writers; w++ {
+		for i := 0; i < keysPerWriter; i++ {
+			key := []byte(fmt.Sprintf("w%d-key%04d", w, i))
+			val, err := db.Get(key)
+			assert.NoError(t, err)
+			assert.Equal(t, key, val)
+		}
+	}
+}
+
+func TestVersion_ObsoleteTableRemovedAfterLastReader(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, db.Insert([]byte("key"), []byte("value")))
+	db.Close()
+
+	db, err = Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	reader := db.currentVersion()
+	sst := reader.levels[0].sstables[0]
+
+	edit := &versionEdit{}
+	edit.deleteTable(0, sst)
+	assert.NoError(t, db.logAndApply(edit))
+	assert.FileExists(t, sst.meta.Path())
+
+	reader.unref()
+	assert.NoFileExists(t, sst.meta.Path())
+}
+
+func TestDB_NewestSequenceWinsAcrossReopen(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 3; i++ {
+		db, err := Open(dir, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.NoError(t, db.Insert([]byte("key"), []byte(fmt.Sprintf("value%d", i))))
+		if i == 2 {
+			assert.NoError(t, db.Delete([]byte("gone")))
+		} else {
+			assert.NoError(t, db.Insert([]byte("gone"), []byte("value")))
+		}
+		db.Close()
+	}
+
+	db, err := Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	assert.Equal(t, uint64(6), db.lastSeq.Load())
+
+	val, err := db.Get([]byte("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value2"), val)
+	_, err = db.Get([]byte("gone"))
+	assert.ErrorIs(t, err, ErrorKeyNotFound)
+}
@@ -11,33 +11,37 @@ type Flusher struct {
 	writer   *TempWriter
 }
 
-func NewFlusher(memtable *Memtable, file *os.File) *Flusher {
+func NewFlusher(memtable *Memtable, file *os.File, opts *Options) *Flusher {
 	return &Flusher{
 		memtable: memtable,
 		file:     file,
-		writer:   NewTempWriter(file),
+		writer:   NewTempWriter(file, opts, 0),
 	}
 }
 
 func (f *Flusher) Flush() error {
+	return f.writer.Write(memtableEntries(f.memtable))
+}
+
+// memtableEntries 는 memtable 의 entry 를 internal key 순서로 모은다
+func memtableEntries(m *Memtable) []*DataEntry {
 	de := make([]*DataEntry, 0, 500)
-	iterator := f.memtable.Iterator()
-	key, val := iterator.Current()
-	entry := &DataEntry{
-		key:    key,
-		value:  val[1:],
-		opType: encoder.OpType(val[0]),
-	}
-	de = append(de, entry)
+	iterator := m.Iterator()
+	internalKey, val := iterator.Current()
+	de = append(de, newDataEntry(internalKey, val))
 	for iterator.HasNext() {
-		key, val = iterator.Next()
-		entry := &DataEntry{
-			key:    key,
-			value:  val[1:],
-			opType: encoder.OpType(val[0]),
-		}
-		de = append(de, entry)
+		internalKey, val = iterator.Next()
+		de = append(de, newDataEntry(internalKey, val))
+	}
+	return de
+}
+
+func newDataEntry(internalKey, val []byte) *DataEntry {
+	key, seq, opType := encoder.ParseInternalKey(internalKey)
+	return &DataEntry{
+		key:    key,
+		value:  val,
+		opType: opType,
+		seq:    seq,
 	}
-	f.writer.Write(de)
-	return nil
 }
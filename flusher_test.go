@@ -2,7 +2,9 @@ package lsm
 
 import (
 	"fmt"
+	"math"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/gptjddldi/lsm/db/storage"
@@ -10,7 +12,7 @@ import (
 )
 
 func TestFlusher_Flush(t *testing.T) {
-	memtable := NewMemtable(1024, false)
+	memtable := NewMemtable(1024, nil)
 	i := 0
 	for memtable.Size() < 1024 {
 		i++
@@ -28,13 +30,31 @@ func TestFlusher_Flush(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	flusher := NewFlusher(memtable, f)
+	flusher := NewFlusher(memtable, f, nil)
 	err = flusher.Flush()
 	if err != nil {
 		t.Fatal(err)
 	}
-	fs, _ := f.Stat()
-	assert.GreaterOrEqual(t, fs.Size(), int64(1024))
+	sst, err := NewSSTable(f, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(i), sst.reader.props.numEntries)
 
 	os.Remove(f.Name())
 }
+
+func TestTempWriter_RejectsOffsetsPastUint32(t *testing.T) {
+	memtable := NewMemtable(1<<20, nil)
+	for i := 0; i < 100; i++ {
+		memtable.Insert([]byte(fmt.Sprintf("key%04d", i)), []byte(fmt.Sprintf("value%04d", i)))
+	}
+	f, err := os.Create(filepath.Join(t.TempDir(), "0_000001.sst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// 앞에 4GB 가까이 쓴 것처럼 offset 을 옮긴다
+	writer := NewTempWriter(f, nil, 0)
+	writer.curOffset = math.MaxUint32 - 100
+	assert.ErrorIs(t, writer.Write(memtableEntries(memtable)), ErrTableTooLarge)
+}
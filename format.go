@@ -0,0 +1,321 @@
+package lsm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/gptjddldi/lsm/db/encoder"
+)
+
+// SSTable 파일 layout (format version 1):
+//
+//	data block ... | index block | filter block | properties block | metaindex block | footer
+//
+// 모든 block 뒤에는 checksum 이 붙는다. footer 는 고정 크기이고 checksum 이 없다:
+//
+//	{ index handle, filter handle, properties handle, metaindex handle, format version (4), magic (8) }
+//
+// handle 은 { offset (8), length (8) }.
+// format version 0 은 magic 이 없는 16 byte footer { index length (8), filter length (8) } 를 쓰고
+// properties, metaindex block 이 없다.
+// format version 2 부터 data block 은 key prefix 를 압축하고 restart 배열을 가진다 (block.go).
+// format version 3 부터 data block 은 { 내용, compression type (1), checksum } 으로 저장되고
+// checksum 은 내용과 compression type 을 함께 덮는다. handle 의 length 는 (압축된) 내용의 길이다.
+// format version 4 는 data block 에 모든 entry 의 offset 을 둔다. Options.BlockEntryOffsets 일 때만 쓴다
+const (
+	formatVersion0       uint32 = 0
+	formatVersion1       uint32 = 1
+	formatVersion2       uint32 = 2
+	formatVersion3       uint32 = 3
+	formatVersion4       uint32 = 4
+	currentFormatVersion        = formatVersion4
+
+	tableMagic = 0x656c626174736d6c // "lsmtable"
+
+	blockHandleSize = 16
+	footerSizeV0    = 16
+	footerSizeV1    = 4*blockHandleSize + 4 + 8
+)
+
+// ErrUnsupportedFormat 은 이 버전이 읽을 수 없는 SSTable 을 열 때 반환된다
+var ErrUnsupportedFormat = errors.New("unsupported sstable format")
+
+// ErrComparatorMismatch 는 SSTable 을 쓴 comparator 와 Options.Comparator 가 다를 때 반환된다
+var ErrComparatorMismatch = errors.New("comparator mismatch")
+
+type blockHandle struct {
+	offset, length uint64
+}
+
+func (h blockHandle) encodeTo(buf []byte) {
+	binary.LittleEndian.PutUint64(buf, h.offset)
+	binary.LittleEndian.PutUint64(buf[8:], h.length)
+}
+
+func decodeBlockHandle(buf []byte) blockHandle {
+	return blockHandle{
+		offset: binary.LittleEndian.Uint64(buf),
+		length: binary.LittleEndian.Uint64(buf[8:]),
+	}
+}
+
+type footer struct {
+	version    uint32
+	index      blockHandle
+	filter     blockHandle
+	properties blockHandle // version 0 에서는 비어있다
+	metaindex  blockHandle // version 0 에서는 비어있다
+}
+
+func (f *footer) encode() []byte {
+	buf := make([]byte, footerSizeV1)
+	f.index.encodeTo(buf)
+	f.filter.encodeTo(buf[blockHandleSize:])
+	f.properties.encodeTo(buf[2*blockHandleSize:])
+	f.metaindex.encodeTo(buf[3*blockHandleSize:])
+	binary.LittleEndian.PutUint32(buf[4*blockHandleSize:], f.version)
+	binary.LittleEndian.PutUint64(buf[4*blockHandleSize+4:], tableMagic)
+	return buf
+}
+
+// readFooter 는 파일 끝의 footer 를 읽는다. magic 이 없으면 version 0 footer 로 읽는다
+func (r *tableReader) readFooter() (*footer, error) {
+	info, err := r.file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	fileSize := uint64(info.Size())
+	if fileSize < footerSizeV0 {
+		return nil, r.corruption(0, "file of %d bytes is too short", fileSize)
+	}
+
+	if fileSize >= footerSizeV1 {
+		buf := make([]byte, footerSizeV1)
+		if _, err = r.file.ReadAt(buf, int64(fileSize-footerSizeV1)); err != nil {
+			return nil, err
+		}
+		if binary.LittleEndian.Uint64(buf[4*blockHandleSize+4:]) == tableMagic {
+			f := &footer{
+				version:    binary.LittleEndian.Uint32(buf[4*blockHandleSize:]),
+				index:      decodeBlockHandle(buf),
+				filter:     decodeBlockHandle(buf[blockHandleSize:]),
+				properties: decodeBlockHandle(buf[2*blockHandleSize:]),
+				metaindex:  decodeBlockHandle(buf[3*blockHandleSize:]),
+			}
+			if f.version > currentFormatVersion {
+				return nil, fmt.Errorf("%w: %s has format version %d, newest supported is %d",
+					ErrUnsupportedFormat, r.file.Name(), f.version, currentFormatVersion)
+			}
+			for _, h := range []blockHandle{f.index, f.filter, f.properties, f.metaindex} {
+				if h.offset > fileSize || h.length+blockTrailerSize > fileSize-h.offset {
+					return nil, r.corruption(fileSize-footerSizeV1, "block handle out of range")
+				}
+			}
+			return f, nil
+		}
+	}
+
+	buf := make([]byte, footerSizeV0)
+	if _, err = r.file.ReadAt(buf, int64(fileSize-footerSizeV0)); err != nil {
+		return nil, err
+	}
+	indexLength := binary.LittleEndian.Uint64(buf[:8])
+	filterLength := binary.LittleEndian.Uint64(buf[8:])
+	metaSize := indexLength + filterLength + 2*blockTrailerSize + footerSizeV0
+	if indexLength > fileSize || filterLength > fileSize || metaSize > fileSize {
+		return nil, r.corruption(fileSize-footerSizeV0, "invalid footer")
+	}
+	indexOffset := fileSize - metaSize
+	return &footer{
+		version: formatVersion0,
+		index:   blockHandle{offset: indexOffset, length: indexLength},
+		filter:  blockHandle{offset: indexOffset + indexLength + blockTrailerSize, length: filterLength},
+	}, nil
+}
+
+// meta block 이름
+const (
+	metaFilterName     = "filter.bloom"
+	metaPropertiesName = "properties"
+	// 문자열 property. properties block 의 값은 uvarint 라서 따로 둔다
+	metaStringPropertiesName = "properties.string"
+	// 쓸 때 학습한 learned index 모델. 모델이 index 보다 나을 때만 있다
+	metaLearnedIndexName = "index.learned"
+)
+
+// tableProperties.indexType 에서 learned index 가 아닌 파일의 값
+const indexTypeBinarySearch = "binary-search"
+
+// metaindex block: { name length, name, handle } 의 반복, 이름 순서
+func encodeMetaIndex(handles map[string]blockHandle) []byte {
+	names := make([]string, 0, len(handles))
+	for name := range handles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf := make([]byte, 0, 64)
+	for _, name := range names {
+		buf = binary.AppendUvarint(buf, uint64(len(name)))
+		buf = append(buf, name...)
+		buf = buf[:len(buf)+blockHandleSize]
+		handles[name].encodeTo(buf[len(buf)-blockHandleSize:])
+	}
+	return buf
+}
+
+func decodeMetaIndex(buf []byte) (map[string]blockHandle, error) {
+	handles := make(map[string]blockHandle)
+	for len(buf) > 0 {
+		nameLen, n := binary.Uvarint(buf)
+		if n <= 0 || nameLen+blockHandleSize > uint64(len(buf)-n) {
+			return nil, fmt.Errorf("invalid metaindex entry")
+		}
+		name := string(buf[n : n+int(nameLen)])
+		buf = buf[n+int(nameLen):]
+		handles[name] = decodeBlockHandle(buf)
+		buf = buf[blockHandleSize:]
+	}
+	return handles, nil
+}
+
+// tableProperties 는 SSTable 을 쓸 때 모은 통계
+type tableProperties struct {
+	numEntries    uint64
+	numDeletions  uint64
+	numDataBlocks uint64
+	rawKeySize    uint64 // internal key 기준
+	rawValueSize  uint64
+	smallestSeq   uint64
+	largestSeq    uint64
+	filterSize    uint64 // bloom filter block 의 크기 (bytes)
+	filterBits    uint64 // bloom filter 의 key 당 bit 수
+
+	comparator      string // key 를 정렬한 Comparator 의 이름. 예전 파일에는 없다
+	indexType       string // indexTypeBinarySearch 또는 learned index 모델 이름. 예전 파일에는 없다
+	prefixExtractor string // bloom filter 에 prefix 를 넣은 PrefixExtractor 의 이름, 없으면 ""
+}
+
+type tableStringProperty struct {
+	name  string
+	value *string
+}
+
+type tableProperty struct {
+	name  string
+	value *uint64
+}
+
+// properties block: { name length, name, value (uvarint) } 의 반복.
+// 모르는 이름은 무시하므로 나중에 property 를 추가해도 이전 버전이 읽을 수 있다
+func (p *tableProperties) fields() []tableProperty {
+	return []tableProperty{
+		{"lsm.num.entries", &p.numEntries},
+		{"lsm.num.deletions", &p.numDeletions},
+		{"lsm.num.data.blocks", &p.numDataBlocks},
+		{"lsm.raw.key.size", &p.rawKeySize},
+		{"lsm.raw.value.size", &p.rawValueSize},
+		{"lsm.smallest.seq", &p.smallestSeq},
+		{"lsm.largest.seq", &p.largestSeq},
+		{"lsm.filter.size", &p.filterSize},
+		{"lsm.filter.bits.per.key", &p.filterBits},
+	}
+}
+
+// string properties block: { name length, name, value length, value } 의 반복.
+// 빈 값은 쓰지 않는다
+func (p *tableProperties) stringFields() []tableStringProperty {
+	return []tableStringProperty{
+		{"lsm.comparator", &p.comparator},
+		{"lsm.index.type", &p.indexType},
+		{"lsm.prefix.extractor", &p.prefixExtractor},
+	}
+}
+
+func (p *tableProperties) encodeStrings() []byte {
+	var buf []byte
+	for _, f := range p.stringFields() {
+		if *f.value == "" {
+			continue
+		}
+		buf = binary.AppendUvarint(buf, uint64(len(f.name)))
+		buf = append(buf, f.name...)
+		buf = binary.AppendUvarint(buf, uint64(len(*f.value)))
+		buf = append(buf, *f.value...)
+	}
+	return buf
+}
+
+func (p *tableProperties) decodeStrings(buf []byte) error {
+	values := make(map[string]*string)
+	for _, f := range p.stringFields() {
+		values[f.name] = f.value
+	}
+	for len(buf) > 0 {
+		var fields [2]string
+		for i := range fields {
+			l, n := binary.Uvarint(buf)
+			if n <= 0 || l > uint64(len(buf)-n) {
+				return fmt.Errorf("invalid string property")
+			}
+			fields[i] = string(buf[n : n+int(l)])
+			buf = buf[n+int(l):]
+		}
+		if dst, ok := values[fields[0]]; ok {
+			*dst = fields[1]
+		}
+	}
+	return nil
+}
+
+func (p *tableProperties) encode() []byte {
+	buf := make([]byte, 0, 128)
+	for _, f := range p.fields() {
+		buf = binary.AppendUvarint(buf, uint64(len(f.name)))
+		buf = append(buf, f.name...)
+		buf = binary.AppendUvarint(buf, *f.value)
+	}
+	return buf
+}
+
+func decodeTableProperties(buf []byte) (*tableProperties, error) {
+	p := &tableProperties{}
+	values := make(map[string]*uint64)
+	for _, f := range p.fields() {
+		values[f.name] = f.value
+	}
+	for len(buf) > 0 {
+		nameLen, n := binary.Uvarint(buf)
+		if n <= 0 || nameLen > uint64(len(buf)-n) {
+			return nil, fmt.Errorf("invalid property name")
+		}
+		name := string(buf[n : n+int(nameLen)])
+		buf = buf[n+int(nameLen):]
+		v, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return nil, fmt.Errorf("invalid value of property %q", name)
+		}
+		buf = buf[n:]
+		if dst, ok := values[name]; ok {
+			*dst = v
+		}
+	}
+	return p, nil
+}
+
+func (p *tableProperties) add(entry *DataEntry) {
+	if p.numEntries == 0 || entry.seq < p.smallestSeq {
+		p.smallestSeq = entry.seq
+	}
+	if entry.seq > p.largestSeq {
+		p.largestSeq = entry.seq
+	}
+	p.numEntries++
+	if entry.opType == encoder.OpTypeDelete {
+		p.numDeletions++
+	}
+	p.rawKeySize += uint64(len(entry.key) + encoder.TrailerSize)
+	p.rawValueSize += uint64(len(entry.value))
+}
@@ -0,0 +1,77 @@
+package lsm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestSSTableVersion(t *testing.T, version uint32) string {
+	return writeTestSSTable(t, testSSTableSpec{
+		version: versionPtr(version),
+		extra:   func(m *Memtable) { m.InsertTombstone([]byte("key0000")) },
+	})
+}
+
+func TestSSTable_FormatVersion1(t *testing.T) {
+	sst, err := openTestSSTable(t, writeTestSSTableVersion(t, formatVersion1))
+	assert.NoError(t, err)
+	assert.Equal(t, formatVersion1, sst.reader.footer.version)
+	assert.Equal(t, uint64(101), sst.reader.props.numEntries)
+	assert.Equal(t, uint64(1), sst.reader.props.numDeletions)
+	assert.Equal(t, uint64(1), sst.reader.props.smallestSeq)
+	assert.Equal(t, uint64(101), sst.reader.props.largestSeq)
+	assert.Equal(t, uint64(len((*sst.reader.index).Entries())), sst.reader.props.numDataBlocks)
+	assert.Equal(t, sst.reader.footer.filter, sst.reader.metaindex[metaFilterName])
+	assert.Equal(t, sst.reader.footer.properties, sst.reader.metaindex[metaPropertiesName])
+
+	val, err := sst.Get([]byte("key0042"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value0042"), val.Value())
+	assert.NoError(t, sst.verifyChecksums())
+}
+
+func TestSSTable_ReadsFormatVersion0(t *testing.T) {
+	sst, err := openTestSSTable(t, writeTestSSTableVersion(t, formatVersion0))
+	assert.NoError(t, err)
+	assert.Equal(t, formatVersion0, sst.reader.footer.version)
+	assert.Empty(t, sst.reader.metaindex)
+
+	val, err := sst.Get([]byte("key0042"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value0042"), val.Value())
+	val, err = sst.Get([]byte("key0000"))
+	assert.NoError(t, err)
+	assert.True(t, val.IsTombstone())
+	assert.NoError(t, sst.verifyChecksums())
+}
+
+func TestSSTable_RejectsUnknownFormatVersion(t *testing.T) {
+	path := writeTestSSTableVersion(t, currentFormatVersion)
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0644)
+	assert.NoError(t, err)
+	version := make([]byte, 4)
+	binary.LittleEndian.PutUint32(version, currentFormatVersion+1)
+	_, err = f.WriteAt(version, info.Size()-12)
+	assert.NoError(t, err)
+	f.Close()
+
+	_, err = openTestSSTable(t, path)
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
+	assert.ErrorContains(t, err, fmt.Sprintf("format version %d", currentFormatVersion+1))
+}
+
+func TestSSTable_RejectsNonSSTableFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "0_000001.sst")
+	assert.NoError(t, os.WriteFile(path, []byte("this is not an sstable, just some text"), 0644))
+
+	_, err := openTestSSTable(t, path)
+	assert.ErrorIs(t, err, ErrCorruption)
+}
@@ -3,26 +3,40 @@ package lsm
 import (
 	"bytes"
 	"encoding/binary"
+	"sync/atomic"
 
 	"github.com/gptjddldi/lsm/db/compare"
 )
 
+// BaseIndex 는 internal key 로 그 key 가 들어있을 수 있는 block 을 찾는다
 type BaseIndex interface {
 	Get(searchKey []byte) IndexEntry
 	FirstEntry() IndexEntry
 	LastEntry() IndexEntry
+	Entries() []IndexEntry
 }
 
 type Index struct {
 	entries []IndexEntry
+	cmp     compare.Comparator
 }
 
 type IndexEntry struct {
-	key   []byte
+	key   []byte // block 마지막 entry 의 internal key
 	value []byte
 }
 
-func NewIndex(indexBytes []byte) BaseIndex {
+// blockHandle 은 entry 가 가리키는 data block 의 (offset, length). length 에 checksum 은 포함되지 않는다
+func (ie IndexEntry) blockHandle() (uint64, uint64) {
+	return uint64(binary.LittleEndian.Uint32(ie.value[:4])), uint64(binary.LittleEndian.Uint32(ie.value[4:8]))
+}
+
+func NewIndex(indexBytes []byte, cmp compare.Comparator) BaseIndex {
+	return &Index{entries: parseIndexEntries(indexBytes), cmp: cmp}
+}
+
+// index block: { internal key length, value length, internal key, (offset, length) } 의 반복
+func parseIndexEntries(indexBytes []byte) []IndexEntry {
 	var entries []IndexEntry
 	buf := bytes.NewBuffer(indexBytes)
 
@@ -35,28 +49,29 @@ func NewIndex(indexBytes []byte) BaseIndex {
 		if valLen == 0 {
 			break
 		}
-		valLen -= 1 // subtract the added 1
 
 		key := make([]byte, keyLen)
 		buf.Read(key)
 
-		// Skip the operation type byte
-		buf.ReadByte()
-
 		val := make([]byte, valLen)
 		buf.Read(val)
 
 		entries = append(entries, IndexEntry{key: key, value: val})
 	}
-
-	return &Index{entries: entries}
+	return entries
 }
 
 func (idx *Index) Get(searchKey []byte) IndexEntry {
+	entry, _ := idx.find(searchKey)
+	return entry
+}
+
+// find 는 Get 과 같고, binary search 한 범위의 entry 수도 반환한다
+func (idx *Index) find(searchKey []byte) (IndexEntry, int) {
 	low, high := 0, len(idx.entries)-1
 	offset := idx.binarySearch(searchKey, low, high)
 
-	return idx.entries[offset]
+	return idx.entries[offset], len(idx.entries)
 }
 
 func (idx *Index) FirstEntry() IndexEntry {
@@ -67,11 +82,15 @@ func (idx *Index) LastEntry() IndexEntry {
 	return idx.entries[len(idx.entries)-1]
 }
 
+func (idx *Index) Entries() []IndexEntry {
+	return idx.entries
+}
+
 func (idx *Index) binarySearch(searchKey []byte, low, high int) int {
 	high = min(high, len(idx.entries))
 	for low < high {
 		mid := (low + high) / 2
-		cmp := compare.Compare(searchKey, idx.entries[mid].key, false)
+		cmp := compare.CompareInternal(idx.cmp, searchKey, idx.entries[mid].key)
 		if cmp > 0 {
 			low = mid + 1
 		} else {
@@ -80,3 +99,61 @@ func (idx *Index) binarySearch(searchKey []byte, low, high int) int {
 	}
 	return low
 }
+
+// IndexStats 는 SSTable index 종류별로 처리한 lookup 수와 binary search 한 범위의 평균 entry 수
+type IndexStats struct {
+	BinarySearchLookups   uint64
+	LearnedLookups        uint64
+	AvgBinarySearchWindow float64
+	AvgLearnedWindow      float64
+}
+
+// indexStats 는 DB 의 모든 SSTable index 가 공유한다
+type indexStats struct {
+	binarySearch, learned indexCounter
+}
+
+type indexCounter struct {
+	lookups atomic.Uint64
+	window  atomic.Uint64 // binary search 한 범위의 entry 수 합
+}
+
+func (c *indexCounter) record(window int) {
+	c.lookups.Add(1)
+	c.window.Add(uint64(window))
+}
+
+func (c *indexCounter) load() (uint64, float64) {
+	lookups, window := c.lookups.Load(), c.window.Load()
+	if lookups == 0 {
+		return 0, 0
+	}
+	return lookups, float64(window) / float64(lookups)
+}
+
+func (s *indexStats) stats() IndexStats {
+	var st IndexStats
+	st.BinarySearchLookups, st.AvgBinarySearchWindow = s.binarySearch.load()
+	st.LearnedLookups, st.AvgLearnedWindow = s.learned.load()
+	return st
+}
+
+// findIndexEntry 는 idx 에서 searchKey 의 entry 를 찾고 stats 에 기록한다. stats 가 nil 이면 기록하지 않는다
+func findIndexEntry(idx BaseIndex, searchKey []byte, stats *indexStats) IndexEntry {
+	switch idx := idx.(type) {
+	case *LearnedIndex:
+		entry, window := idx.find(searchKey)
+		if stats != nil {
+			stats.learned.record(window)
+		}
+		return entry
+	case *Index:
+		entry, window := idx.find(searchKey)
+		if stats != nil {
+			stats.binarySearch.record(window)
+		}
+		return entry
+	default:
+		return idx.Get(searchKey)
+	}
+}
@@ -0,0 +1,326 @@
+package lsm
+
+import (
+	"bytes"
+	"container/heap"
+
+	"github.com/gptjddldi/lsm/db/compare"
+	"github.com/gptjddldi/lsm/db/encoder"
+)
+
+// internalIterator 는 memtable 과 SSTable 을 같은 방식으로 순회하기 위한 interface.
+// 이동 메서드는 이동한 위치에 entry 가 있는지를 반환한다.
+// 아직 아무 위치도 가리키지 않는 iterator 에서 Next 는 첫 entry, Prev 는 마지막 entry 로 이동한다
+type internalIterator interface {
+	SeekToFirst() (bool, error)
+	SeekToLast() (bool, error)
+	Seek(key []byte) (bool, error)
+	Next() (bool, error)
+	Prev() (bool, error)
+	Key() []byte // user key
+	Seq() uint64
+	Value() []byte
+	OpType() encoder.OpType
+	Close() error
+}
+
+type ReadOptions struct {
+	LowerBound []byte    // inclusive, nil 이면 제한 없음
+	UpperBound []byte    // exclusive, nil 이면 제한 없음
+	Snapshot   *Snapshot // nil 이면 읽기 시작 시점의 최신 데이터를 읽는다
+
+	// true 면 SSTable 에서 읽는 data block 마다 checksum 을 확인한다.
+	// index 와 bloom filter block 은 SSTable 을 열 때 항상 확인한다
+	VerifyChecksums bool
+
+	// true 면 디스크에서 읽은 data block 을 block cache 에 넣지 않는다. 한 번만 읽는 대량 scan 은 true 로 둔다
+	DontFillCache bool
+
+	// true 면 Seek 한 key 와 prefix 가 같은 key 만 보여준다. Options.PrefixExtractor 로 prefix 를 뽑고,
+	// bloom filter 에 그 prefix 가 없는 SSTable 은 읽지 않는다.
+	// PrefixExtractor 가 없거나 Seek 한 key 에서 prefix 를 뽑을 수 없으면 효과가 없다
+	PrefixSameAsStart bool
+}
+
+// prefixFilter 는 bloom filter 로 prefix 를 확인할 수 있는 child iterator
+type prefixFilter interface {
+	prefixMayMatch(extractor PrefixExtractor, prefix []byte) bool
+}
+
+// Iterator 는 memtable 과 모든 level 을 합쳐 key 순서대로 보여준다.
+// 같은 key 는 sequence 가 가장 큰 값만 보이고, 삭제된 key 는 보이지 않는다.
+// Iterator 는 만들어진 시점의 version 을 잡고 있으므로 다 쓴 뒤 Close 해야 한다.
+// 하나의 Iterator 를 여러 goroutine 에서 동시에 사용하면 안 된다
+type Iterator struct {
+	children []internalIterator
+	heap     *MinHeap
+	version  *version
+	seq      uint64 // 이보다 큰 sequence 의 entry 는 보이지 않는다
+	cmp      compare.Comparator
+
+	lower, upper []byte
+
+	prefixExtractor PrefixExtractor // PrefixSameAsStart 가 아니면 nil
+	prefix          []byte          // 마지막 Seek 의 prefix, nil 이면 prefix 로 거르지 않는다
+
+	key   []byte
+	value []byte
+	valid bool
+	err   error
+}
+
+func (db *DB) NewIterator(opts *ReadOptions) *Iterator {
+	ro := newBlockReadOptions(opts)
+	if opts == nil {
+		opts = &ReadOptions{}
+	}
+	rs := db.getReadState()
+	seq := db.lastSeq.Load()
+	if opts.Snapshot != nil {
+		seq = opts.Snapshot.seq
+	}
+
+	var prefixExtractor PrefixExtractor
+	if opts.PrefixSameAsStart {
+		prefixExtractor = db.opts.PrefixExtractor
+	}
+
+	children := make([]internalIterator, 0)
+	children = append(children, rs.mutable.newIterator())
+	for i := len(rs.queue) - 1; i >= 0; i-- {
+		children = append(children, rs.queue[i].newIterator())
+	}
+	for _, l := range rs.version.levels {
+		for _, sst := range l.sstables {
+			children = append(children, sst.newIterator(ro))
+		}
+	}
+
+	return &Iterator{
+		children:        children,
+		version:         rs.version,
+		seq:             seq,
+		cmp:             db.opts.Comparator,
+		lower:           opts.LowerBound,
+		upper:           opts.UpperBound,
+		prefixExtractor: prefixExtractor,
+	}
+}
+
+func (it *Iterator) compare(a, b []byte) int {
+	return it.cmp.Compare(a, b)
+}
+
+// Valid 는 iterator 가 entry 를 가리키고 있는지 반환한다
+func (it *Iterator) Valid() bool {
+	return it.valid
+}
+
+func (it *Iterator) Key() []byte {
+	return it.key
+}
+
+func (it *Iterator) Value() []byte {
+	return it.value
+}
+
+// Error 는 순회 중에 발생한 에러를 반환한다
+func (it *Iterator) Error() error {
+	return it.err
+}
+
+func (it *Iterator) Close() error {
+	for _, child := range it.children {
+		child.Close()
+	}
+	it.children = nil
+	it.valid = false
+	if it.version != nil {
+		it.version.unref()
+		it.version = nil
+	}
+	return it.err
+}
+
+func (it *Iterator) SeekToFirst() bool {
+	it.prefix = nil
+	if it.lower != nil {
+		return it.Seek(it.lower)
+	}
+	return it.reposition(false, func(child internalIterator) (bool, error) {
+		return child.SeekToFirst()
+	})
+}
+
+func (it *Iterator) SeekToLast() bool {
+	it.prefix = nil
+	if it.upper != nil {
+		return it.seekBefore(it.upper)
+	}
+	return it.reposition(true, func(child internalIterator) (bool, error) {
+		return child.SeekToLast()
+	})
+}
+
+// Seek 는 key 보다 크거나 같은 첫 번째 key 로 이동한다
+func (it *Iterator) Seek(key []byte) bool {
+	if it.lower != nil && it.compare(key, it.lower) < 0 {
+		key = it.lower
+	}
+	it.prefix = nil
+	if it.prefixExtractor != nil && it.prefixExtractor.InDomain(key) {
+		it.prefix = append([]byte(nil), it.prefixExtractor.Transform(key)...)
+	}
+	return it.reposition(false, func(child internalIterator) (bool, error) {
+		return child.Seek(key)
+	})
+}
+
+func (it *Iterator) Next() bool {
+	if !it.valid {
+		return false
+	}
+	if it.heap.reverse {
+		// 모든 child 를 현재 key 다음으로 옮긴다
+		key := it.key
+		return it.reposition(false, func(child internalIterator) (bool, error) {
+			ok, err := child.Seek(key)
+			for ok && err == nil && it.compare(child.Key(), key) == 0 {
+				ok, err = child.Next()
+			}
+			return ok, err
+		})
+	}
+	return it.findNextVisible()
+}
+
+func (it *Iterator) Prev() bool {
+	if !it.valid {
+		return false
+	}
+	if !it.heap.reverse {
+		return it.seekBefore(it.key)
+	}
+	return it.findNextVisible()
+}
+
+// seekBefore 는 key 보다 작은 마지막 key 로 이동한다
+func (it *Iterator) seekBefore(key []byte) bool {
+	return it.reposition(true, func(child internalIterator) (bool, error) {
+		ok, err := child.Seek(key)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			return child.SeekToLast()
+		}
+		return child.Prev()
+	})
+}
+
+// reposition 은 모든 child 를 position 으로 옮기고 heap 을 다시 만든 뒤
+// 그 방향으로 처음 보이는 entry 를 찾는다
+func (it *Iterator) reposition(reverse bool, position func(child internalIterator) (bool, error)) bool {
+	if it.err != nil {
+		it.valid = false
+		return false
+	}
+	it.heap = &MinHeap{cmp: it.cmp, reverse: reverse}
+	for _, child := range it.children {
+		if f, ok := child.(prefixFilter); ok && it.prefix != nil && !f.prefixMayMatch(it.prefixExtractor, it.prefix) {
+			continue
+		}
+		ok, err := position(child)
+		if err != nil {
+			return it.fail(err)
+		}
+		if ok {
+			it.heap.items = append(it.heap.items, newHeapItem(child))
+		}
+	}
+	heap.Init(it.heap)
+	return it.findNextVisible()
+}
+
+// findNextVisible 은 heap 에서 다음 key 를 꺼내, 그 key 의 entry 를 모두 지나가게 한다.
+// 역방향에서는 같은 key 의 entry 가 오래된 것부터 나오므로 sequence 로 가장 최근 entry 를 고른다.
+// it.seq 보다 나중에 쓰인 entry 는 무시하고, 가장 최근 entry 가 tombstone 이면 건너뛴다
+func (it *Iterator) findNextVisible() bool {
+	for it.heap.Len() > 0 {
+		key := it.heap.items[0].key
+		var newest *MinHeapItem
+
+		for it.heap.Len() > 0 && it.compare(it.heap.items[0].key, key) == 0 {
+			top := it.heap.items[0]
+			if top.seq <= it.seq && (newest == nil || top.seq > newest.seq) {
+				entry := *top
+				newest = &entry
+			}
+			if err := it.advance(top); err != nil {
+				return it.fail(err)
+			}
+		}
+
+		if it.outOfBounds(key) {
+			break
+		}
+		if newest == nil || newest.opType == encoder.OpTypeDelete {
+			continue
+		}
+		it.key, it.value, it.valid = key, newest.value, true
+		return true
+	}
+	it.valid = false
+	return false
+}
+
+// advance 는 heap 의 top 에 있는 child 를 현재 방향으로 한 칸 옮긴다
+func (it *Iterator) advance(item *MinHeapItem) error {
+	var ok bool
+	var err error
+	if it.heap.reverse {
+		ok, err = item.iterator.Prev()
+	} else {
+		ok, err = item.iterator.Next()
+	}
+	if err != nil {
+		return err
+	}
+	if !ok {
+		heap.Pop(it.heap)
+		return nil
+	}
+	*item = *newHeapItem(item.iterator)
+	heap.Fix(it.heap, 0)
+	return nil
+}
+
+func (it *Iterator) outOfBounds(key []byte) bool {
+	if it.prefix != nil && !it.hasPrefix(key) {
+		return true
+	}
+	if it.heap.reverse {
+		return it.lower != nil && it.compare(key, it.lower) < 0
+	}
+	return it.upper != nil && it.compare(key, it.upper) >= 0
+}
+
+func (it *Iterator) hasPrefix(key []byte) bool {
+	return it.prefixExtractor.InDomain(key) && bytes.Equal(it.prefixExtractor.Transform(key), it.prefix)
+}
+
+func (it *Iterator) fail(err error) bool {
+	it.err = err
+	it.valid = false
+	return false
+}
+
+func newHeapItem(iterator internalIterator) *MinHeapItem {
+	return &MinHeapItem{
+		iterator: iterator,
+		key:      iterator.Key(),
+		value:    iterator.Value(),
+		opType:   iterator.OpType(),
+		seq:      iterator.Seq(),
+	}
+}
@@ -0,0 +1,137 @@
+package lsm
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// openIteratorTestDB 는 SSTable 두 개와 memtable 에 걸쳐 덮어쓰기 / 삭제가 섞인 DB 를 만든다.
+// 최종적으로 보여야 하는 값을 함께 반환한다
+func openIteratorTestDB(t *testing.T) (*DB, map[string]string) {
+	dir := t.TempDir()
+	expected := make(map[string]string)
+
+	db, err := Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%03d", i)
+		assert.NoError(t, db.Insert([]byte(key), []byte("v1")))
+		expected[key] = "v1"
+	}
+	db.Close()
+
+	db, err = Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i += 3 {
+		key := fmt.Sprintf("key%03d", i)
+		assert.NoError(t, db.Insert([]byte(key), []byte("v2")))
+		expected[key] = "v2"
+	}
+	db.Close()
+
+	db, err = Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i += 5 {
+		key := fmt.Sprintf("key%03d", i)
+		assert.NoError(t, db.Delete([]byte(key)))
+		delete(expected, key)
+	}
+	for i := 100; i < 110; i++ {
+		key := fmt.Sprintf("key%03d", i)
+		assert.NoError(t, db.Insert([]byte(key), []byte("v3")))
+		expected[key] = "v3"
+	}
+	return db, expected
+}
+
+func sortedExpectedKeys(expected map[string]string) []string {
+	keys := make([]string, 0, len(expected))
+	for i := 0; i < 110; i++ {
+		key := fmt.Sprintf("key%03d", i)
+		if _, ok := expected[key]; ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func TestIterator_ForwardAndBackward(t *testing.T) {
+	db, expected := openIteratorTestDB(t)
+	defer db.Close()
+	keys := sortedExpectedKeys(expected)
+
+	it := db.NewIterator(nil)
+	defer it.Close()
+
+	var got []string
+	for ok := it.SeekToFirst(); ok; ok = it.Next() {
+		got = append(got, string(it.Key()))
+		assert.Equal(t, expected[string(it.Key())], string(it.Value()))
+	}
+	assert.NoError(t, it.Error())
+	assert.Equal(t, keys, got)
+
+	got = got[:0]
+	for ok := it.SeekToLast(); ok; ok = it.Prev() {
+		got = append([]string{string(it.Key())}, got...)
+	}
+	assert.Equal(t, keys, got)
+}
+
+func TestIterator_SeekAndChangeDirection(t *testing.T) {
+	db, _ := openIteratorTestDB(t)
+	defer db.Close()
+
+	it := db.NewIterator(nil)
+	defer it.Close()
+
+	assert.True(t, it.Seek([]byte("key050")))
+	assert.Equal(t, "key051", string(it.Key())) // key050 은 삭제됨
+
+	assert.True(t, it.Prev())
+	assert.Equal(t, "key049", string(it.Key()))
+	assert.True(t, it.Next())
+	assert.Equal(t, "key051", string(it.Key()))
+	assert.True(t, it.Next())
+	assert.Equal(t, "key052", string(it.Key()))
+	assert.True(t, it.Prev())
+	assert.Equal(t, "key051", string(it.Key()))
+
+	assert.False(t, it.Seek([]byte("key999")))
+}
+
+func TestIterator_Bounds(t *testing.T) {
+	db, expected := openIteratorTestDB(t)
+	defer db.Close()
+
+	it := db.NewIterator(&ReadOptions{
+		LowerBound: []byte("key010"),
+		UpperBound: []byte("key020"),
+	})
+	defer it.Close()
+
+	var got []string
+	for ok := it.SeekToFirst(); ok; ok = it.Next() {
+		got = append(got, string(it.Key()))
+	}
+	var want []string
+	for _, key := range sortedExpectedKeys(expected) {
+		if key >= "key010" && key < "key020" {
+			want = append(want, key)
+		}
+	}
+	assert.Equal(t, want, got)
+
+	assert.True(t, it.SeekToLast())
+	assert.Equal(t, "key019", string(it.Key()))
+	assert.True(t, it.Seek([]byte("key000")))
+	assert.Equal(t, "key011", string(it.Key()))
+}
@@ -3,63 +3,212 @@ package lsm
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
+	"math"
+
 	"github.com/gptjddldi/lsm/db/compare"
+	"github.com/gptjddldi/lsm/db/encoder"
 	"github.com/gptjddldi/lsm/db/regression"
 )
 
+// LearnedIndexModel 은 learned index 가 key 로 위치를 예측하는 모델
+type LearnedIndexModel int
+
+const (
+	LinearModel          LearnedIndexModel = iota // 모든 key 에 직선 하나
+	PiecewiseLinearModel                          // 오차가 learnedIndexEpsilon 이하인 여러 직선 (PGM)
+	RadixSplineModel                              // 오차가 learnedIndexEpsilon 이하인 spline 과 radix table
+)
+
+const (
+	// learnedIndexEpsilon 은 piecewise linear, radix spline 모델이 학습할 때 허용하는 위치 오차
+	learnedIndexEpsilon = 16
+	// learnedIndexRadixBits 는 radix spline 의 radix table 크기. spline 점이 적으면 더 작게 만든다
+	learnedIndexRadixBits = 16
+)
+
+func (m LearnedIndexModel) String() string {
+	switch m {
+	case LinearModel:
+		return "linear"
+	case PiecewiseLinearModel:
+		return "piecewise-linear"
+	case RadixSplineModel:
+		return "radix-spline"
+	default:
+		return fmt.Sprintf("LearnedIndexModel(%d)", int(m))
+	}
+}
+
+func (m LearnedIndexModel) valid() bool {
+	return m >= LinearModel && m <= RadixSplineModel
+}
+
+// newModel 은 학습하지 않은 모델을 만든다
+func (m LearnedIndexModel) newModel() regression.Model {
+	switch m {
+	case PiecewiseLinearModel:
+		return regression.NewPiecewiseLinear(learnedIndexEpsilon)
+	case RadixSplineModel:
+		return regression.NewRadixSpline(learnedIndexEpsilon, learnedIndexRadixBits)
+	default:
+		return regression.NewRegression()
+	}
+}
+
+// learnedIndexMaxWindowRatio 는 learned index 가 탐색하는 범위가 차지할 수 있는 entry 비율.
+// 모델의 오차로 이보다 넓은 범위를 찾아야 하면 binary search 보다 나을 것이 없으므로 Index 를 쓴다
+const learnedIndexMaxWindowRatio = 0.25
+
+// LearnedIndex 는 user key 를 uint64 로 바꿔 index entry 의 위치를 예측하고,
+// 학습할 때 잰 최대 오차만큼의 범위 안에서만 binary search 한다
 type LearnedIndex struct {
-	entries []IndexEntry
-	learned *regression.LinearRegression
-}
-
-func NewLearnedIndex(indexBytes []byte) BaseIndex {
-	var entries []IndexEntry
-	buf := bytes.NewBuffer(indexBytes)
-	x := make([]uint64, 0)
-	y := make([]uint64, 0)
-	i := 0
-	for {
-		keyLen, _ := binary.ReadUvarint(buf)
-		if keyLen == 0 {
-			break
-		}
-		valLen, _ := binary.ReadUvarint(buf)
-		if valLen == 0 {
-			break
+	entries  []IndexEntry
+	kind     LearnedIndexModel
+	model    regression.Model
+	mapper   keyMapper
+	maxOver  float64 // 학습한 entry 에서 예측 위치가 실제 위치보다 큰 최대 차이
+	maxUnder float64 // 학습한 entry 에서 예측 위치가 실제 위치보다 작은 최대 차이
+	cmp      compare.Comparator
+}
+
+// NewLearnedIndex 는 index entry 로 kind 모델을 학습한다. key 를 순서대로 uint64 로 바꿀 수 없거나
+// 모델의 오차가 크면 Index 를 반환한다
+func NewLearnedIndex(indexBytes []byte, cmp compare.Comparator, kind LearnedIndexModel) BaseIndex {
+	entries := parseIndexEntries(indexBytes)
+	if idx := trainLearnedIndex(entries, cmp, kind); idx != nil {
+		return idx
+	}
+	return &Index{entries: entries, cmp: cmp}
+}
+
+// trainLearnedIndex 는 학습한 LearnedIndex 를 반환한다. Index 를 써야 하면 nil 을 반환한다
+func trainLearnedIndex(entries []IndexEntry, cmp compare.Comparator, kind LearnedIndexModel) *LearnedIndex {
+	if !keyMapperSupports(cmp) {
+		return nil
+	}
+	userKeys := make([][]byte, len(entries))
+	for i, entry := range entries {
+		userKeys[i] = encoder.UserKey(entry.key)
+	}
+	mapper := newKeyMapper(userKeys, cmp)
+
+	x := make([]uint64, 0, len(entries))
+	y := make([]uint64, 0, len(entries))
+	for i, key := range userKeys {
+		mapped, ok := mapper.mapKey(key)
+		if !ok || i > 0 && mapped < x[i-1] {
+			// cmp 순서를 보존하지 못한다
+			return nil
 		}
-		valLen -= 1 // subtract the added 1
+		x = append(x, mapped)
+		y = append(y, uint64(i))
+	}
+	model := kind.newModel()
+	model.Train(x, y)
 
-		key := make([]byte, keyLen)
-		buf.Read(key)
+	idx := &LearnedIndex{entries: entries, kind: kind, model: model, mapper: mapper, cmp: cmp}
+	for i := range x {
+		diff := idx.predict(x[i]) - float64(i)
+		idx.maxOver = math.Max(idx.maxOver, diff)
+		idx.maxUnder = math.Max(idx.maxUnder, -diff)
+	}
+	if math.IsNaN(idx.maxOver) || math.IsNaN(idx.maxUnder) ||
+		idx.maxOver+idx.maxUnder+2 > learnedIndexMaxWindowRatio*float64(len(entries)) {
+		return nil
+	}
+	return idx
+}
 
-		// Skip the operation type byte
-		buf.ReadByte()
+// learned index meta block:
+//
+//	{ model (1), maxOver (8), maxUnder (8), reverse (1), width (uvarint), prefix length (uvarint), prefix, model encoding }
+//
+// index entry 는 index block 에서 읽으므로 저장하지 않는다
+func (idx *LearnedIndex) encode() ([]byte, error) {
+	model, err := idx.model.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	buf := []byte{byte(idx.kind)}
+	buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(idx.maxOver))
+	buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(idx.maxUnder))
+	if idx.mapper.reverse {
+		buf = append(buf, 1)
+	} else {
+		buf = append(buf, 0)
+	}
+	buf = binary.AppendUvarint(buf, uint64(idx.mapper.width))
+	buf = binary.AppendUvarint(buf, uint64(len(idx.mapper.prefix)))
+	buf = append(buf, idx.mapper.prefix...)
+	return append(buf, model...), nil
+}
 
-		val := make([]byte, valLen)
-		buf.Read(val)
+// decodeLearnedIndex 는 저장된 모델을 다시 학습하지 않고 읽는다
+func decodeLearnedIndex(buf []byte, entries []IndexEntry, cmp compare.Comparator) (*LearnedIndex, error) {
+	if len(buf) < 18 {
+		return nil, fmt.Errorf("learned index block of %d bytes is too short", len(buf))
+	}
+	idx := &LearnedIndex{entries: entries, kind: LearnedIndexModel(buf[0]), cmp: cmp}
+	if !idx.kind.valid() {
+		return nil, fmt.Errorf("unknown learned index model %d", buf[0])
+	}
+	idx.maxOver = math.Float64frombits(binary.LittleEndian.Uint64(buf[1:]))
+	idx.maxUnder = math.Float64frombits(binary.LittleEndian.Uint64(buf[9:]))
+	idx.mapper.reverse = buf[17] == 1
+	buf = buf[18:]
 
-		str := uint64(compare.ByteToInt(key))
-		x = append(x, str)
-		y = append(y, uint64(i))
-		i++
+	width, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid learned index key width")
+	}
+	buf = buf[n:]
+	prefixLen, n := binary.Uvarint(buf)
+	if n <= 0 || prefixLen > uint64(len(buf)-n) {
+		return nil, fmt.Errorf("invalid learned index key prefix")
+	}
+	idx.mapper.width = int(width)
+	idx.mapper.prefix = buf[n : n+int(prefixLen)]
+	buf = buf[n+int(prefixLen):]
 
-		entries = append(entries, IndexEntry{key: key, value: val})
+	idx.model = idx.kind.newModel()
+	if err := idx.model.UnmarshalBinary(buf); err != nil {
+		return nil, err
 	}
-	b := regression.NewRegression()
-	b.Train(x, y)
+	return idx, nil
+}
 
-	return &LearnedIndex{entries: entries, learned: b}
+func (idx *LearnedIndex) predict(x uint64) float64 {
+	return idx.model.Estimate(x)
 }
 
+// Get 은 searchKey 보다 크거나 같은 첫 entry j 를 [예측 - maxOver, 예측 + maxUnder + 1] 에서 찾는다.
+// 모델의 예측은 key 에 대해 감소하지 않으므로 key 가 entry j-1 과 j 사이에 있으면 key 의 예측 위치도 두 entry 의 예측 위치 사이에 있고
+// 예측 <= j + maxOver 이고 예측 >= j - 1 - maxUnder 이다
 func (idx *LearnedIndex) Get(searchKey []byte) IndexEntry {
-	key := uint64(compare.ByteToInt(searchKey))
-	predicted := idx.learned.Predict(key)
-	low := int(predicted) - int(float64(len(idx.entries))*0.001)  // -1%
-	high := int(predicted) + int(float64(len(idx.entries))*0.001) // +1%
+	entry, _ := idx.find(searchKey)
+	return entry
+}
 
+// find 는 Get 과 같고, binary search 한 범위의 entry 수도 반환한다
+func (idx *LearnedIndex) find(searchKey []byte) (IndexEntry, int) {
+	low, high := idx.window(searchKey)
 	offset := idx.binarySearch(searchKey, low, high)
 
-	return idx.entries[offset]
+	return idx.entries[offset], high - low + 1
+}
+
+// window 는 searchKey 의 entry 가 있을 수 있는 범위 [low, high]
+func (idx *LearnedIndex) window(searchKey []byte) (int, int) {
+	last := len(idx.entries) - 1
+	mapped, ok := idx.mapper.mapKey(encoder.UserKey(searchKey))
+	if !ok {
+		return 0, last
+	}
+	predicted := idx.predict(mapped)
+	low := math.Min(math.Max(math.Floor(predicted-idx.maxOver), 0), float64(last))
+	high := math.Min(math.Max(math.Ceil(predicted+idx.maxUnder+1), 0), float64(last))
+	return int(low), int(high)
 }
 
 func (idx *LearnedIndex) FirstEntry() IndexEntry {
@@ -70,12 +219,16 @@ func (idx *LearnedIndex) LastEntry() IndexEntry {
 	return idx.entries[len(idx.entries)-1]
 }
 
+func (idx *LearnedIndex) Entries() []IndexEntry {
+	return idx.entries
+}
+
 func (idx *LearnedIndex) binarySearch(searchKey []byte, low, high int) int {
 	high = min(high, len(idx.entries)-1)
 	low = min(max(low, 0), high)
 	for low < high {
 		mid := (low + high) / 2
-		cmp := compare.Compare(searchKey, idx.entries[mid].key, true)
+		cmp := compare.CompareInternal(idx.cmp, searchKey, idx.entries[mid].key)
 		if cmp > 0 {
 			low = mid + 1
 		} else {
@@ -84,3 +237,86 @@ func (idx *LearnedIndex) binarySearch(searchKey []byte, low, high int) int {
 	}
 	return low
 }
+
+// keyMapper 는 user key 를 순서를 보존하는 uint64 로 바꾼다.
+// 학습한 모든 key 가 공유하는 prefix 를 떼고 다음 8 byte 를 big-endian 으로 읽는다.
+// compare.NumericString 이면 prefix 뒤의 숫자를 최대 19 자리까지 10 진수로 읽는다
+type keyMapper struct {
+	prefix  []byte
+	width   int  // 0 이 아니면 key 앞에 '0' 을 채워 이 길이로 맞춘다 (compare.NumericString)
+	reverse bool // compare.ReverseBytewise 면 순서를 뒤집는다
+}
+
+// keyMapperSupports 는 keyMapper 가 cmp 순서를 보존하는지 확인한다.
+// 학습한 key 가 순서대로 바뀌어도 그 사이의 key 까지 순서가 보존되는지는 알 수 없으므로 built-in comparator 만 쓴다
+func keyMapperSupports(cmp compare.Comparator) bool {
+	return cmp == compare.Bytewise || cmp == compare.ReverseBytewise || cmp == compare.NumericString
+}
+
+func newKeyMapper(keys [][]byte, cmp compare.Comparator) keyMapper {
+	m := keyMapper{reverse: cmp == compare.ReverseBytewise}
+	if cmp == compare.NumericString {
+		for _, key := range keys {
+			m.width = max(m.width, len(key))
+		}
+	}
+	for i, key := range keys {
+		key = m.normalize(key)
+		if i == 0 {
+			m.prefix = append([]byte(nil), key...)
+			continue
+		}
+		n := 0
+		for n < len(m.prefix) && n < len(key) && m.prefix[n] == key[n] {
+			n++
+		}
+		m.prefix = m.prefix[:n]
+	}
+	return m
+}
+
+// normalize 는 bytewise 순서가 cmp 순서와 같아지도록 key 를 바꾼다
+func (m keyMapper) normalize(key []byte) []byte {
+	if len(key) >= m.width {
+		return key
+	}
+	padded := bytes.Repeat([]byte{'0'}, m.width-len(key))
+	return append(padded, key...)
+}
+
+// mapKey 는 key 를 바꾼 값을 반환한다. 순서를 보존할 수 없는 key 면 false 를 반환한다
+func (m keyMapper) mapKey(key []byte) (uint64, bool) {
+	var v uint64
+	switch key = m.normalize(key); {
+	case m.width > 0 && len(key) > m.width:
+		v = math.MaxUint64
+	case bytes.HasPrefix(key, m.prefix) && m.width > 0:
+		var ok bool
+		if v, ok = parseDecimal(key[len(m.prefix):]); !ok {
+			return 0, false
+		}
+	case bytes.HasPrefix(key, m.prefix):
+		var buf [8]byte
+		copy(buf[:], key[len(m.prefix):])
+		v = binary.BigEndian.Uint64(buf[:])
+	case bytes.Compare(key, m.prefix) > 0:
+		v = math.MaxUint64
+	}
+	if m.reverse {
+		return ^v, true
+	}
+	return v, true
+}
+
+// parseDecimal 은 앞에서부터 최대 19 자리를 읽는다. 19 자리까지는 uint64 에 들어간다.
+// 숫자가 아닌 byte 가 있으면 false 를 반환한다
+func parseDecimal(digits []byte) (uint64, bool) {
+	var v uint64
+	for _, d := range digits[:min(len(digits), 19)] {
+		if d < '0' || d > '9' {
+			return 0, false
+		}
+		v = v*10 + uint64(d-'0')
+	}
+	return v, true
+}
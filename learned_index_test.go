@@ -0,0 +1,454 @@
+package lsm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/gptjddldi/lsm/db/compare"
+	"github.com/gptjddldi/lsm/db/encoder"
+	"github.com/stretchr/testify/assert"
+)
+
+// encodeIndexBlock 은 user key 마다 block 하나를 가리키는 index block 을 만든다
+func encodeIndexBlock(keys []string) []byte {
+	var buf []byte
+	for i, key := range keys {
+		handle := make([]byte, 8)
+		handle[0] = byte(i)
+		entry := &DataEntry{key: []byte(key), value: handle, opType: encoder.OpTypeSet, seq: 1}
+		buf = append(buf, entry.toBytes()...)
+	}
+	return buf
+}
+
+// checkIndex 는 idx 가 모든 key 와 key 사이의 값에 대해 binary search 와 같은 entry 를 찾는지 확인한다
+func checkIndex(t *testing.T, idx BaseIndex, cmp compare.Comparator, keys []string, between func(i int) string) {
+	want := &Index{entries: idx.Entries(), cmp: cmp}
+	for i, key := range keys {
+		lookup := encoder.MakeLookupKey([]byte(key), encoder.MaxSequenceNumber)
+		assert.Equal(t, want.Get(lookup), idx.Get(lookup), key)
+		if between != nil {
+			lookup = encoder.MakeLookupKey([]byte(between(i)), encoder.MaxSequenceNumber)
+			assert.Equal(t, want.Get(lookup), idx.Get(lookup), between(i))
+		}
+	}
+}
+
+func TestLearnedIndex_StringKeys(t *testing.T) {
+	keys := make([]string, 1000)
+	for i := range keys {
+		// 19 자보다 긴 공통 prefix 뒤에 순서대로 증가하는 부분이 온다
+		keys[i] = fmt.Sprintf("tenant-0001/users/profile/%06d", i*10)
+	}
+	idx := NewLearnedIndex(encodeIndexBlock(keys), compare.Bytewise, LinearModel)
+	learned, ok := idx.(*LearnedIndex)
+	assert.True(t, ok)
+	assert.Less(t, learned.maxOver+learned.maxUnder, float64(len(keys))/4)
+	checkIndex(t, idx, compare.Bytewise, keys, func(i int) string {
+		return fmt.Sprintf("tenant-0001/users/profile/%06d", i*10-5)
+	})
+}
+
+func TestLearnedIndex_NumericStringKeys(t *testing.T) {
+	keys := make([]string, 500)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i * 7)
+	}
+	idx := NewLearnedIndex(encodeIndexBlock(keys), compare.NumericString, LinearModel)
+	_, ok := idx.(*LearnedIndex)
+	assert.True(t, ok)
+	checkIndex(t, idx, compare.NumericString, keys, func(i int) string {
+		return strconv.Itoa(i*7 + 3)
+	})
+}
+
+func TestLearnedIndex_ReverseKeys(t *testing.T) {
+	key := func(v int) string {
+		return "key" + string(binary.BigEndian.AppendUint32(nil, uint32(v)))
+	}
+	keys := make([]string, 500)
+	for i := range keys {
+		keys[i] = key((len(keys) - i) * 2)
+	}
+	idx := NewLearnedIndex(encodeIndexBlock(keys), compare.ReverseBytewise, LinearModel)
+	_, ok := idx.(*LearnedIndex)
+	assert.True(t, ok)
+	checkIndex(t, idx, compare.ReverseBytewise, keys, func(i int) string {
+		return key((len(keys)-i)*2 + 1)
+	})
+}
+
+func TestLearnedIndex_SkewedKeys(t *testing.T) {
+	key := func(v uint64) string {
+		return "key" + string(binary.BigEndian.AppendUint64(nil, v))
+	}
+	distributions := map[string]func(i int) uint64{
+		"power": func(i int) uint64 { return uint64(math.Pow(float64(i), 1.5)) * 2 },
+		"two densities": func(i int) uint64 {
+			if i < 700 {
+				return uint64(i) * 2
+			}
+			return 1400 + uint64(i-700)*4
+		},
+	}
+	for name, dist := range distributions {
+		t.Run(name, func(t *testing.T) {
+			keys := make([]string, 1000)
+			for i := range keys {
+				keys[i] = key(dist(i))
+			}
+			idx := NewLearnedIndex(encodeIndexBlock(keys), compare.Bytewise, LinearModel)
+			learned, ok := idx.(*LearnedIndex)
+			if !assert.True(t, ok) {
+				return
+			}
+			// 직선 하나로 잘 맞지 않아 한쪽 오차가 고정된 ±0.1% 범위를 훨씬 넘는다
+			assert.Greater(t, max(learned.maxOver, learned.maxUnder), 10.0)
+			assert.NotEqual(t, learned.maxOver, learned.maxUnder)
+
+			checkIndex(t, idx, compare.Bytewise, keys, func(i int) string {
+				return key(dist(i) - 1)
+			})
+			want := &Index{entries: idx.Entries(), cmp: compare.Bytewise}
+			rnd := rand.New(rand.NewSource(1))
+			last := dist(len(keys) - 1)
+			for i := 0; i < 10000; i++ {
+				lookup := encoder.MakeLookupKey([]byte(key(uint64(rnd.Int63n(int64(last)+10)))), encoder.MaxSequenceNumber)
+				assert.Equal(t, want.Get(lookup), idx.Get(lookup))
+			}
+		})
+	}
+}
+
+func TestLearnedIndex_FallsBackOnPoorFit(t *testing.T) {
+	// 지수적으로 늘어나는 key 는 직선 하나로 맞출 수 없다
+	keys := make([]string, 60)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%020d", uint64(math.Pow(2, float64(i))))
+	}
+	idx := NewLearnedIndex(encodeIndexBlock(keys), compare.Bytewise, LinearModel)
+	_, ok := idx.(*Index)
+	assert.True(t, ok)
+
+	// bytewise 로 정렬된 10 진수 key 는 NumericString 순서가 아니다
+	keys = []string{"1", "10", "100", "2", "20", "3", "4", "5"}
+	idx = NewLearnedIndex(encodeIndexBlock(keys), compare.NumericString, LinearModel)
+	_, ok = idx.(*Index)
+	assert.True(t, ok)
+}
+
+// caseInsensitive 는 대소문자를 구분하지 않는 comparator. bytewise 순서와 다르다
+type caseInsensitive struct{}
+
+func (caseInsensitive) Compare(a, b []byte) int {
+	return bytes.Compare(bytes.ToLower(a), bytes.ToLower(b))
+}
+
+func (caseInsensitive) Name() string { return "test.CaseInsensitiveComparator" }
+
+func TestLearnedIndex_CustomComparator(t *testing.T) {
+	keys := make([]string, 500)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%05d", i*10)
+	}
+	// 학습할 key 는 bytewise 로도 순서대로지만 그 사이의 대문자 key 는 그렇지 않다
+	idx := NewLearnedIndex(encodeIndexBlock(keys), caseInsensitive{}, LinearModel)
+	_, ok := idx.(*Index)
+	assert.True(t, ok)
+	checkIndex(t, idx, caseInsensitive{}, keys, func(i int) string {
+		return fmt.Sprintf("KEY%05d", i*10+5)
+	})
+}
+
+func TestLearnedIndex_DBGet(t *testing.T) {
+	for _, model := range learnedIndexModels {
+		t.Run(model.String(), func(t *testing.T) {
+			opts := &Options{Comparator: compare.NumericString, UseLearnedIndex: true, LearnedIndexModel: model, BlockSize: 256}
+			db, err := Open(t.TempDir(), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			const n = 5000
+			for i := 0; i < n; i++ {
+				assert.NoError(t, db.Insert([]byte(strconv.Itoa(i*3)), []byte(strconv.Itoa(i))))
+			}
+			flushMutable(t, db)
+
+			v := db.currentVersion()
+			r, err := v.levels[0].sstables[0].acquireReader()
+			v.unref()
+			assert.NoError(t, err)
+			_, ok := (*r.index).(*LearnedIndex)
+			assert.True(t, ok)
+			r.unref()
+
+			for i := 0; i < n; i++ {
+				val, err := db.Get([]byte(strconv.Itoa(i * 3)))
+				assert.NoError(t, err)
+				assert.Equal(t, []byte(strconv.Itoa(i)), val)
+				_, err = db.Get([]byte(strconv.Itoa(i*3 + 1)))
+				assert.ErrorIs(t, err, ErrorKeyNotFound)
+			}
+		})
+	}
+}
+
+// learnedIndexKeys 는 분포 dist 를 따르는 정렬된 8 byte big-endian key n 개를 만든다
+func learnedIndexKeys(dist string, n int) []string {
+	rnd := rand.New(rand.NewSource(1))
+	values := make([]uint64, 0, n)
+	seen := make(map[uint64]bool)
+	for len(values) < n {
+		var v uint64
+		switch dist {
+		case "uniform":
+			v = uint64(rnd.Int63n(1 << 40))
+		case "lognormal":
+			v = uint64(math.Exp(rnd.NormFloat64()*2) * 1e6)
+		case "clustered":
+			// 멀리 떨어진 16 개의 좁은 구간
+			v = uint64(rnd.Intn(16))<<36 + uint64(rnd.Int63n(1<<20))
+		}
+		if !seen[v] {
+			seen[v] = true
+			values = append(values, v)
+		}
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	keys := make([]string, n)
+	for i, v := range values {
+		keys[i] = string(binary.BigEndian.AppendUint64(nil, v))
+	}
+	return keys
+}
+
+var learnedIndexModels = []LearnedIndexModel{LinearModel, PiecewiseLinearModel, RadixSplineModel}
+
+func TestLearnedIndex_Models(t *testing.T) {
+	for _, dist := range []string{"uniform", "lognormal", "clustered"} {
+		keys := learnedIndexKeys(dist, 5000)
+		for _, model := range learnedIndexModels {
+			t.Run(dist+"/"+model.String(), func(t *testing.T) {
+				idx := NewLearnedIndex(encodeIndexBlock(keys), compare.Bytewise, model)
+				learned, ok := idx.(*LearnedIndex)
+				if model != LinearModel {
+					// 오차 한도가 있는 모델은 분포와 관계없이 좁은 범위만 찾는다
+					assert.True(t, ok)
+					assert.LessOrEqual(t, learned.maxOver+learned.maxUnder, 2.0*learnedIndexEpsilon+1)
+				}
+
+				want := &Index{entries: idx.Entries(), cmp: compare.Bytewise}
+				rnd := rand.New(rand.NewSource(2))
+				for i := 0; i < 5000; i++ {
+					var key []byte
+					if i%2 == 0 {
+						key = []byte(keys[rnd.Intn(len(keys))])
+					} else {
+						key = binary.BigEndian.AppendUint64(nil, rnd.Uint64()>>uint(rnd.Intn(64)))
+					}
+					lookup := encoder.MakeLookupKey(key, encoder.MaxSequenceNumber)
+					assert.Equal(t, want.Get(lookup), idx.Get(lookup))
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkLearnedIndex_Get(b *testing.B) {
+	const n = 100000
+	for _, dist := range []string{"uniform", "lognormal", "clustered"} {
+		keys := learnedIndexKeys(dist, n)
+		indexBytes := encodeIndexBlock(keys)
+		lookups := make([][]byte, 1024)
+		rnd := rand.New(rand.NewSource(3))
+		for i := range lookups {
+			lookups[i] = encoder.MakeLookupKey([]byte(keys[rnd.Intn(n)]), encoder.MaxSequenceNumber)
+		}
+
+		indexes := map[string]BaseIndex{"binary-search": NewIndex(indexBytes, compare.Bytewise)}
+		for _, model := range learnedIndexModels {
+			indexes[model.String()] = NewLearnedIndex(indexBytes, compare.Bytewise, model)
+		}
+		for _, name := range []string{"binary-search", "linear", "piecewise-linear", "radix-spline"} {
+			idx := indexes[name]
+			if _, ok := idx.(*LearnedIndex); !ok && name != "binary-search" {
+				name += "(fallback)"
+			}
+			b.Run(dist+"/"+name, func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					idx.Get(lookups[i%len(lookups)])
+				}
+			})
+		}
+	}
+}
+
+func openLearnedSSTable(t *testing.T, path string, opts *Options) *SSTable {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	sst, err := NewSSTable(f, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sst
+}
+
+func TestLearnedIndex_PersistedModel(t *testing.T) {
+	for _, model := range []LearnedIndexModel{PiecewiseLinearModel, RadixSplineModel} {
+		t.Run(model.String(), func(t *testing.T) {
+			opts, err := (&Options{BlockSize: 256, Comparator: compare.Bytewise, UseLearnedIndex: true, LearnedIndexModel: model}).withDefaults()
+			assert.NoError(t, err)
+			path := writeTestSSTable(t, testSSTableSpec{opts: opts, numKeys: 2000})
+
+			// 읽을 때의 모델 설정과 관계없이 저장된 모델을 그대로 쓴다
+			readOpts := *opts
+			readOpts.LearnedIndexModel = LinearModel
+			sst := openLearnedSSTable(t, path, &readOpts)
+			assert.Contains(t, sst.reader.metaindex, metaLearnedIndexName)
+			assert.Equal(t, model.String(), sst.reader.props.indexType)
+			loaded, ok := (*sst.reader.index).(*LearnedIndex)
+			if !assert.True(t, ok) {
+				return
+			}
+			assert.Equal(t, model, loaded.kind)
+
+			trained := trainLearnedIndex(loaded.entries, compare.Bytewise, model)
+			assert.Equal(t, trained.maxOver, loaded.maxOver)
+			assert.Equal(t, trained.maxUnder, loaded.maxUnder)
+			assert.Equal(t, trained.mapper, loaded.mapper)
+
+			for i := 0; i < 2000; i++ {
+				val, err := sst.Get([]byte(fmt.Sprintf("key%04d", i)))
+				assert.NoError(t, err)
+				assert.Equal(t, []byte(fmt.Sprintf("value%04d", i)), val.Value())
+			}
+
+			// index 종류는 파일마다 정해져 있으므로 UseLearnedIndex 가 없어도 저장된 모델을 쓴다
+			readOpts.UseLearnedIndex = false
+			sst = openLearnedSSTable(t, path, &readOpts)
+			_, ok = (*sst.reader.index).(*LearnedIndex)
+			assert.True(t, ok)
+		})
+	}
+}
+
+func TestLearnedIndex_IndexTypePerSSTable(t *testing.T) {
+	opts, err := (&Options{BlockSize: 256, Comparator: compare.Bytewise}).withDefaults()
+	assert.NoError(t, err)
+	path := writeTestSSTable(t, testSSTableSpec{opts: opts, numKeys: 2000})
+
+	// binary search 로 쓴 파일은 UseLearnedIndex 로 열어도 다시 학습하지 않는다
+	readOpts := *opts
+	readOpts.UseLearnedIndex = true
+	readOpts.LearnedIndexModel = RadixSplineModel
+	sst := openLearnedSSTable(t, path, &readOpts)
+	assert.Equal(t, indexTypeBinarySearch, sst.reader.props.indexType)
+	_, ok := (*sst.reader.index).(*Index)
+	assert.True(t, ok)
+
+	// index 종류가 기록되지 않은 예전 파일은 열 때 학습한다
+	path = writeTestSSTable(t, testSSTableSpec{opts: opts, version: versionPtr(formatVersion0), numKeys: 2000})
+	sst = openLearnedSSTable(t, path, &readOpts)
+	assert.Empty(t, sst.reader.props.indexType)
+	idx, ok := (*sst.reader.index).(*LearnedIndex)
+	if assert.True(t, ok) {
+		assert.Equal(t, RadixSplineModel, idx.kind)
+	}
+	val, err := sst.Get([]byte("key1234"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value1234"), val.Value())
+
+	// 모델이 맞지 않는 파일은 UseLearnedIndex 여도 binary search 로 쓴다
+	memtable := NewMemtable(1<<20, compare.Bytewise)
+	for i := 0; i < 60; i++ {
+		key := binary.BigEndian.AppendUint64(nil, uint64(math.Pow(2, float64(i))))
+		memtable.Insert(key, []byte("value"))
+	}
+	path = filepath.Join(t.TempDir(), "0_000002.sst")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	readOpts.LearnedIndexModel = LinearModel
+	readOpts.BlockSize = 64
+	assert.NoError(t, NewFlusher(memtable, f, &readOpts).Flush())
+	sst = openLearnedSSTable(t, path, &readOpts)
+	assert.Equal(t, indexTypeBinarySearch, sst.reader.props.indexType)
+	assert.NotContains(t, sst.reader.metaindex, metaLearnedIndexName)
+}
+
+func TestLearnedIndex_MixedIndexStats(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{Comparator: compare.Bytewise, BlockSize: 256, L0CompactionTrigger: 100}
+	db, err := Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		assert.NoError(t, db.Insert([]byte(fmt.Sprintf("key%05d", i)), []byte("old")))
+	}
+	flushMutable(t, db)
+	db.Close()
+
+	// 같은 DB 에 binary search 로 쓴 파일과 learned index 로 쓴 파일이 함께 있다
+	opts.UseLearnedIndex = true
+	opts.LearnedIndexModel = PiecewiseLinearModel
+	db, err = Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for i := 1000; i < 2000; i++ {
+		assert.NoError(t, db.Insert([]byte(fmt.Sprintf("key%05d", i)), []byte("new")))
+	}
+	flushMutable(t, db)
+
+	indexTypes := func() []string {
+		v := db.currentVersion()
+		defer v.unref()
+		var types []string
+		for _, l := range v.levels {
+			for _, sst := range l.sstables {
+				r, err := sst.acquireReader()
+				assert.NoError(t, err)
+				types = append(types, r.props.indexType)
+				r.unref()
+			}
+		}
+		return types
+	}
+	assert.ElementsMatch(t, []string{indexTypeBinarySearch, PiecewiseLinearModel.String()}, indexTypes())
+
+	for i := 0; i < 2000; i++ {
+		_, err := db.Get([]byte(fmt.Sprintf("key%05d", i)))
+		assert.NoError(t, err)
+	}
+	stats := db.IndexStats()
+	assert.Equal(t, uint64(1000), stats.BinarySearchLookups)
+	assert.Equal(t, uint64(1000), stats.LearnedLookups)
+	assert.Less(t, stats.AvgLearnedWindow, stats.AvgBinarySearchWindow)
+	assert.LessOrEqual(t, stats.AvgLearnedWindow, 2.0*learnedIndexEpsilon+2)
+
+	// compaction 으로 다시 쓴 파일은 learned index 로 바뀐다
+	assert.NoError(t, db.compactLevel(0))
+	for _, typ := range indexTypes() {
+		assert.Equal(t, PiecewiseLinearModel.String(), typ)
+	}
+	val, err := db.Get([]byte("key00042"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("old"), val)
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math/rand"
 	"testing"
 )
 
@@ -22,7 +23,7 @@ func init() {
 }
 
 func BenchmarkSSTSearch(b *testing.B) {
-	d, err := Open("demo-data", false)
+	d, err := Open("demo-data", nil)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -38,3 +39,73 @@ func BenchmarkSSTSearch(b *testing.B) {
 		})
 	}
 }
+
+// BenchmarkGet_IndexModes 는 index 종류와 data block 안의 탐색 방식별로 SSTable 에서 읽는 Get 을 잰다.
+// entry-offsets 는 block 안에서도 entry offset 으로 binary search 한다.
+// block cache 에 든 block 은 디스크 읽기와 압축 풀기가 빠지므로 cache 를 끈 경우도 잰다
+func BenchmarkGet_IndexModes(b *testing.B) {
+	modes := []struct {
+		name    string
+		learned bool
+		model   LearnedIndexModel
+	}{
+		{"binary-search", false, LinearModel},
+		{"linear", true, LinearModel},
+		{"piecewise-linear", true, PiecewiseLinearModel},
+		{"radix-spline", true, RadixSplineModel},
+	}
+	caches := []struct {
+		name string
+		size int64
+	}{
+		{"cache=off", -1},
+		{"cache=on", 8 << 20},
+	}
+	for _, mode := range modes {
+		for _, entryOffsets := range []bool{false, true} {
+			block := "restarts"
+			if entryOffsets {
+				block = "entry-offsets"
+			}
+			for _, cache := range caches {
+				b.Run(mode.name+"/"+block+"/"+cache.name, func(b *testing.B) {
+					opts := DefaultOptions()
+					opts.UseLearnedIndex = mode.learned
+					opts.LearnedIndexModel = mode.model
+					opts.BlockEntryOffsets = entryOffsets
+					opts.BlockCacheSize = cache.size
+					benchmarkGetFromSSTables(b, opts)
+				})
+			}
+		}
+	}
+}
+
+// benchmarkGetFromSSTables 는 opts 로 연 DB 의 SSTable 에서 임의의 key 를 읽는다
+func benchmarkGetFromSSTables(b *testing.B, opts *Options) {
+	const n = 100000
+	dir := b.TempDir()
+	d, err := Open(dir, opts)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if err = d.Insert([]byte(fmt.Sprintf("key%08d", i)), []byte(fmt.Sprintf("value%08d", i))); err != nil {
+			b.Fatal(err)
+		}
+	}
+	// 다시 열어서 memtable 이 아니라 SSTable 에서 읽게 한다
+	d.Close()
+	if d, err = Open(dir, opts); err != nil {
+		b.Fatal(err)
+	}
+	defer d.Close()
+
+	rng := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err = d.Get([]byte(fmt.Sprintf("key%08d", rng.Intn(n)))); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
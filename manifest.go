@@ -0,0 +1,555 @@
+package lsm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/gptjddldi/lsm/db/storage"
+	"github.com/gptjddldi/lsm/db/wal"
+)
+
+const (
+	maxManifestFileSize = 4 << 20 // 넘어가면 snapshot 으로 새 manifest 를 만든다
+)
+
+// version edit 의 각 필드는 { tag, 값... } 으로 인코딩된다
+const (
+	tagLogNumber = iota + 1
+	tagNextFileNumber
+	tagLastSequence
+	tagAddFile
+	tagDeleteFile
+	tagCompactCursor
+	tagAddFileMeta
+	tagComparator
+)
+
+type fileEdit struct {
+	level   int
+	fileNum int
+	meta    *fileMeta // 삭제 edit 와 tagAddFile 로 기록된 예전 edit 에서는 nil
+}
+
+// fileMeta 는 Open 이 SSTable 파일을 열지 않고 version 을 만들 수 있도록 manifest 에 기록하는 정보
+type fileMeta struct {
+	size       int64
+	smallest   []byte // user key
+	largest    []byte // user key
+	largestSeq uint64
+}
+
+func newFileEdit(level int, sst *SSTable) fileEdit {
+	return fileEdit{
+		level:   level,
+		fileNum: sst.meta.FileNum(),
+		meta: &fileMeta{
+			size:       sst.size,
+			smallest:   sst.minKey,
+			largest:    sst.maxKey,
+			largestSeq: sst.largestSeq,
+		},
+	}
+}
+
+// compactCursor 는 level 에서 마지막으로 compaction 한 SSTable 의 max key.
+// 다음 compaction 은 이 key 뒤의 SSTable 부터 고른다
+type compactCursor struct {
+	level int
+	key   []byte
+}
+
+// versionEdit 는 manifest 에 append 되는 하나의 레코드.
+// 하나의 edit 는 통째로 적용되거나 전혀 적용되지 않는다
+type versionEdit struct {
+	logNumber      int // 이 번호보다 작은 WAL 은 이미 SSTable 로 내려갔다
+	nextFileNumber int
+	lastSequence   uint64
+
+	comparator string // key 를 정렬한 Comparator 의 이름. manifest 의 첫 edit 에만 있다
+
+	hasLogNumber      bool
+	hasNextFileNumber bool
+	hasLastSequence   bool
+
+	addedFiles     []fileEdit
+	deletedFiles   []fileEdit
+	compactCursors []compactCursor
+
+	// manifest 에는 기록되지 않고 메모리 상의 version 에 반영할 때만 쓰인다
+	addedTables     []*SSTable
+	deletedTables   []*SSTable
+	flushedMemtable *Memtable // 새 version 과 함께 queue 에서 빠진다
+}
+
+func (e *versionEdit) setLogNumber(n int) {
+	e.logNumber = n
+	e.hasLogNumber = true
+}
+
+func (e *versionEdit) setNextFileNumber(n int) {
+	e.nextFileNumber = n
+	e.hasNextFileNumber = true
+}
+
+func (e *versionEdit) setLastSequence(seq uint64) {
+	e.lastSequence = seq
+	e.hasLastSequence = true
+}
+
+func (e *versionEdit) addTable(level int, sst *SSTable) {
+	e.addedFiles = append(e.addedFiles, newFileEdit(level, sst))
+	e.addedTables = append(e.addedTables, sst)
+}
+
+func (e *versionEdit) deleteTable(level int, sst *SSTable) {
+	e.deletedFiles = append(e.deletedFiles, fileEdit{level: level, fileNum: sst.meta.FileNum()})
+	e.deletedTables = append(e.deletedTables, sst)
+}
+
+func (e *versionEdit) setCompactCursor(level int, key []byte) {
+	e.compactCursors = append(e.compactCursors, compactCursor{level: level, key: key})
+}
+
+func (e *versionEdit) encode() []byte {
+	buf := make([]byte, 0, 64)
+	putUvarint := func(v uint64) {
+		buf = binary.AppendUvarint(buf, v)
+	}
+	putBytes := func(b []byte) {
+		putUvarint(uint64(len(b)))
+		buf = append(buf, b...)
+	}
+	if e.comparator != "" {
+		putUvarint(tagComparator)
+		putBytes([]byte(e.comparator))
+	}
+	if e.hasLogNumber {
+		putUvarint(tagLogNumber)
+		putUvarint(uint64(e.logNumber))
+	}
+	if e.hasNextFileNumber {
+		putUvarint(tagNextFileNumber)
+		putUvarint(uint64(e.nextFileNumber))
+	}
+	if e.hasLastSequence {
+		putUvarint(tagLastSequence)
+		putUvarint(e.lastSequence)
+	}
+	for _, f := range e.deletedFiles {
+		putUvarint(tagDeleteFile)
+		putUvarint(uint64(f.level))
+		putUvarint(uint64(f.fileNum))
+	}
+	for _, f := range e.addedFiles {
+		if f.meta == nil {
+			putUvarint(tagAddFile)
+			putUvarint(uint64(f.level))
+			putUvarint(uint64(f.fileNum))
+			continue
+		}
+		// { level, file number, size, largest sequence, smallest key, largest key }
+		putUvarint(tagAddFileMeta)
+		putUvarint(uint64(f.level))
+		putUvarint(uint64(f.fileNum))
+		putUvarint(uint64(f.meta.size))
+		putUvarint(f.meta.largestSeq)
+		putBytes(f.meta.smallest)
+		putBytes(f.meta.largest)
+	}
+	for _, c := range e.compactCursors {
+		putUvarint(tagCompactCursor)
+		putUvarint(uint64(c.level))
+		putBytes(c.key)
+	}
+	return buf
+}
+
+func decodeVersionEdit(record []byte) (*versionEdit, error) {
+	e := &versionEdit{}
+	offset := 0
+	readUvarint := func() (uint64, error) {
+		v, n := binary.Uvarint(record[offset:])
+		if n <= 0 {
+			return 0, fmt.Errorf("invalid version edit at offset %d", offset)
+		}
+		offset += n
+		return v, nil
+	}
+	readBytes := func() ([]byte, error) {
+		n, err := readUvarint()
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(len(record)-offset) {
+			return nil, fmt.Errorf("invalid length %d at offset %d", n, offset)
+		}
+		b := append([]byte(nil), record[offset:offset+int(n)]...)
+		offset += int(n)
+		return b, nil
+	}
+	readFileEdit := func() (fileEdit, error) {
+		level, err := readUvarint()
+		if err != nil {
+			return fileEdit{}, err
+		}
+		if level >= maxNumLevels {
+			return fileEdit{}, fmt.Errorf("invalid level %d in version edit", level)
+		}
+		fileNum, err := readUvarint()
+		if err != nil {
+			return fileEdit{}, err
+		}
+		return fileEdit{level: int(level), fileNum: int(fileNum)}, nil
+	}
+
+	for offset < len(record) {
+		tag, err := readUvarint()
+		if err != nil {
+			return nil, err
+		}
+		switch tag {
+		case tagLogNumber:
+			v, err := readUvarint()
+			if err != nil {
+				return nil, err
+			}
+			e.setLogNumber(int(v))
+		case tagNextFileNumber:
+			v, err := readUvarint()
+			if err != nil {
+				return nil, err
+			}
+			e.setNextFileNumber(int(v))
+		case tagLastSequence:
+			v, err := readUvarint()
+			if err != nil {
+				return nil, err
+			}
+			e.setLastSequence(v)
+		case tagAddFile:
+			f, err := readFileEdit()
+			if err != nil {
+				return nil, err
+			}
+			e.addedFiles = append(e.addedFiles, f)
+		case tagAddFileMeta:
+			f, err := readFileEdit()
+			if err != nil {
+				return nil, err
+			}
+			f.meta = &fileMeta{}
+			size, err := readUvarint()
+			if err != nil {
+				return nil, err
+			}
+			f.meta.size = int64(size)
+			if f.meta.largestSeq, err = readUvarint(); err != nil {
+				return nil, err
+			}
+			if f.meta.smallest, err = readBytes(); err != nil {
+				return nil, err
+			}
+			if f.meta.largest, err = readBytes(); err != nil {
+				return nil, err
+			}
+			e.addedFiles = append(e.addedFiles, f)
+		case tagDeleteFile:
+			f, err := readFileEdit()
+			if err != nil {
+				return nil, err
+			}
+			e.deletedFiles = append(e.deletedFiles, f)
+		case tagCompactCursor:
+			level, err := readUvarint()
+			if err != nil {
+				return nil, err
+			}
+			if level >= maxNumLevels {
+				return nil, fmt.Errorf("invalid level %d in version edit", level)
+			}
+			key, err := readBytes()
+			if err != nil {
+				return nil, err
+			}
+			e.setCompactCursor(int(level), key)
+		case tagComparator:
+			name, err := readBytes()
+			if err != nil {
+				return nil, err
+			}
+			e.comparator = string(name)
+		default:
+			return nil, fmt.Errorf("unknown version edit tag %d", tag)
+		}
+	}
+	return e, nil
+}
+
+type manifest struct {
+	meta    *storage.FileMetadata
+	writer  *wal.Writer
+	written int
+
+	logNumber    int
+	lastSequence uint64
+}
+
+// manifestState 는 manifest 를 처음부터 재생한 결과
+type manifestState struct {
+	levels         [][]fileEdit // level 별 SSTable, 추가된 순서
+	compactCursors [][]byte
+	comparator     string
+	logNumber      int
+	nextFileNumber int
+	lastSequence   uint64
+}
+
+func replayManifest(path string) (*manifestState, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader, err := wal.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+
+	state := newManifestState()
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("replaying %s: %w", path, err)
+		}
+		edit, err := decodeVersionEdit(record)
+		if err != nil {
+			return nil, fmt.Errorf("replaying %s: %w", path, err)
+		}
+		state.apply(edit)
+	}
+	return state, nil
+}
+
+func newManifestState() *manifestState {
+	return &manifestState{
+		levels:         make([][]fileEdit, maxNumLevels),
+		compactCursors: make([][]byte, maxNumLevels),
+	}
+}
+
+func (s *manifestState) apply(edit *versionEdit) {
+	if edit.comparator != "" {
+		s.comparator = edit.comparator
+	}
+	if edit.hasLogNumber {
+		s.logNumber = edit.logNumber
+	}
+	if edit.hasNextFileNumber {
+		s.nextFileNumber = edit.nextFileNumber
+	}
+	if edit.hasLastSequence {
+		s.lastSequence = edit.lastSequence
+	}
+	for _, d := range edit.deletedFiles {
+		files := s.levels[d.level]
+		for i, f := range files {
+			if f.fileNum == d.fileNum {
+				s.levels[d.level] = append(files[:i:i], files[i+1:]...)
+				break
+			}
+		}
+	}
+	for _, a := range edit.addedFiles {
+		s.levels[a.level] = append(s.levels[a.level], a)
+	}
+	for _, c := range edit.compactCursors {
+		s.compactCursors[c.level] = c.key
+	}
+}
+
+// recoverVersion 은 CURRENT 가 가리키는 manifest 로 level 을 복구한다.
+// manifest 가 없는 디렉토리는 파일 이름으로 level 을 복구한다.
+// 어느 쪽이든 새 manifest 로 교체하고, version 에 없는 파일은 지운다
+func (db *DB) recoverVersion() error {
+	files, err := db.dataStorage.ListFiles()
+	if err != nil {
+		return err
+	}
+	current, err := db.dataStorage.CurrentManifest()
+	if err != nil {
+		return err
+	}
+
+	logNumber := 0
+	levels := emptyLevels(db.opts.MaxLevels)
+	cursors := make([][]byte, db.opts.MaxLevels)
+	if current == nil {
+		if err = db.loadSSTFilesFromDisk(levels); err != nil {
+			return err
+		}
+	} else {
+		state, err := replayManifest(current.Path())
+		if err != nil {
+			return err
+		}
+		// SSTable 을 열지 않으므로 파일마다 확인하던 comparator 를 manifest 에서 확인한다
+		if state.comparator != "" && state.comparator != db.opts.Comparator.Name() {
+			return fmt.Errorf("%w: %s was written with %s, opened with %s",
+				ErrComparatorMismatch, current.Path(), state.comparator, db.opts.Comparator.Name())
+		}
+		db.dataStorage.MarkFileNumUsed(state.nextFileNumber - 1)
+		for level, files := range state.levels {
+			if len(files) > 0 && level >= len(levels) {
+				return fmt.Errorf("manifest has files at level %d but Options.MaxLevels is %d", level, len(levels))
+			}
+			for _, f := range files {
+				meta := db.dataStorage.SSTableFile(level, f.fileNum)
+				if f.meta != nil {
+					levels[level].sstables = append(levels[level].sstables, db.newSSTable(meta, f.meta))
+					continue
+				}
+				// key 범위가 기록되지 않은 예전 manifest 는 파일을 열어 읽는다
+				sst, err := db.openSSTable(meta)
+				if err != nil {
+					return err
+				}
+				levels[level].sstables = append(levels[level].sstables, sst)
+			}
+		}
+		copy(cursors, state.compactCursors)
+		logNumber = state.logNumber
+		db.lastSeq.Store(state.lastSequence)
+	}
+	db.current = newVersion(levels)
+	db.current.compactCursors = cursors
+
+	if err = db.rotateManifest(logNumber); err != nil {
+		return err
+	}
+
+	live := make(map[int]bool)
+	for _, l := range levels {
+		for _, sst := range l.sstables {
+			live[sst.meta.FileNum()] = true
+		}
+	}
+	for _, f := range files {
+		obsolete := false
+		switch {
+		case f.IsSSTable():
+			// 중간에 끊긴 flush / compaction 이 남긴 파일
+			obsolete = !live[f.FileNum()]
+		case f.IsWAL():
+			obsolete = f.FileNum() < logNumber
+		case f.IsManifest():
+			obsolete = f.FileNum() != db.manifest.meta.FileNum()
+		}
+		if obsolete {
+			if err := os.Remove(f.Path()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rotateManifest 는 현재 version 전체를 담은 snapshot 으로 새 manifest 를 쓰고
+// CURRENT 를 교체한다. versionMu 를 잡은 상태 (또는 Open 도중) 에 호출된다
+func (db *DB) rotateManifest(logNumber int) error {
+	meta := db.dataStorage.PrepareNewManifestFile()
+	f, err := db.dataStorage.OpenFileForWriting(meta)
+	if err != nil {
+		return err
+	}
+	m := &manifest{
+		meta:         meta,
+		writer:       wal.NewWriter(f, wal.SyncEveryWrite, 0),
+		logNumber:    logNumber,
+		lastSequence: db.lastSeq.Load(),
+	}
+
+	snapshot := &versionEdit{comparator: db.opts.Comparator.Name()}
+	snapshot.setLogNumber(m.logNumber)
+	snapshot.setNextFileNumber(db.dataStorage.NextFileNum())
+	snapshot.setLastSequence(m.lastSequence)
+	for level, l := range db.current.levels {
+		for _, sst := range l.sstables {
+			snapshot.addedFiles = append(snapshot.addedFiles, newFileEdit(level, sst))
+		}
+	}
+	for level, key := range db.current.compactCursors {
+		if key != nil {
+			snapshot.setCompactCursor(level, key)
+		}
+	}
+	record := snapshot.encode()
+	if err = m.writer.Append(record); err != nil {
+		m.writer.Close()
+		return err
+	}
+	m.written = len(record)
+
+	if err = db.dataStorage.SetCurrentManifest(meta); err != nil {
+		m.writer.Close()
+		return err
+	}
+
+	old := db.manifest
+	db.manifest = m
+	if old != nil {
+		if err := old.writer.Close(); err != nil {
+			log.Printf("Error closing manifest: %v", err)
+		}
+		if err := os.Remove(old.meta.Path()); err != nil {
+			log.Printf("Error deleting manifest: %v", err)
+		}
+	}
+	return nil
+}
+
+// logAndApply 는 edit 를 manifest 에 기록한 뒤 새 version 을 설치한다.
+// manifest 에 기록되기 전에 crash 가 나면 edit 는 없었던 일이 된다
+func (db *DB) logAndApply(edit *versionEdit) error {
+	db.versionMu.Lock()
+	defer db.versionMu.Unlock()
+
+	if edit.hasLogNumber {
+		db.manifest.logNumber = edit.logNumber
+	}
+	edit.setNextFileNumber(db.dataStorage.NextFileNum())
+	// flush 된 entry 의 sequence 가 다음 Open 에서 다시 쓰이지 않도록 한다
+	db.manifest.lastSequence = db.lastSeq.Load()
+	edit.setLastSequence(db.manifest.lastSequence)
+
+	record := edit.encode()
+	if err := db.manifest.writer.Append(record); err != nil {
+		return err
+	}
+	db.manifest.written += len(record)
+
+	// 지워지는 SSTable 은 이전 version 이 해제되기 전에 표시해 둔다
+	for _, sst := range edit.deletedTables {
+		sst.obsolete.Store(true)
+	}
+
+	db.mu.Lock()
+	db.installVersion(db.current.apply(edit))
+	if edit.flushedMemtable != nil {
+		db.removeFlushedMemtable(edit.flushedMemtable)
+	}
+	db.mu.Unlock()
+
+	if db.manifest.written > maxManifestFileSize {
+		if err := db.rotateManifest(db.manifest.logNumber); err != nil {
+			log.Printf("Error rotating manifest: %v", err)
+		}
+	}
+	return nil
+}
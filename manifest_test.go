@@ -0,0 +1,116 @@
+package lsm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gptjddldi/lsm/db/compare"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersionEdit_EncodeDecode(t *testing.T) {
+	edit := &versionEdit{}
+	edit.setLogNumber(7)
+	edit.setNextFileNumber(12)
+	edit.setLastSequence(1 << 40)
+	edit.comparator = compare.Bytewise.Name()
+	edit.addedFiles = []fileEdit{
+		{level: 1, fileNum: 10, meta: &fileMeta{size: 4096, smallest: []byte("a"), largest: []byte("m"), largestSeq: 42}},
+		{level: 1, fileNum: 11},
+	}
+	edit.deletedFiles = []fileEdit{{level: 0, fileNum: 3}}
+	edit.setCompactCursor(2, []byte("key042"))
+
+	decoded, err := decodeVersionEdit(edit.encode())
+	assert.NoError(t, err)
+	assert.Equal(t, 7, decoded.logNumber)
+	assert.Equal(t, 12, decoded.nextFileNumber)
+	assert.Equal(t, uint64(1<<40), decoded.lastSequence)
+	assert.Equal(t, edit.addedFiles, decoded.addedFiles)
+	assert.Equal(t, edit.deletedFiles, decoded.deletedFiles)
+	assert.Equal(t, edit.compactCursors, decoded.compactCursors)
+	assert.Equal(t, edit.comparator, decoded.comparator)
+}
+
+func TestManifestState_Apply(t *testing.T) {
+	state := newManifestState()
+	state.apply(&versionEdit{addedFiles: []fileEdit{{level: 0, fileNum: 1}, {level: 0, fileNum: 2}, {level: 0, fileNum: 3}}})
+	state.apply(&versionEdit{
+		deletedFiles:   []fileEdit{{level: 0, fileNum: 1}, {level: 0, fileNum: 2}},
+		addedFiles:     []fileEdit{{level: 1, fileNum: 4}},
+		compactCursors: []compactCursor{{1, []byte("a")}},
+	})
+	state.apply(&versionEdit{compactCursors: []compactCursor{{1, []byte("b")}}})
+	assert.Equal(t, []fileEdit{{level: 0, fileNum: 3}}, state.levels[0])
+	assert.Equal(t, []fileEdit{{level: 1, fileNum: 4}}, state.levels[1])
+	assert.Equal(t, []byte("b"), state.compactCursors[1])
+}
+
+func TestDB_ReopenFromManifest(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, db.Insert([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprintf("value%03d", i))))
+	}
+	db.Close()
+
+	current, err := os.ReadFile(filepath.Join(dir, "CURRENT"))
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dir, string(current[:len(current)-1])))
+
+	db, err = Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for i := 0; i < 100; i++ {
+		val, err := db.Get([]byte(fmt.Sprintf("key%03d", i)))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("value%03d", i)), val)
+	}
+	manifests, _ := filepath.Glob(filepath.Join(dir, "MANIFEST-*"))
+	assert.Len(t, manifests, 1)
+}
+
+func TestDB_DropsFilesOfUnfinishedCompaction(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, db.Insert([]byte("key"), []byte("old")))
+	db.Close()
+
+	// compaction output 을 쓰고 manifest 에 기록하기 전에 죽은 상황
+	db, err = Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMemtable(1024, nil)
+	m.Insert([]byte("key"), []byte("stale"))
+	meta := db.dataStorage.PrepareNewFile(1)
+	f, err := db.dataStorage.OpenFileForWriting(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, NewFlusher(m, f, nil).Flush())
+	f.Close()
+	crash(db)
+
+	db, err = Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	assert.NoFileExists(t, meta.Path())
+	assert.Empty(t, db.current.levels[1].sstables)
+	val, err := db.Get([]byte("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("old"), val)
+}
@@ -1,19 +1,35 @@
 package lsm
 
 import (
+	"sync"
+
+	"github.com/gptjddldi/lsm/db/compare"
 	"github.com/gptjddldi/lsm/db/encoder"
 	"github.com/gptjddldi/lsm/db/skiplist"
+	"github.com/gptjddldi/lsm/db/wal"
 )
 
+// Memtable 은 하나의 writer 와 여러 reader 가 동시에 사용할 수 있다
 type Memtable struct {
-	sl        *skiplist.SkipList
+	mu        sync.RWMutex
+	sl        *skiplist.SkipList // internal key -> value
+	cmp       compare.Comparator
 	sizeUsed  int
 	sizeLimit int
+	lastSeq   uint64
+
+	wal        *wal.Writer // WAL 이 없는 memtable 은 nil
+	walFileNum int
 }
 
-func NewMemtable(sizeLimit int, useLearnedIndex bool) *Memtable {
+// NewMemtable 은 key 를 cmp 순서로 정렬하는 memtable 을 만든다. cmp 가 nil 이면 compare.Bytewise 를 쓴다
+func NewMemtable(sizeLimit int, cmp compare.Comparator) *Memtable {
+	if cmp == nil {
+		cmp = compare.Bytewise
+	}
 	m := &Memtable{
-		sl:        skiplist.NewSkipList(useLearnedIndex),
+		sl:        skiplist.NewSkipList(cmp),
+		cmp:       cmp,
 		sizeUsed:  0,
 		sizeLimit: sizeLimit,
 	}
@@ -21,32 +37,154 @@ func NewMemtable(sizeLimit int, useLearnedIndex bool) *Memtable {
 }
 
 func (m *Memtable) HasRoomForWrite(key, val []byte) bool {
-	sizeNeeded := len(key) + len(val)
+	return m.hasRoomFor(len(key) + len(val))
+}
+
+func (m *Memtable) hasRoomFor(sizeNeeded int) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	return m.sizeUsed+sizeNeeded <= m.sizeLimit
 }
 
+// Add 는 sequence 가 seq 인 entry 를 추가한다. 같은 key 의 이전 entry 는 지워지지 않는다
+func (m *Memtable) Add(seq uint64, op encoder.OpType, key, val []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sl.Insert(encoder.MakeInternalKey(key, seq, op), val)
+	m.sizeUsed += len(key) + len(val) + encoder.TrailerSize
+	if seq > m.lastSeq {
+		m.lastSeq = seq
+	}
+}
+
+// Insert 는 memtable 의 마지막 sequence 다음 번호로 값을 추가한다
 func (m *Memtable) Insert(key, val []byte) {
-	m.sl.Insert(key, encoder.Encode(encoder.OpTypeSet, val))
-	m.sizeUsed += len(key) + len(val) + 1
+	m.Add(m.LastSequence()+1, encoder.OpTypeSet, key, val)
 }
 
 func (m *Memtable) InsertTombstone(key []byte) {
-	m.sl.Insert(key, encoder.Encode(encoder.OpTypeDelete, nil))
-	m.sizeUsed += 1
+	m.Add(m.LastSequence()+1, encoder.OpTypeDelete, key, nil)
 }
 
+// Get 은 key 의 가장 최근 entry 를 반환한다. tombstone 도 그대로 반환한다
 func (m *Memtable) Get(key []byte) (*encoder.EncodedValue, error) {
-	val, err := m.sl.Find(key)
-	if err != nil {
-		return nil, err
+	return m.get(key, encoder.MaxSequenceNumber)
+}
+
+// get 은 sequence 가 seq 이하인 key 의 가장 최근 entry 를 반환한다
+func (m *Memtable) get(key []byte, seq uint64) (*encoder.EncodedValue, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	internalKey, val, ok := m.sl.SeekGE(encoder.MakeLookupKey(key, seq))
+	if !ok {
+		return nil, ErrorKeyNotFound
+	}
+	userKey, entrySeq, op := encoder.ParseInternalKey(internalKey)
+	// SSTable 과 같이 comparator 가 같다고 보는 key 를 같은 key 로 본다
+	if m.cmp.Compare(userKey, key) != 0 {
+		return nil, ErrorKeyNotFound
 	}
-	return encoder.Decode(val), nil
+	return encoder.NewEncodedValue(op, val, entrySeq), nil
 }
 
 func (m *Memtable) Size() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	return m.sizeUsed
 }
 
+func (m *Memtable) LastSequence() uint64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.lastSeq
+}
+
+// Iterator 는 더 이상 쓰기가 없는 memtable (flush 대상) 에만 사용한다
 func (m *Memtable) Iterator() *skiplist.Iterator {
 	return m.sl.Iterator()
 }
+
+// memtableIterator 는 매 이동마다 skiplist 를 다시 탐색한다. 이동할 때만 잠금을 잡으므로
+// 쓰기가 계속되는 mutable memtable 에도 사용할 수 있다
+type memtableIterator struct {
+	m           *Memtable
+	internalKey []byte
+	key         []byte
+	seq         uint64
+	opType      encoder.OpType
+	val         []byte
+	valid       bool
+}
+
+func (m *Memtable) newIterator() *memtableIterator {
+	return &memtableIterator{m: m}
+}
+
+func (it *memtableIterator) set(internalKey, val []byte, ok bool) (bool, error) {
+	it.internalKey, it.val, it.valid = internalKey, val, ok
+	if ok {
+		it.key, it.seq, it.opType = encoder.ParseInternalKey(internalKey)
+	}
+	return ok, nil
+}
+
+func (it *memtableIterator) SeekToFirst() (bool, error) {
+	it.m.mu.RLock()
+	defer it.m.mu.RUnlock()
+	return it.set(it.m.sl.First())
+}
+
+func (it *memtableIterator) SeekToLast() (bool, error) {
+	it.m.mu.RLock()
+	defer it.m.mu.RUnlock()
+	return it.set(it.m.sl.Last())
+}
+
+func (it *memtableIterator) Seek(key []byte) (bool, error) {
+	it.m.mu.RLock()
+	defer it.m.mu.RUnlock()
+	return it.set(it.m.sl.SeekGE(encoder.MakeLookupKey(key, encoder.MaxSequenceNumber)))
+}
+
+func (it *memtableIterator) Next() (bool, error) {
+	if !it.valid {
+		return it.SeekToFirst()
+	}
+	it.m.mu.RLock()
+	defer it.m.mu.RUnlock()
+	return it.set(it.m.sl.SeekGT(it.internalKey))
+}
+
+func (it *memtableIterator) Prev() (bool, error) {
+	if !it.valid {
+		return it.SeekToLast()
+	}
+	it.m.mu.RLock()
+	defer it.m.mu.RUnlock()
+	return it.set(it.m.sl.SeekLT(it.internalKey))
+}
+
+func (it *memtableIterator) Key() []byte {
+	return it.key
+}
+
+func (it *memtableIterator) Seq() uint64 {
+	return it.seq
+}
+
+func (it *memtableIterator) Value() []byte {
+	return it.val
+}
+
+func (it *memtableIterator) OpType() encoder.OpType {
+	return it.opType
+}
+
+func (it *memtableIterator) Close() error {
+	return nil
+}
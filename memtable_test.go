@@ -2,12 +2,13 @@ package lsm
 
 import (
 	"fmt"
+	"github.com/gptjddldi/lsm/db/encoder"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
 
 func TestMemtable_Insert(t *testing.T) {
-	memtable := NewMemtable(1024, false)
+	memtable := NewMemtable(1024, nil)
 	key := []byte("testkey")
 	value := []byte("testValue")
 	memtable.Insert(key, value)
@@ -20,7 +21,7 @@ func TestMemtable_Insert(t *testing.T) {
 }
 
 func TestMemtable_InsertTombstone(t *testing.T) {
-	memtable := NewMemtable(1024, false)
+	memtable := NewMemtable(1024, nil)
 	key := []byte("testkey")
 	memtable.Insert(key, []byte("testValue"))
 	memtable.InsertTombstone(key)
@@ -32,7 +33,7 @@ func TestMemtable_InsertTombstone(t *testing.T) {
 }
 
 func TestMemtable_InsertTombstone2(t *testing.T) {
-	memtable := NewMemtable(1024, false)
+	memtable := NewMemtable(1024, nil)
 	key := []byte("testkey")
 	memtable.Insert(key, []byte("testValue"))
 	memtable.InsertTombstone(key)
@@ -46,7 +47,7 @@ func TestMemtable_InsertTombstone2(t *testing.T) {
 }
 
 func TestMemtable_HasRoomForWrite(t *testing.T) {
-	memtable := NewMemtable(1024, false)
+	memtable := NewMemtable(1024, nil)
 	assert.Equal(t, true, memtable.HasRoomForWrite([]byte("testKey"), []byte("testValue")))
 	i := 0
 	for memtable.Size() < 1024 {
@@ -59,3 +60,38 @@ func TestMemtable_HasRoomForWrite(t *testing.T) {
 	value := []byte(fmt.Sprintf("testValue%d", i+1))
 	assert.Equal(t, false, memtable.HasRoomForWrite(key, value))
 }
+
+func TestMemtable_KeepsOlderVersions(t *testing.T) {
+	memtable := NewMemtable(1024, nil)
+	key := []byte("testkey")
+	memtable.Add(1, encoder.OpTypeSet, key, []byte("v1"))
+	memtable.Add(2, encoder.OpTypeDelete, key, nil)
+	memtable.Add(3, encoder.OpTypeSet, key, []byte("v3"))
+
+	encodedValue, err := memtable.get(key, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v1"), encodedValue.Value())
+	encodedValue, err = memtable.get(key, 2)
+	assert.NoError(t, err)
+	assert.True(t, encodedValue.IsTombstone())
+	encodedValue, err = memtable.Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), encodedValue.Seq)
+	assert.Equal(t, []byte("v3"), encodedValue.Value())
+}
+
+func TestMemtable_GetUsesComparator(t *testing.T) {
+	memtable := NewMemtable(1024, caseInsensitive{})
+	memtable.Insert([]byte("TestKey"), []byte("v1"))
+	memtable.Insert([]byte("testkey"), []byte("v2"))
+
+	// comparator 가 같다고 보는 key 는 byte 가 달라도 같은 key 다
+	encodedValue, err := memtable.Get([]byte("TESTKEY"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v2"), encodedValue.Value())
+	encodedValue, err = memtable.get([]byte("testKey"), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v1"), encodedValue.Value())
+	_, err = memtable.Get([]byte("testkey2"))
+	assert.ErrorIs(t, err, ErrorKeyNotFound)
+}
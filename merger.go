@@ -8,22 +8,30 @@ import (
 )
 
 type MinHeapItem struct {
-	iterator  *SSTableIterator
-	key       []byte
-	value     []byte
-	opType    encoder.OpType
-	Timestamp int64 // todo: implement time stamp
+	iterator internalIterator
+	key      []byte
+	value    []byte
+	opType   encoder.OpType
+	seq      uint64 // key 가 같으면 sequence 가 큰 (더 최근) 쪽이 먼저 나온다
 }
 
 type MinHeap struct {
-	items           []*MinHeapItem
-	useLearnedIndex bool // Added useLearnedIndex attribute
+	items   []*MinHeapItem
+	cmp     compare.Comparator
+	reverse bool // true 면 큰 key 가 먼저 나온다
 }
 
 func (h MinHeap) Len() int { return len(h.items) }
 
 func (h MinHeap) Less(i, j int) bool {
-	return compare.Compare(h.items[i].key, h.items[j].key, h.useLearnedIndex) < 0
+	cmp := h.cmp.Compare(h.items[i].key, h.items[j].key)
+	if cmp == 0 {
+		return h.items[i].seq > h.items[j].seq
+	}
+	if h.reverse {
+		return cmp > 0
+	}
+	return cmp < 0
 }
 
 func (h MinHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
@@ -40,33 +48,33 @@ func (h *MinHeap) Pop() interface{} {
 	return item
 }
 
-func (db *DB) mergeIterators(iterators []*SSTableIterator, targetLevel int) ([]*SSTable, error) {
+// mergeIterators 는 살아있는 snapshot 중 하나에라도 보이는 entry 만 남긴다.
+// snapshot 이 없으면 user key 마다 가장 큰 sequence 의 entry 만 남는다.
+// tombstone 은 targetLevel 아래에 같은 key 가 남아있지 않을 때만 버린다
+func (db *DB) mergeIterators(v *version, iterators []*SSTableIterator, targetLevel int) ([]*SSTable, error) {
 	minHeap := &MinHeap{
-		useLearnedIndex: db.useLearnedIndex,
+		cmp: db.opts.Comparator,
 	}
 	heap.Init(minHeap)
 
 	for _, it := range iterators {
-		_, err := it.Next()
+		ok, err := it.Next()
 		if err != nil {
 			return nil, err
 		}
-
-		heap.Push(minHeap, &MinHeapItem{
-			iterator: it,
-			key:      it.Key(),
-			value:    it.Value(),
-			opType:   it.OpType(),
-			//Timestamp: int64(idx), // todo: temporary
-		})
+		if !ok {
+			continue
+		}
+		heap.Push(minHeap, newHeapItem(it))
 	}
 	de := make([]*DataEntry, 0)
 	totalSize := 0
 	sstables := make([]*SSTable, 0)
 
 	var before []byte
-	var nextIter func(iterator *SSTableIterator) error
-	nextIter = func(iterator *SSTableIterator) error {
+	var nextIter func(item *MinHeapItem) error
+	nextIter = func(item *MinHeapItem) error {
+		iterator := item.iterator
 		ok, err := iterator.Next()
 		if err != nil {
 			return err
@@ -74,59 +82,64 @@ func (db *DB) mergeIterators(iterators []*SSTableIterator, targetLevel int) ([]*
 		if !ok {
 			return nil
 		}
-		heap.Push(minHeap, &MinHeapItem{
-			iterator: iterator,
-			key:      iterator.Key(),
-			opType:   iterator.OpType(),
-			value:    iterator.Value(),
-		})
+		heap.Push(minHeap, newHeapItem(iterator))
 		return nil
 	}
 
+	smallestSnapshot := db.smallestSnapshot()
+	lastSeqForKey := uint64(encoder.MaxSequenceNumber)
 	for minHeap.Len() > 0 {
 		item := heap.Pop(minHeap).(*MinHeapItem)
 
-		if compare.Compare(before, item.key, db.useLearnedIndex) == 0 {
-			err := nextIter(item.iterator)
-			if err != nil {
-				return nil, err
+		if before == nil || db.opts.Comparator.Compare(before, item.key) != 0 {
+			// 같은 user key 의 entry 가 여러 SSTable 로 나뉘지 않도록 key 가 바뀔 때만 파일을 자른다
+			if totalSize >= db.opts.maxFileSize(targetLevel) {
+				iter, err := db.writeIterator(de, targetLevel)
+				if err != nil {
+					return nil, err
+				}
+				sstables = append(sstables, iter)
+				de = make([]*DataEntry, 0)
+				totalSize = 0
 			}
-			continue
+			before = item.key
+			lastSeqForKey = encoder.MaxSequenceNumber
+		}
+
+		drop := false
+		if lastSeqForKey <= smallestSnapshot {
+			// 더 최근 entry 가 가장 오래된 snapshot 에도 보이므로 이 entry 는 누구에게도 보이지 않는다
+			drop = true
+		} else if item.opType == encoder.OpTypeDelete && item.seq <= smallestSnapshot &&
+			v.isBaseLevelForKey(targetLevel, item.key) {
+			drop = true
 		}
+		lastSeqForKey = item.seq
 
-		if item.opType != encoder.OpTypeDelete {
+		if !drop {
 			de = append(de, &DataEntry{
 				key:    item.key,
 				value:  item.value,
 				opType: item.opType,
+				seq:    item.seq,
 			})
-			before = item.key
-			totalSize += len(item.key) + len(item.value) + 1
-		}
-
-		if totalSize >= calculateMaxFileSize(targetLevel) {
-			iter, err := db.writeIterator(de, targetLevel)
-			if err != nil {
-				return nil, err
-			}
-			sstables = append(sstables, iter)
-			de = make([]*DataEntry, 0)
-			totalSize = 0
+			totalSize += len(item.key) + len(item.value) + encoder.TrailerSize
 		}
 
-		err := nextIter(item.iterator)
+		err := nextIter(item)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	iter, err := db.writeIterator(de, targetLevel)
-	if err != nil {
-		return nil, err
+	if len(de) > 0 {
+		iter, err := db.writeIterator(de, targetLevel)
+		if err != nil {
+			return nil, err
+		}
+		sstables = append(sstables, iter)
 	}
 
-	sstables = append(sstables, iter)
-
 	return sstables, nil
 }
 
@@ -137,13 +150,16 @@ func (db *DB) writeIterator(entries []*DataEntry, targetLevel int) (*SSTable, er
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 
-	writer := NewTempWriter(f)
-	writer.Write(entries)
+	writer := NewTempWriter(f, db.opts, targetLevel)
+	if err = writer.Write(entries); err != nil {
+		return nil, err
+	}
 
-	sst, err := db.OpenSSTable(f)
-	if err != nil {
+	if err = f.Sync(); err != nil {
 		return nil, err
 	}
-	return sst, nil
+
+	return db.openSSTable(meta)
 }
@@ -0,0 +1,249 @@
+package lsm
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/gptjddldi/lsm/db/compare"
+	"github.com/gptjddldi/lsm/db/compression"
+	"github.com/gptjddldi/lsm/db/wal"
+)
+
+// SSTable 파일 이름에 level 이 한 자리로 들어가므로 level 은 10 개를 넘을 수 없다
+const maxNumLevels = 10
+
+// Options 는 Open 에 넘기는 설정. 0 인 필드는 DefaultOptions 의 값을 사용한다
+type Options struct {
+	// memtable 이 이 크기 (bytes) 를 넘으면 L0 SSTable 로 flush 된다. 기본값 10MB
+	MemtableSize int
+
+	// level 의 개수. 2 ~ 10, 기본값 7
+	MaxLevels int
+
+	// L0 의 SSTable 이 이 개수만큼 쌓이면 L1 으로 compaction 한다. 기본값 5
+	L0CompactionTrigger int
+
+	// L(n+1) 은 L(n) 보다 이 배수만큼 더 커질 수 있다. 기본값 10
+	LevelSizeMultiplier int
+
+	// compaction 방식. 기본값 LeveledCompaction
+	CompactionStyle CompactionStyle
+
+	// CompactionStyle 이 UniversalCompaction 일 때의 설정
+	Universal UniversalCompactionOptions
+
+	// SSTable data block 의 크기 (bytes). 기본값 4KB
+	BlockSize int
+
+	// data block 에서 key 전체를 저장하는 restart point 사이의 entry 수. 기본값 16
+	BlockRestartInterval int
+
+	// true 면 data block 에 모든 entry 의 offset 을 저장해서 block 안에서도 순차 탐색 없이 binary search 로 entry 를 찾는다.
+	// key prefix 는 그대로 압축하고 entry 마다 4 byte 를 더 쓴다. format version 4 로 쓰므로 이전 버전은 읽을 수 없다
+	BlockEntryOffsets bool
+
+	// 모든 SSTable 이 공유하는 block cache 의 크기 (bytes). 압축을 푼 block 크기로 계산한다.
+	// 기본값 8MB, 음수면 block cache 를 쓰지 않는다
+	BlockCacheSize int64
+
+	// table cache 가 동시에 열어두는 SSTable 파일 수. 열린 SSTable 만 index 와 bloom filter 를 메모리에 둔다.
+	// 기본값 1000
+	MaxOpenFiles int
+
+	// level 별 bloom filter 의 key 당 bit 수. SSTable 마다 실제 key 개수로 filter 크기를 정한다.
+	// i 번째 값이 level i 에 쓰이고, 없는 level 은 마지막 값을 쓴다. 1 ~ 64, 기본값 10 (false positive 약 1%)
+	BloomBitsPerKey []int
+
+	// level 별 data block 압축 방식. i 번째 값이 level i 에 쓰이고, 없는 level 은 마지막 값을 쓴다.
+	// 압축해도 1/8 이상 줄지 않는 block 은 압축하지 않고 저장한다. 기본값은 모든 level 에 compression.LZ
+	Compression []compression.Type
+
+	// nil 이 아니면 key 의 prefix 도 bloom filter 에 넣는다. ReadOptions.PrefixSameAsStart 참고
+	PrefixExtractor PrefixExtractor
+
+	// user key 의 순서. SSTable 마다 이름이 기록되고, 다른 comparator 로 쓴 파일이 있으면 Open 이 실패한다.
+	// 기본값은 compare.Bytewise
+	Comparator compare.Comparator
+
+	// true 면 SSTable 을 쓸 때 learned index 모델을 학습해서, 그 파일의 key 에서 오차가 작아
+	// binary search 보다 범위를 좁힐 수 있을 때만 모델을 저장한다. 읽을 때는 파일마다 기록된 index 종류를 쓰고,
+	// 기록이 없는 예전 파일만 이 값에 따라 모델을 학습한다. compaction 으로 다시 쓰는 파일은 새로 정한다
+	UseLearnedIndex bool
+
+	// UseLearnedIndex 가 true 일 때 learned index 가 쓰는 모델. 기본값 LinearModel
+	LearnedIndexModel LearnedIndexModel
+
+	// zero value 는 매 쓰기마다 fsync 하는 wal.SyncEveryWrite. DefaultOptions 는 wal.SyncInterval 을 사용한다
+	WAL WALOptions
+}
+
+func DefaultOptions() *Options {
+	return &Options{
+		MemtableSize:         10 << 20,
+		MaxLevels:            7,
+		L0CompactionTrigger:  5,
+		LevelSizeMultiplier:  10,
+		Universal:            DefaultUniversalCompactionOptions(),
+		BlockSize:            4 << 10,
+		BlockRestartInterval: 16,
+		BlockCacheSize:       8 << 20,
+		MaxOpenFiles:         1000,
+		BloomBitsPerKey:      []int{10},
+		Compression:          []compression.Type{compression.LZ},
+		WAL:                  DefaultWALOptions(),
+	}
+}
+
+// withDefaults 는 0 인 필드를 기본값으로 채운 복사본을 반환하고, 범위를 벗어난 값이 있으면 에러를 반환한다
+func (o *Options) withDefaults() (*Options, error) {
+	def := DefaultOptions()
+	if o == nil {
+		def.Comparator = def.comparator()
+		return def, nil
+	}
+	opts := *o
+	opts.Comparator = opts.comparator()
+	if opts.MemtableSize == 0 {
+		opts.MemtableSize = def.MemtableSize
+	}
+	if opts.MaxLevels == 0 {
+		opts.MaxLevels = def.MaxLevels
+	}
+	if opts.L0CompactionTrigger == 0 {
+		opts.L0CompactionTrigger = def.L0CompactionTrigger
+	}
+	if opts.LevelSizeMultiplier == 0 {
+		opts.LevelSizeMultiplier = def.LevelSizeMultiplier
+	}
+	if opts.Universal.SizeRatio == 0 {
+		opts.Universal.SizeRatio = def.Universal.SizeRatio
+	}
+	if opts.Universal.MinMergeWidth == 0 {
+		opts.Universal.MinMergeWidth = def.Universal.MinMergeWidth
+	}
+	if opts.Universal.MaxMergeWidth == 0 {
+		opts.Universal.MaxMergeWidth = def.Universal.MaxMergeWidth
+	}
+	if opts.Universal.MaxSizeAmplificationPercent == 0 {
+		opts.Universal.MaxSizeAmplificationPercent = def.Universal.MaxSizeAmplificationPercent
+	}
+	if opts.BlockSize == 0 {
+		opts.BlockSize = def.BlockSize
+	}
+	if opts.BlockRestartInterval == 0 {
+		opts.BlockRestartInterval = def.BlockRestartInterval
+	}
+	if opts.BlockCacheSize == 0 {
+		opts.BlockCacheSize = def.BlockCacheSize
+	}
+	if opts.MaxOpenFiles == 0 {
+		opts.MaxOpenFiles = def.MaxOpenFiles
+	}
+	if len(opts.BloomBitsPerKey) == 0 {
+		opts.BloomBitsPerKey = def.BloomBitsPerKey
+	}
+	for _, bits := range opts.BloomBitsPerKey {
+		if bits < 1 || bits > 64 {
+			return nil, fmt.Errorf("invalid BloomBitsPerKey %d: must be between 1 and 64", bits)
+		}
+	}
+	if len(opts.Compression) == 0 {
+		opts.Compression = def.Compression
+	}
+	for _, c := range opts.Compression {
+		if _, ok := compression.Lookup(c); !ok {
+			return nil, fmt.Errorf("unknown Compression %v", c)
+		}
+	}
+	if opts.WAL.SyncMode == wal.SyncInterval && opts.WAL.SyncInterval == 0 {
+		opts.WAL.SyncInterval = def.WAL.SyncInterval
+	}
+
+	switch {
+	case opts.MemtableSize < 0:
+		return nil, fmt.Errorf("invalid MemtableSize %d", opts.MemtableSize)
+	case opts.MaxLevels < 2 || opts.MaxLevels > maxNumLevels:
+		return nil, fmt.Errorf("invalid MaxLevels %d: must be between 2 and %d", opts.MaxLevels, maxNumLevels)
+	case opts.L0CompactionTrigger < 1:
+		return nil, fmt.Errorf("invalid L0CompactionTrigger %d", opts.L0CompactionTrigger)
+	case opts.LevelSizeMultiplier < 2:
+		return nil, fmt.Errorf("invalid LevelSizeMultiplier %d: must be at least 2", opts.LevelSizeMultiplier)
+	case !opts.CompactionStyle.valid():
+		return nil, fmt.Errorf("unknown CompactionStyle %v", opts.CompactionStyle)
+	case opts.Universal.SizeRatio < 1:
+		return nil, fmt.Errorf("invalid Universal.SizeRatio %d: must be at least 1", opts.Universal.SizeRatio)
+	case opts.Universal.MinMergeWidth < 2:
+		return nil, fmt.Errorf("invalid Universal.MinMergeWidth %d: must be at least 2", opts.Universal.MinMergeWidth)
+	case opts.Universal.MaxMergeWidth < opts.Universal.MinMergeWidth:
+		return nil, fmt.Errorf("invalid Universal.MaxMergeWidth %d: must be at least MinMergeWidth %d",
+			opts.Universal.MaxMergeWidth, opts.Universal.MinMergeWidth)
+	case opts.Universal.MaxSizeAmplificationPercent < 1:
+		return nil, fmt.Errorf("invalid Universal.MaxSizeAmplificationPercent %d: must be at least 1", opts.Universal.MaxSizeAmplificationPercent)
+	case opts.BlockSize < 0:
+		return nil, fmt.Errorf("invalid BlockSize %d", opts.BlockSize)
+	case !opts.LearnedIndexModel.valid():
+		return nil, fmt.Errorf("unknown LearnedIndexModel %v", opts.LearnedIndexModel)
+	case opts.BlockRestartInterval < 1:
+		return nil, fmt.Errorf("invalid BlockRestartInterval %d", opts.BlockRestartInterval)
+	case opts.MaxOpenFiles < 1:
+		return nil, fmt.Errorf("invalid MaxOpenFiles %d", opts.MaxOpenFiles)
+	case opts.WAL.SyncMode == wal.SyncInterval && opts.WAL.SyncInterval < 0:
+		return nil, fmt.Errorf("invalid WAL.SyncInterval %v", opts.WAL.SyncInterval)
+	}
+	return &opts, nil
+}
+
+// comparator 는 Comparator 가 nil 이면 compare.Bytewise 를 쓴다
+func (o *Options) comparator() compare.Comparator {
+	if o.Comparator != nil {
+		return o.Comparator
+	}
+	return compare.Bytewise
+}
+
+// levelMaxBytes 는 level 이 compaction 없이 가질 수 있는 최대 크기
+func (o *Options) levelMaxBytes(level int) int {
+	return o.MemtableSize * o.L0CompactionTrigger * int(math.Pow(float64(o.LevelSizeMultiplier), float64(level)))
+}
+
+// maxTableFileSize 는 compaction 이 쓰는 SSTable 하나의 최대 크기.
+// index entry 의 block handle 이 uint32 라서 4GB 를 넘는 파일의 block 은 가리킬 수 없다
+const maxTableFileSize = 1 << 30
+
+// maxFileSize 는 compaction 으로 level 에 만들어지는 SSTable 하나의 최대 크기.
+// L0 로 쓰는 universal compaction 은 sorted run 하나를 key 범위가 겹치지 않는 파일 여러 개로 나눌 수 있다
+func (o *Options) maxFileSize(level int) int {
+	if level == 0 {
+		return maxTableFileSize
+	}
+	return min(o.levelMaxBytes(level-1), maxTableFileSize)
+}
+
+// compression 은 level 에 쓰는 data block 의 압축 방식
+func (o *Options) compression(level int) compression.Type {
+	if len(o.Compression) == 0 {
+		return compression.None
+	}
+	return o.Compression[min(level, len(o.Compression)-1)]
+}
+
+// bloomBitsPerKey 는 level 에 쓰는 bloom filter 의 key 당 bit 수
+func (o *Options) bloomBitsPerKey(level int) int {
+	if len(o.BloomBitsPerKey) == 0 {
+		return 10
+	}
+	return o.BloomBitsPerKey[min(level, len(o.BloomBitsPerKey)-1)]
+}
+
+// formatVersion 은 새로 쓰는 SSTable 의 format version
+func (o *Options) formatVersion() uint32 {
+	if o.BlockEntryOffsets {
+		return formatVersion4
+	}
+	return formatVersion3
+}
+
+// blockThreshold 를 넘으면 data block 을 끊는다
+func (o *Options) blockThreshold() int {
+	return int(math.Floor(float64(o.BlockSize) * 0.9))
+}
@@ -0,0 +1,95 @@
+package lsm
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gptjddldi/lsm/db/compare"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptions_WithDefaults(t *testing.T) {
+	opts, err := (*Options)(nil).withDefaults()
+	assert.NoError(t, err)
+	def := DefaultOptions()
+	def.Comparator = compare.Bytewise
+	assert.Equal(t, def, opts)
+
+	opts, err = (&Options{MemtableSize: 1 << 10, UseLearnedIndex: true}).withDefaults()
+	assert.NoError(t, err)
+	assert.Equal(t, 1<<10, opts.MemtableSize)
+	assert.Equal(t, DefaultOptions().MaxLevels, opts.MaxLevels)
+	assert.True(t, opts.UseLearnedIndex)
+	// learned index 를 써도 key 순서는 바뀌지 않는다
+	assert.Equal(t, compare.Bytewise, opts.Comparator)
+
+	// universal compaction 설정도 0 이면 기본값이다
+	opts, err = (&Options{Universal: UniversalCompactionOptions{MaxMergeWidth: 8}}).withDefaults()
+	assert.NoError(t, err)
+	want := DefaultUniversalCompactionOptions()
+	want.MaxMergeWidth = 8
+	assert.Equal(t, want, opts.Universal)
+
+	for _, invalid := range []*Options{
+		{MemtableSize: -1},
+		{MaxLevels: 1},
+		{MaxLevels: maxNumLevels + 1},
+		{LevelSizeMultiplier: 1},
+		{BloomBitsPerKey: []int{10, 0}},
+		{LearnedIndexModel: RadixSplineModel + 1},
+		{BlockRestartInterval: -1},
+		{CompactionStyle: UniversalCompaction + 1},
+		{Universal: UniversalCompactionOptions{MinMergeWidth: 1}},
+		{Universal: UniversalCompactionOptions{MinMergeWidth: 4, MaxMergeWidth: 3}},
+		{Universal: UniversalCompactionOptions{SizeRatio: -1}},
+		{Universal: UniversalCompactionOptions{MaxSizeAmplificationPercent: -1}},
+	} {
+		_, err = invalid.withDefaults()
+		assert.Error(t, err)
+	}
+}
+
+func TestDB_SmallOptionsCompact(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{
+		MemtableSize:        4 << 10,
+		MaxLevels:           3,
+		L0CompactionTrigger: 2,
+		BlockSize:           512,
+	}
+	db, err := Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 2000
+	for i := 0; i < n; i++ {
+		assert.NoError(t, db.Insert([]byte(fmt.Sprintf("key%05d", i)), []byte(fmt.Sprintf("value%05d", i))))
+	}
+	db.Close()
+
+	db, err = Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	v := db.currentVersion()
+	assert.Len(t, v.levels, 3)
+	assert.NotEmpty(t, append(v.levels[1].sstables, v.levels[2].sstables...))
+	v.unref()
+
+	for i := 0; i < n; i++ {
+		val, err := db.Get([]byte(fmt.Sprintf("key%05d", i)))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("value%05d", i)), val)
+	}
+}
+
+func TestOptions_MaxFileSize(t *testing.T) {
+	opts, err := (*Options)(nil).withDefaults()
+	assert.NoError(t, err)
+	assert.Equal(t, opts.levelMaxBytes(0), opts.maxFileSize(1))
+	// 아래 level 의 목표 크기가 커도 index 가 가리킬 수 있는 크기를 넘지 않는다
+	assert.Equal(t, maxTableFileSize, opts.maxFileSize(opts.MaxLevels-1))
+	assert.Equal(t, maxTableFileSize, opts.maxFileSize(0))
+}
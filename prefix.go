@@ -0,0 +1,48 @@
+package lsm
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// PrefixExtractor 는 key 에서 prefix 를 뽑는다. prefix 는 SSTable 의 bloom filter 에 함께 들어가서
+// ReadOptions.PrefixSameAsStart 인 iterator 가 prefix 가 없는 SSTable 을 건너뛸 수 있게 한다
+type PrefixExtractor interface {
+	// Name 은 SSTable 에 기록된다. 이름이 다른 extractor 로 쓴 SSTable 의 filter 는 prefix 확인에 쓰지 않는다
+	Name() string
+	// InDomain 은 key 에서 prefix 를 뽑을 수 있는지 반환한다
+	InDomain(key []byte) bool
+	// Transform 은 InDomain 인 key 의 prefix 를 반환한다
+	Transform(key []byte) []byte
+}
+
+type fixedPrefix int
+
+// FixedPrefix 는 앞 n byte 를 prefix 로 쓴다. n byte 보다 짧은 key 는 prefix 가 없다
+func FixedPrefix(n int) PrefixExtractor {
+	return fixedPrefix(n)
+}
+
+func (p fixedPrefix) Name() string                { return fmt.Sprintf("lsm.FixedPrefix.%d", int(p)) }
+func (p fixedPrefix) InDomain(key []byte) bool    { return len(key) >= int(p) }
+func (p fixedPrefix) Transform(key []byte) []byte { return key[:p] }
+
+type delimitedPrefix byte
+
+// DelimitedPrefix 는 처음 나오는 delim 까지 (delim 포함) 를 prefix 로 쓴다.
+// "entity_id/attribute" 꼴의 key 는 DelimitedPrefix('/') 로 "entity_id/" 를 얻는다
+func DelimitedPrefix(delim byte) PrefixExtractor {
+	return delimitedPrefix(delim)
+}
+
+func (p delimitedPrefix) Name() string {
+	return fmt.Sprintf("lsm.DelimitedPrefix.%02x", byte(p))
+}
+
+func (p delimitedPrefix) InDomain(key []byte) bool {
+	return bytes.IndexByte(key, byte(p)) >= 0
+}
+
+func (p delimitedPrefix) Transform(key []byte) []byte {
+	return key[:bytes.IndexByte(key, byte(p))+1]
+}
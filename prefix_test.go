@@ -0,0 +1,85 @@
+package lsm
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrefixExtractors(t *testing.T) {
+	fixed := FixedPrefix(3)
+	assert.True(t, fixed.InDomain([]byte("abcd")))
+	assert.False(t, fixed.InDomain([]byte("ab")))
+	assert.Equal(t, []byte("abc"), fixed.Transform([]byte("abcd")))
+
+	delimited := DelimitedPrefix('/')
+	assert.True(t, delimited.InDomain([]byte("user42/name")))
+	assert.False(t, delimited.InDomain([]byte("user42")))
+	assert.Equal(t, []byte("user42/"), delimited.Transform([]byte("user42/name/first")))
+	assert.NotEqual(t, fixed.Name(), delimited.Name())
+}
+
+// openPrefixTestDB 는 entity 마다 SSTable 하나를 만든다
+func openPrefixTestDB(t *testing.T, extractor PrefixExtractor) *DB {
+	db, err := Open(t.TempDir(), &Options{L0CompactionTrigger: 100, PrefixExtractor: extractor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+	for e := 0; e < 5; e++ {
+		for a := 0; a < 20; a++ {
+			key := fmt.Sprintf("entity%d/attr%02d", e, a)
+			assert.NoError(t, db.Insert([]byte(key), []byte("value-"+key)))
+		}
+		flushMutable(t, db)
+	}
+	return db
+}
+
+func TestDB_PrefixSameAsStart(t *testing.T) {
+	db := openPrefixTestDB(t, DelimitedPrefix('/'))
+	assert.NoError(t, db.Insert([]byte("entity2/attr99"), []byte("in memtable")))
+
+	v := db.currentVersion()
+	for _, sst := range v.levels[0].sstables {
+		owns := sst.IsInKeyRange([]byte("entity2/"), []byte("entity2/~"))
+		assert.Equal(t, owns, sst.prefixMayMatch(DelimitedPrefix('/'), []byte("entity2/")))
+		// 다른 extractor 로 쓴 filter 는 prefix 를 배제하지 않는다
+		assert.True(t, sst.prefixMayMatch(FixedPrefix(8), []byte("entity2/")))
+	}
+	v.unref()
+
+	it := db.NewIterator(&ReadOptions{PrefixSameAsStart: true})
+	var keys []string
+	for ok := it.Seek([]byte("entity2/")); ok; ok = it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	assert.Len(t, keys, 21)
+	assert.Equal(t, "entity2/attr00", keys[0])
+	assert.Equal(t, "entity2/attr99", keys[20])
+
+	// 역방향도 prefix 를 벗어나면 멈춘다
+	assert.True(t, it.Seek([]byte("entity2/attr05")))
+	n := 0
+	for ok := true; ok; ok = it.Prev() {
+		n++
+	}
+	assert.Equal(t, 6, n)
+	assert.NoError(t, it.Close())
+
+	// entity2 의 SSTable 만 읽는다
+	assert.Equal(t, uint64(1), db.BlockCacheStats().Misses)
+}
+
+func TestDB_PrefixSameAsStartWithoutExtractor(t *testing.T) {
+	db := openPrefixTestDB(t, nil)
+
+	it := db.NewIterator(&ReadOptions{PrefixSameAsStart: true})
+	defer it.Close()
+	n := 0
+	for ok := it.Seek([]byte("entity2/")); ok; ok = it.Next() {
+		n++
+	}
+	assert.Equal(t, 60, n)
+}
@@ -0,0 +1,83 @@
+package lsm
+
+// Snapshot 은 만들어진 시점까지의 쓰기만 보이는 읽기 전용 view.
+// 다 쓴 뒤 Release 해야 compaction 이 오래된 entry 를 정리할 수 있다
+type Snapshot struct {
+	db  *DB
+	seq uint64
+
+	prev, next *Snapshot // db.snapshots 의 원소, 만들어진 순서 (sequence 오름차순)
+	released   bool
+}
+
+// snapshotList 는 살아있는 snapshot 의 이중 연결 리스트. head 는 sentinel
+type snapshotList struct {
+	head Snapshot
+}
+
+func (l *snapshotList) init() {
+	l.head.prev = &l.head
+	l.head.next = &l.head
+}
+
+func (l *snapshotList) empty() bool {
+	return l.head.next == &l.head
+}
+
+// oldest 는 가장 오래된 snapshot 의 sequence 를 반환한다
+func (l *snapshotList) oldest() uint64 {
+	return l.head.next.seq
+}
+
+func (l *snapshotList) pushBack(s *Snapshot) {
+	s.prev = l.head.prev
+	s.next = &l.head
+	s.prev.next = s
+	l.head.prev = s
+}
+
+func (l *snapshotList) remove(s *Snapshot) {
+	s.prev.next = s.next
+	s.next.prev = s.prev
+	s.prev, s.next = nil, nil
+}
+
+// NewSnapshot 은 지금까지 쓰인 데이터의 snapshot 을 만든다
+func (db *DB) NewSnapshot() *Snapshot {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	s := &Snapshot{db: db, seq: db.lastSeq.Load()}
+	db.snapshots.pushBack(s)
+	return s
+}
+
+// Release 는 여러 번 호출해도 된다
+func (s *Snapshot) Release() {
+	db := s.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if s.released {
+		return
+	}
+	s.released = true
+	db.snapshots.remove(s)
+}
+
+// Sequence 는 snapshot 에서 보이는 마지막 sequence 를 반환한다
+func (s *Snapshot) Sequence() uint64 {
+	return s.seq
+}
+
+// smallestSnapshot 은 compaction 이 지우면 안 되는 가장 오래된 sequence 를 반환한다.
+// 이 sequence 이하의 entry 중 key 마다 가장 최근 것만 누군가에게 보인다
+func (db *DB) smallestSnapshot() uint64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.snapshots.empty() {
+		return db.lastSeq.Load()
+	}
+	return db.snapshots.oldest()
+}
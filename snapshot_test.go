@@ -0,0 +1,145 @@
+package lsm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// flushMutable 은 mutable memtable 을 바로 L0 SSTable 로 내린다
+func flushMutable(t *testing.T, db *DB) {
+	db.writeMu.Lock()
+	m := db.memtables.mutable
+	next, err := db.newMemtable()
+	if err != nil {
+		db.writeMu.Unlock()
+		t.Fatal(err)
+	}
+	db.mu.Lock()
+	db.memtables.queue = append(db.memtables.queue, m)
+	db.memtables.mutable = next
+	db.mu.Unlock()
+	db.writeMu.Unlock()
+
+	assert.NoError(t, db.flushMemtable(m))
+}
+
+func countEntries(t *testing.T, v *version, key []byte) int {
+	count := 0
+	for _, l := range v.levels {
+		for _, sst := range l.sstables {
+			it, err := sst.Iterator()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ok, err := it.Seek(key)
+			for ok && err == nil && string(it.Key()) == string(key) {
+				count++
+				ok, err = it.Next()
+			}
+			assert.NoError(t, err)
+		}
+	}
+	return count
+}
+
+func TestSnapshot_Get(t *testing.T) {
+	db, err := Open(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	assert.NoError(t, db.Insert([]byte("key"), []byte("v1")))
+	assert.NoError(t, db.Insert([]byte("deleted"), []byte("v1")))
+	snap := db.NewSnapshot()
+	defer snap.Release()
+	assert.NoError(t, db.Insert([]byte("key"), []byte("v2")))
+	assert.NoError(t, db.Delete([]byte("deleted")))
+	assert.NoError(t, db.Insert([]byte("new"), []byte("v1")))
+
+	check := func() {
+		opts := &ReadOptions{Snapshot: snap}
+		val, err := db.GetWithOptions([]byte("key"), opts)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("v1"), val)
+		val, err = db.GetWithOptions([]byte("deleted"), opts)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("v1"), val)
+		_, err = db.GetWithOptions([]byte("new"), opts)
+		assert.ErrorIs(t, err, ErrorKeyNotFound)
+
+		val, err = db.Get([]byte("key"))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("v2"), val)
+		_, err = db.Get([]byte("deleted"))
+		assert.ErrorIs(t, err, ErrorKeyNotFound)
+	}
+	check()
+	flushMutable(t, db)
+	check()
+}
+
+func TestSnapshot_Iterator(t *testing.T) {
+	db, err := Open(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	assert.NoError(t, db.Insert([]byte("a"), []byte("v1")))
+	assert.NoError(t, db.Insert([]byte("b"), []byte("v1")))
+	snap := db.NewSnapshot()
+	defer snap.Release()
+	assert.NoError(t, db.Delete([]byte("a")))
+	assert.NoError(t, db.Insert([]byte("b"), []byte("v2")))
+	assert.NoError(t, db.Insert([]byte("c"), []byte("v2")))
+
+	it := db.NewIterator(&ReadOptions{Snapshot: snap})
+	defer it.Close()
+	var got []string
+	for ok := it.SeekToLast(); ok; ok = it.Prev() {
+		got = append(got, string(it.Key())+"="+string(it.Value()))
+	}
+	assert.NoError(t, it.Error())
+	assert.Equal(t, []string{"b=v1", "a=v1"}, got)
+}
+
+func TestSnapshot_CompactionKeepsVisibleVersions(t *testing.T) {
+	db, err := Open(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	assert.NoError(t, db.Insert([]byte("key"), []byte("v1")))
+	assert.NoError(t, db.Insert([]byte("deleted"), []byte("v1")))
+	flushMutable(t, db)
+	snap := db.NewSnapshot()
+	assert.NoError(t, db.Insert([]byte("key"), []byte("v2")))
+	assert.NoError(t, db.Delete([]byte("deleted")))
+	flushMutable(t, db)
+
+	assert.NoError(t, db.compactLevel(0))
+	opts := &ReadOptions{Snapshot: snap}
+	val, err := db.GetWithOptions([]byte("key"), opts)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v1"), val)
+	val, err = db.GetWithOptions([]byte("deleted"), opts)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v1"), val)
+
+	snap.Release()
+	// L0 는 비었으므로 L1 의 SSTable 을 L2 로 내리며 다시 합친다
+	assert.NoError(t, db.compactLevel(1))
+	v := db.currentVersion()
+	defer v.unref()
+	assert.Equal(t, 1, countEntries(t, v, []byte("key")))
+	assert.Equal(t, 0, countEntries(t, v, []byte("deleted")))
+
+	val, err = db.Get([]byte("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v2"), val)
+	_, err = db.Get([]byte("deleted"))
+	assert.ErrorIs(t, err, ErrorKeyNotFound)
+}
@@ -1,315 +1,581 @@
 package lsm
 
 import (
-	"bufio"
-	"encoding/binary"
+	"fmt"
 	"os"
+	"sort"
+	"sync/atomic"
 
+	"github.com/gptjddldi/lsm/db/cache"
 	"github.com/gptjddldi/lsm/db/compare"
 	"github.com/gptjddldi/lsm/db/encoder"
+	"github.com/gptjddldi/lsm/db/storage"
 )
 
+// SSTable 은 version 에 들어있는 SSTable 파일. key 범위와 크기만 메모리에 두고,
+// 파일과 index, bloom filter 는 읽을 때 table cache 에서 tableReader 로 연다
 type SSTable struct {
+	meta   *storage.FileMetadata // DB 밖에서 연 SSTable 은 nil
+	size   int64
+	minKey []byte
+	maxKey []byte
+
+	largestSeq uint64 // format version 0 파일은 0
+
+	cmp compare.Comparator
+
+	tables *tableCache  // nil 이면 reader 를 직접 들고 있다
+	reader *tableReader // NewSSTable 로 DB 밖에서 연 SSTable 의 reader
+
+	refs     int32       // 이 SSTable 을 포함하는 version 수
+	obsolete atomic.Bool // 어떤 version 에서 지워졌으면 true
+}
+
+// tableReader 는 열린 SSTable 파일과 그 index, bloom filter
+type tableReader struct {
+	footer      *footer
 	index       *BaseIndex
 	bloomFilter *BloomFilter
+	props       *tableProperties
+	metaindex   map[string]blockHandle // meta block 이름 -> handle
 
-	file *os.File
+	file  *os.File
+	meta  *storage.FileMetadata // nil 이면 block cache 를 쓰지 않는다
+	cache *cache.Cache          // DB 의 모든 SSTable 이 공유한다. nil 이면 cache 를 쓰지 않는다
 
 	minKey []byte
 	maxKey []byte
 
-	useLearnedIndex bool
+	cmp               compare.Comparator
+	useLearnedIndex   bool
+	learnedIndexModel LearnedIndexModel
+	indexStats        *indexStats // nil 이면 lookup 통계를 모으지 않는다
+
+	refs atomic.Int32 // table cache 와 읽고 있는 쪽이 하나씩 잡는다. 0 이 되면 파일을 닫는다
 }
 
+// SSTableIterator 는 index block 을 따라 data block 을 하나씩 읽는다.
+// 처음 만들어졌을 때는 아무 entry 도 가리키지 않고, 첫 Next 가 첫 entry 로 이동한다.
+// reader 는 block 을 읽는 동안에만 잡으므로 iterator 가 열린 파일을 붙잡지 않는다
 type SSTableIterator struct {
-	sstable    *SSTable
-	reader     *bufio.Reader
-	entry      *DataEntry
-	curOffset  uint64
-	stopOffset uint64
+	sstable  *SSTable
+	fileName string
+	entries  []IndexEntry // 처음 이동할 때 index 에서 가져온다
+	blockIdx int
+	block    blockIterator
+	entry    *DataEntry // nil 이면 아무 entry 도 가리키지 않는다
+	ro       blockReadOptions
 }
 
-func NewSSTable(file *os.File, useLearnedIndex bool) (*SSTable, error) {
-	sst := &SSTable{
-		file:            file,
-		useLearnedIndex: useLearnedIndex,
+// blockReadOptions 는 data block 을 읽는 방법
+type blockReadOptions struct {
+	verifyChecksums bool // 디스크에서 읽는 block 의 checksum 을 확인한다
+	fillCache       bool // 디스크에서 읽은 block 을 block cache 에 넣는다
+}
+
+func newBlockReadOptions(opts *ReadOptions) blockReadOptions {
+	if opts == nil {
+		return blockReadOptions{fillCache: true}
 	}
+	return blockReadOptions{verifyChecksums: opts.VerifyChecksums, fillCache: !opts.DontFillCache}
+}
 
-	index, err := sst.buildIndex()
+// NewSSTable 은 DB 밖에서 file 을 SSTable 로 연다. opts 의 Comparator 와 UseLearnedIndex 만 쓰고,
+// nil 이면 기본값을 쓴다. file 은 호출자가 닫는다
+func NewSSTable(file *os.File, opts *Options) (*SSTable, error) {
+	opts, err := opts.withDefaults()
 	if err != nil {
 		return nil, err
 	}
-	sst.index = &index
-
-	bloomFilter, err := sst.readBloomFilter()
+	r, err := openTableReader(file, nil, nil, nil, opts)
 	if err != nil {
 		return nil, err
 	}
-	sst.bloomFilter = bloomFilter
-
-	sst.minKey = sst.getFirstKeyFromFile()
-	sst.maxKey = (*sst.index).LastEntry().key
+	return r.newSSTable(nil), nil
+}
 
-	return sst, err
+// newSSTable 은 r 의 key 범위와 크기를 가진 SSTable 을 만든다. tables 가 nil 이면 r 을 계속 들고 있는다
+func (r *tableReader) newSSTable(tables *tableCache) *SSTable {
+	sst := &SSTable{
+		meta:       r.meta,
+		minKey:     r.minKey,
+		maxKey:     r.maxKey,
+		largestSeq: r.props.largestSeq,
+		cmp:        r.cmp,
+		tables:     tables,
+	}
+	if info, err := r.file.Stat(); err == nil {
+		sst.size = info.Size()
+	}
+	if tables == nil {
+		sst.reader = r
+	}
+	return sst
 }
 
-func (s *SSTable) getFirstKeyFromFile() []byte {
-	firstIndexEntry := (*s.index).FirstEntry()
-	length := binary.LittleEndian.Uint32(firstIndexEntry.value[4:8])
+// openTableReader 는 file 의 footer, index, bloom filter 를 읽는다. 돌려받은 reader 의 참조는 하나다.
+// file 이 opts.Comparator 와 다른 comparator 로 쓰였으면 ErrComparatorMismatch 를 반환한다
+func openTableReader(file *os.File, meta *storage.FileMetadata, blockCache *cache.Cache, stats *indexStats, opts *Options) (*tableReader, error) {
+	r := &tableReader{
+		file:              file,
+		meta:              meta,
+		cache:             blockCache,
+		indexStats:        stats,
+		cmp:               opts.Comparator,
+		useLearnedIndex:   opts.UseLearnedIndex,
+		learnedIndexModel: opts.LearnedIndexModel,
+	}
 
-	buf, err := s.readBlockAt(0, length)
+	var err error
+	r.footer, err = r.readFooter()
 	if err != nil {
-		return nil
+		return nil, err
 	}
 
-	var keyLen uint64
-	var n, offset int
-	keyLen, n = binary.Uvarint(buf[offset:])
-
-	offset += n
-	_, n = binary.Uvarint(buf[offset:])
-	offset += n
-	key := buf[offset : offset+int(keyLen)]
-	offset += int(keyLen)
-
-	return key
-}
-
-func (s *SSTable) readFooter() ([]byte, error) {
-	fileSize, err := s.file.Stat()
+	r.metaindex, err = r.readMetaIndex()
 	if err != nil {
 		return nil, err
 	}
-	footerSize := 16
-	footer := make([]byte, footerSize)
-	_, err = s.file.ReadAt(footer, fileSize.Size()-int64(footerSize))
+	r.props, err = r.readProperties()
 	if err != nil {
 		return nil, err
 	}
-	return footer, nil
-}
+	// comparator 이름이 없는 예전 파일은 확인하지 않는다
+	if r.props.comparator != "" && r.props.comparator != r.cmp.Name() {
+		return nil, fmt.Errorf("%w: %s was written with %s, opened with %s",
+			ErrComparatorMismatch, file.Name(), r.props.comparator, r.cmp.Name())
+	}
 
-func (s *SSTable) indexLength() (uint64, error) {
-	footer, err := s.readFooter()
+	// learned index 는 metaindex 에 저장된 모델을 읽으므로 metaindex 뒤에 만든다
+	index, err := r.buildIndex()
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	return binary.LittleEndian.Uint64(footer[:8]), nil
-}
+	r.index = &index
 
-func (s *SSTable) indexOffset() (uint64, error) {
-	footer, err := s.readFooter()
+	bloomFilter, err := r.readBloomFilter()
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	fileSize, err := s.file.Stat()
+	r.bloomFilter = bloomFilter
+
+	r.minKey, err = r.getFirstKeyFromFile()
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
+	r.maxKey = encoder.UserKey((*r.index).LastEntry().key)
 
-	indexSize := binary.LittleEndian.Uint64(footer[:8])
-	bloomFilterSize := binary.LittleEndian.Uint64(footer[8:])
+	r.refs.Store(1)
+	return r, nil
+}
 
-	totalSize := uint64(fileSize.Size())
+func (r *tableReader) ref() {
+	r.refs.Add(1)
+}
 
-	// indexOffset 계산
-	// 전체 크기 - (footer 크기 + bloomFilter 크기 + index 크기)
-	indexOffset := totalSize - (16 + bloomFilterSize + indexSize)
+// unref 는 마지막 참조가 사라지면 파일을 닫는다
+func (r *tableReader) unref() {
+	if r.refs.Add(-1) == 0 {
+		r.file.Close()
+	}
+}
 
-	return indexOffset, nil
+// acquireReader 는 참조를 올린 reader 를 반환한다. 다 쓰고 나면 unref 를 호출해야 한다
+func (s *SSTable) acquireReader() (*tableReader, error) {
+	if s.tables == nil {
+		s.reader.ref()
+		return s.reader, nil
+	}
+	return s.tables.find(s.meta)
 }
 
-func (s *SSTable) buildIndex() (BaseIndex, error) {
-	indexLength, err := s.indexLength()
+func (r *tableReader) getFirstKeyFromFile() ([]byte, error) {
+	offset, length := (*r.index).FirstEntry().blockHandle()
+	buf, err := r.readDataBlock(offset, length, true)
 	if err != nil {
 		return nil, err
 	}
-	indexOffset, err := s.indexOffset()
+	block, err := r.newBlockIterator(offset, buf)
 	if err != nil {
 		return nil, err
 	}
-	index := make([]byte, indexLength)
-	_, err = s.file.ReadAt(index, int64(indexOffset))
-	if err != nil {
-		return nil, err
+	if !block.SeekToFirst() {
+		if err := block.Err(); err != nil {
+			return nil, r.corruption(offset, "%v", err)
+		}
+		return nil, r.corruption(offset, "empty data block")
 	}
+	return encoder.UserKey(block.Key()), nil
+}
 
-	if s.useLearnedIndex {
-		return NewLearnedIndex(index), nil
+// openBlock 은 offset 의 data block 을 block cache 나 디스크에서 읽어 iterator 를 만든다
+func (r *tableReader) openBlock(offset, length uint64, ro blockReadOptions) (blockIterator, error) {
+	buf, err := r.readCachedDataBlock(offset, length, ro)
+	if err != nil {
+		return nil, err
 	}
-	return NewIndex(index), nil
+	return r.newBlockIterator(offset, buf)
 }
 
-func (s *SSTable) bloomFilterOffset() (uint64, error) {
-	fileSize, err := s.file.Stat()
+func (r *tableReader) newBlockIterator(offset uint64, buf []byte) (blockIterator, error) {
+	block, err := newBlockIterator(buf, blockLayoutOf(r.footer.version), r.compareInternal)
 	if err != nil {
-		return 0, err
+		return nil, r.corruption(offset, "%v", err)
 	}
+	return block, nil
+}
 
-	bloomFilterSize, err := s.bloomFilterSize()
+// readCachedDataBlock 은 압축을 푼 data block 을 block cache 에서 찾고, 없으면 디스크에서 읽는다
+func (r *tableReader) readCachedDataBlock(offset, length uint64, ro blockReadOptions) ([]byte, error) {
+	if r.cache == nil || r.meta == nil {
+		return r.readDataBlock(offset, length, ro.verifyChecksums)
+	}
+	key := cache.Key{FileNum: uint64(r.meta.FileNum()), Offset: offset}
+	if buf, ok := r.cache.Get(key); ok {
+		return buf, nil
+	}
+	// cache 에 들어간 block 은 checksum 확인 없이 다시 쓰이므로 넣기 전에 항상 확인한다
+	buf, err := r.readDataBlock(offset, length, ro.verifyChecksums || ro.fillCache)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	if ro.fillCache {
+		r.cache.Set(key, buf)
 	}
+	return buf, nil
+}
 
-	// 전체 파일 크기
-	totalSize := uint64(fileSize.Size())
+func (r *tableReader) compareInternal(a, b []byte) int {
+	return compare.CompareInternal(r.cmp, a, b)
+}
 
-	// bloomFilterOffset 계산
-	// 전체 크기 - (footer 크기 + bloomFilter 크기)
-	bloomFilterOffset := totalSize - (16 + bloomFilterSize)
+func (r *tableReader) buildIndex() (BaseIndex, error) {
+	index, err := r.readBlock(r.footer.index.offset, r.footer.index.length, true)
+	if err != nil {
+		return nil, err
+	}
+	entries := parseIndexEntries(index)
+	if len(entries) == 0 {
+		return nil, r.corruption(r.footer.index.offset, "empty index block")
+	}
+	for _, ie := range entries {
+		if len(ie.value) != 8 {
+			return nil, r.corruption(r.footer.index.offset, "invalid block handle in index block")
+		}
+	}
 
-	return bloomFilterOffset, nil
+	// index 종류는 쓸 때 파일마다 정한다. 모델이 있으면 Options 와 관계없이 그대로 쓰고,
+	// index 종류가 기록되지 않은 예전 파일만 UseLearnedIndex 에 따라 다시 학습한다.
+	// key 를 uint64 로 바꿀 수 없는 comparator 로 쓴 파일의 모델은 쓰지 않는다
+	if h, ok := r.metaindex[metaLearnedIndexName]; ok && keyMapperSupports(r.cmp) {
+		buf, err := r.readBlock(h.offset, h.length, true)
+		if err != nil {
+			return nil, err
+		}
+		idx, err := decodeLearnedIndex(buf, entries, r.cmp)
+		if err != nil {
+			return nil, r.corruption(h.offset, "%v", err)
+		}
+		return idx, nil
+	}
+	if r.props.indexType == "" && r.useLearnedIndex {
+		if idx := trainLearnedIndex(entries, r.cmp, r.learnedIndexModel); idx != nil {
+			return idx, nil
+		}
+	}
+	return &Index{entries: entries, cmp: r.cmp}, nil
 }
 
-func (s *SSTable) bloomFilterSize() (uint64, error) {
-	footer, err := s.readFooter()
+func (r *tableReader) readBloomFilter() (*BloomFilter, error) {
+	bloomFilter, err := r.readBlock(r.footer.filter.offset, r.footer.filter.length, true)
+	if err != nil {
+		return nil, err
+	}
+
+	bf, err := LoadBloomFilter(bloomFilter)
 	if err != nil {
-		return 0, err
+		return nil, r.corruption(r.footer.filter.offset, "invalid bloom filter: %v", err)
 	}
-	return binary.LittleEndian.Uint64(footer[8:]), nil
+	return bf, nil
 }
 
-func (s *SSTable) readBloomFilter() (*BloomFilter, error) {
-	bloomFilterOffset, err := s.bloomFilterOffset()
+// readMetaIndex 는 version 0 파일이면 빈 map 을 반환한다
+func (r *tableReader) readMetaIndex() (map[string]blockHandle, error) {
+	if r.footer.version == formatVersion0 {
+		return map[string]blockHandle{}, nil
+	}
+	buf, err := r.readBlock(r.footer.metaindex.offset, r.footer.metaindex.length, true)
 	if err != nil {
 		return nil, err
 	}
-	bloomFilterSize, err := s.bloomFilterSize()
+	handles, err := decodeMetaIndex(buf)
 	if err != nil {
-		return nil, err
+		return nil, r.corruption(r.footer.metaindex.offset, "%v", err)
+	}
+	return handles, nil
+}
+
+// readProperties 는 version 0 파일이면 빈 properties 를 반환한다. readMetaIndex 뒤에 호출한다
+func (r *tableReader) readProperties() (*tableProperties, error) {
+	if r.footer.version == formatVersion0 {
+		return &tableProperties{}, nil
 	}
-	bloomFilter := make([]byte, bloomFilterSize)
-	_, err = s.file.ReadAt(bloomFilter, int64(bloomFilterOffset))
+	buf, err := r.readBlock(r.footer.properties.offset, r.footer.properties.length, true)
 	if err != nil {
 		return nil, err
 	}
-
-	return LoadBloomFilter(bloomFilter)
+	props, err := decodeTableProperties(buf)
+	if err != nil {
+		return nil, r.corruption(r.footer.properties.offset, "%v", err)
+	}
+	if h, ok := r.metaindex[metaStringPropertiesName]; ok {
+		buf, err = r.readBlock(h.offset, h.length, true)
+		if err != nil {
+			return nil, err
+		}
+		if err = props.decodeStrings(buf); err != nil {
+			return nil, r.corruption(h.offset, "%v", err)
+		}
+	}
+	return props, nil
 }
 
+// Contains 는 bloom filter 로 searchKey 가 있을 수 있는지 확인한다. 파일을 열지 못하면 true 를 반환한다
 func (s *SSTable) Contains(searchKey []byte) bool {
-	return s.bloomFilter.Contains(searchKey)
+	r, err := s.acquireReader()
+	if err != nil {
+		return true
+	}
+	defer r.unref()
+	return r.bloomFilter.Contains(searchKey)
 }
 
+// Get 은 searchKey 의 가장 최근 entry 를 반환한다. tombstone 도 그대로 반환한다
 func (s *SSTable) Get(searchKey []byte) (*encoder.EncodedValue, error) {
+	return s.getAt(searchKey, encoder.MaxSequenceNumber, newBlockReadOptions(nil))
+}
+
+// getAt 은 sequence 가 seq 이하인 searchKey 의 가장 최근 entry 를 반환한다
+func (s *SSTable) getAt(searchKey []byte, seq uint64, ro blockReadOptions) (*encoder.EncodedValue, error) {
 	// searchKey > maxKey 또는 searchKey < minKey 인 경우 NOT FOUND
-	if compare.Compare(searchKey, s.maxKey, s.useLearnedIndex) == 1 || compare.Compare(searchKey, s.minKey, s.useLearnedIndex) == -1 {
+	if s.cmp.Compare(searchKey, s.maxKey) > 0 || s.cmp.Compare(searchKey, s.minKey) < 0 {
 		return nil, ErrorKeyNotFound
 	}
 
-	if !s.Contains(searchKey) {
+	r, err := s.acquireReader()
+	if err != nil {
+		return nil, err
+	}
+	defer r.unref()
+
+	if !r.bloomFilter.Contains(searchKey) {
 		return nil, ErrorKeyNotFound
 	}
 
-	return s.get(searchKey)
+	return r.get(encoder.MakeLookupKey(searchKey, seq), ro)
 }
 
-func (s *SSTable) get(searchKey []byte) (*encoder.EncodedValue, error) {
-	ie := (*s.index).Get(searchKey)
+func (r *tableReader) get(lookupKey []byte, ro blockReadOptions) (*encoder.EncodedValue, error) {
+	offset, length := findIndexEntry(*r.index, lookupKey, r.indexStats).blockHandle()
 
-	offset := binary.LittleEndian.Uint32(ie.value[:4])
-	length := binary.LittleEndian.Uint32(ie.value[4:8])
-
-	block, err := s.readBlockAt(offset, length)
+	block, err := r.openBlock(offset, length, ro)
 	if err != nil {
 		return nil, err
 	}
 
-	return s.sequentialSearchBuf(block, searchKey)
+	// lookupKey 보다 크거나 같은 첫 entry 가 같은 user key 인지 확인한다
+	if !block.Seek(lookupKey) {
+		if err := block.Err(); err != nil {
+			return nil, r.corruption(offset, "%v", err)
+		}
+		return nil, ErrorKeyNotFound
+	}
+	userKey, seq, opType := encoder.ParseInternalKey(block.Key())
+	if r.cmp.Compare(userKey, encoder.UserKey(lookupKey)) != 0 {
+		return nil, ErrorKeyNotFound
+	}
+	// block 은 cache 에서 여러 읽기가 공유하므로 value 를 복사해서 넘긴다
+	value := append([]byte(nil), block.Value()...)
+	return encoder.NewEncodedValue(opType, value, seq), nil
 }
 
-func (s *SSTable) readBlockAt(offset, length uint32) ([]byte, error) {
-	// Create a byte slice to hold the block
-	block := make([]byte, length)
-
-	// Read the block at the specified offset without changing the file's current position
-	_, err := s.file.ReadAt(block, int64(offset))
+// prefixMayMatch 는 extractor 로 쓴 bloom filter 가 prefix 를 가진 key 를 배제하지 못하면 true 를 반환한다.
+// 다른 extractor 로 쓴 SSTable 이나 파일을 열지 못한 경우도 true
+func (s *SSTable) prefixMayMatch(extractor PrefixExtractor, prefix []byte) bool {
+	r, err := s.acquireReader()
 	if err != nil {
-		return nil, err
+		return true
 	}
-
-	return block, nil
-}
-
-func (s *SSTable) sequentialSearchBuf(buf []byte, searchKey []byte) (*encoder.EncodedValue, error) {
-	var offset int
-	for {
-		var keyLen, valLen uint64
-		var n int
-		keyLen, n = binary.Uvarint(buf[offset:])
-		if n <= 0 {
-			break
-		}
-		offset += n
-		valLen, n = binary.Uvarint(buf[offset:])
-		offset += n
-		key := buf[offset : offset+int(keyLen)]
-		offset += int(keyLen)
-		val := buf[offset : offset+int(valLen)]
-		offset += int(valLen)
-		cmp := compare.Compare(searchKey, key, s.useLearnedIndex)
-		if cmp == 0 {
-			return encoder.Decode(val), nil
-		}
-		if cmp < 0 {
-			break
-		}
+	defer r.unref()
+	if r.props.prefixExtractor != extractor.Name() {
+		return true
 	}
-	return nil, ErrorKeyNotFound
+	return r.bloomFilter.Contains(prefix)
 }
 
 func (s *SSTable) IsInKeyRange(min, max []byte) bool {
-	if compare.Compare(s.minKey, max, s.useLearnedIndex) > 0 {
+	if s.cmp.Compare(s.minKey, max) > 0 {
 		return false
 	}
-	if compare.Compare(s.maxKey, min, s.useLearnedIndex) < 0 {
+	if s.cmp.Compare(s.maxKey, min) < 0 {
 		return false
 	}
 	return true
 }
 
 func (s *SSTable) Iterator() (*SSTableIterator, error) {
-	_, err := s.file.Seek(0, 0)
+	return s.newIterator(newBlockReadOptions(nil)), nil
+}
+
+func (s *SSTable) newIterator(ro blockReadOptions) *SSTableIterator {
+	return &SSTableIterator{
+		sstable:  s,
+		blockIdx: -1,
+		ro:       ro,
+	}
+}
+
+// init 은 처음 이동할 때 index entry 를 가져온다
+func (it *SSTableIterator) init() error {
+	if it.entries != nil {
+		return nil
+	}
+	r, err := it.sstable.acquireReader()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	reader := bufio.NewReader(s.file)
-	indexOffset, err := s.indexOffset()
+	defer r.unref()
+	it.entries = (*r.index).Entries()
+	it.fileName = r.file.Name()
+	return nil
+}
+
+func (it *SSTableIterator) loadBlock(idx int) error {
+	it.blockIdx = idx
+	it.block = nil
+	if idx < 0 || idx >= len(it.entries) {
+		return nil
+	}
+	r, err := it.sstable.acquireReader()
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer r.unref()
+	offset, length := it.entries[idx].blockHandle()
+	block, err := r.openBlock(offset, length, it.ro)
+	if err != nil {
+		return err
+	}
+	it.block = block
+	return nil
+}
 
-	iter := &SSTableIterator{
-		sstable:    s,
-		reader:     reader,
-		entry:      &DataEntry{},
-		stopOffset: indexOffset,
+// settle 은 block 안에서 이동한 결과 ok 가 false 면 이웃 block 으로 옮긴다
+func (it *SSTableIterator) settle(ok, forward bool) (bool, error) {
+	for it.block != nil {
+		if ok {
+			key, seq, opType := encoder.ParseInternalKey(it.block.Key())
+			value := append([]byte(nil), it.block.Value()...)
+			it.entry = &DataEntry{key: key, value: value, opType: opType, seq: seq}
+			return true, nil
+		}
+		if err := it.block.Err(); err != nil {
+			offset, _ := it.entries[it.blockIdx].blockHandle()
+			return false, newCorruptionError(it.fileName, offset, "%v", err)
+		}
+		next := it.blockIdx - 1
+		if forward {
+			next = it.blockIdx + 1
+		}
+		if err := it.loadBlock(next); err != nil {
+			return false, err
+		}
+		if it.block != nil {
+			if forward {
+				ok = it.block.SeekToFirst()
+			} else {
+				ok = it.block.SeekToLast()
+			}
+		}
 	}
-	return iter, nil
+	it.entry = nil
+	return false, nil
 }
 
-func (it *SSTableIterator) Next() (bool, error) {
-	if it.curOffset >= it.stopOffset {
-		return false, nil
+func (it *SSTableIterator) SeekToFirst() (bool, error) {
+	if err := it.init(); err != nil {
+		return false, err
 	}
-	entry, offset, err := readEntry(it.reader)
-	if err != nil {
+	if err := it.loadBlock(0); err != nil {
+		return false, err
+	}
+	return it.settle(it.block != nil && it.block.SeekToFirst(), true)
+}
+
+func (it *SSTableIterator) SeekToLast() (bool, error) {
+	if err := it.init(); err != nil {
+		return false, err
+	}
+	if err := it.loadBlock(len(it.entries) - 1); err != nil {
+		return false, err
+	}
+	return it.settle(it.block != nil && it.block.SeekToLast(), false)
+}
+
+// Seek 는 user key 가 key 보다 크거나 같은 첫 번째 entry 로 이동한다
+func (it *SSTableIterator) Seek(key []byte) (bool, error) {
+	if err := it.init(); err != nil {
+		return false, err
+	}
+	lookupKey := encoder.MakeLookupKey(key, encoder.MaxSequenceNumber)
+	idx := sort.Search(len(it.entries), func(i int) bool {
+		return compare.CompareInternal(it.sstable.cmp, it.entries[i].key, lookupKey) >= 0
+	})
+	if err := it.loadBlock(idx); err != nil {
 		return false, err
 	}
-	it.entry = entry
-	it.curOffset += offset
+	return it.settle(it.block != nil && it.block.Seek(lookupKey), true)
+}
 
-	return true, nil
+func (it *SSTableIterator) Next() (bool, error) {
+	if it.blockIdx < 0 {
+		return it.SeekToFirst()
+	}
+	if it.entry == nil {
+		return false, nil
+	}
+	return it.settle(it.block.Next(), true)
 }
 
+func (it *SSTableIterator) Prev() (bool, error) {
+	if it.blockIdx < 0 {
+		return it.SeekToLast()
+	}
+	if it.entry == nil {
+		return false, nil
+	}
+	return it.settle(it.block.Prev(), false)
+}
+
+// Close 는 iterator 가 읽은 block 을 놓는다. SSTable 파일은 다른 reader 와 공유되므로 닫지 않는다
 func (it *SSTableIterator) Close() error {
-	return it.sstable.file.Close()
+	it.block = nil
+	it.entry = nil
+	return nil
+}
+
+func (it *SSTableIterator) prefixMayMatch(extractor PrefixExtractor, prefix []byte) bool {
+	return it.sstable.prefixMayMatch(extractor, prefix)
 }
 
 func (it *SSTableIterator) Key() []byte {
 	return it.entry.key
 }
 
+func (it *SSTableIterator) Seq() uint64 {
+	return it.entry.seq
+}
+
 func (it *SSTableIterator) Value() []byte {
 	return it.entry.value
 }
@@ -21,7 +21,7 @@ func TestSstable_Get(t *testing.T) {
 	}
 	f, err := os.OpenFile(fileName, os.O_RDONLY, 0644)
 
-	sst, err := NewSSTable(f, false)
+	sst, err := NewSSTable(f, nil)
 	assert.NoError(t, err)
 	value, err := sst.Get([]byte("testkey1"))
 	assert.NoError(t, err)
@@ -44,7 +44,7 @@ func TestSSTable_Iterator(t *testing.T) {
 	}
 	f, err := os.OpenFile(fileName, os.O_RDONLY, 0644)
 	keys := sortedKeys()
-	sst, err := NewSSTable(f, false)
+	sst, err := NewSSTable(f, nil)
 	assert.NoError(t, err)
 
 	iter, err := sst.Iterator()
@@ -68,7 +68,7 @@ func TestSSTableIsInKeyRange(t *testing.T) {
 		t.Fatal(err)
 	}
 	f, err := os.OpenFile(fileName, os.O_RDONLY, 0644)
-	sst, err := NewSSTable(f, false)
+	sst, err := NewSSTable(f, nil)
 	assert.NoError(t, err)
 	assert.True(t, sst.IsInKeyRange([]byte("testkey1"), []byte(fmt.Sprintf("testkey%d", N))))
 	assert.True(t, sst.IsInKeyRange([]byte("testkey0"), []byte(fmt.Sprintf("testkey9999%d", N+1))))
@@ -83,7 +83,7 @@ func TestSSTable_MinMaxKey(t *testing.T) {
 		t.Fatal(err)
 	}
 	f, err := os.OpenFile(fileName, os.O_RDONLY, 0644)
-	sst, err := NewSSTable(f, false)
+	sst, err := NewSSTable(f, nil)
 	assert.NoError(t, err)
 	assert.Equal(t, []byte("testkey1"), sst.minKey)
 	assert.Equal(t, []byte("testkey999"), sst.maxKey)
@@ -97,19 +97,24 @@ func TestSSTable_Contains(t *testing.T) {
 		t.Fatal(err)
 	}
 	f, err := os.OpenFile(fileName, os.O_RDONLY, 0644)
-	sst, err := NewSSTable(f, false)
+	sst, err := NewSSTable(f, nil)
 	assert.NoError(t, err)
 	for i := 1; i <= N; i++ {
 		assert.True(t, sst.Contains([]byte(fmt.Sprintf("testkey%d", i))))
 	}
+	// filter 는 key 개수에 맞춰 key 당 10 bit 로 만들어지므로 false positive 는 1% 정도 나온다
+	falsePositives := 0
 	for i := N + 1; i <= 2*N; i++ {
-		assert.False(t, sst.Contains([]byte(fmt.Sprintf("testkey%d", i))))
+		if sst.Contains([]byte(fmt.Sprintf("testkey%d", i))) {
+			falsePositives++
+		}
 	}
+	assert.Less(t, falsePositives, N*3/100)
 	os.Remove(f.Name())
 }
 
 func generateSSTable2() (string, error) {
-	memtable := NewMemtable(100000, false)
+	memtable := NewMemtable(100000, nil)
 	i := 0
 	for i < N {
 		i++
@@ -127,7 +132,7 @@ func generateSSTable2() (string, error) {
 	if err != nil {
 		return "", err
 	}
-	flusher := NewFlusher(memtable, f)
+	flusher := NewFlusher(memtable, f, nil)
 	err = flusher.Flush()
 	if err != nil {
 		return "", err
@@ -0,0 +1,115 @@
+package lsm
+
+import (
+	"container/list"
+	"os"
+	"sync"
+
+	"github.com/gptjddldi/lsm/db/cache"
+	"github.com/gptjddldi/lsm/db/storage"
+)
+
+// tableCache 는 최근에 읽은 SSTable 의 tableReader 를 최대 capacity 개까지 열어둔다.
+// 넘치면 가장 오래 쓰이지 않은 reader 를 내보내고, 내보낸 reader 는 읽고 있는 쪽이 놓을 때 닫힌다
+type tableCache struct {
+	mu       sync.Mutex
+	capacity int
+	lru      *list.List            // 앞쪽이 가장 최근에 쓰인 reader
+	readers  map[int]*list.Element // file number -> *tableReader
+
+	blockCache *cache.Cache
+	opts       *Options
+	indexStats indexStats // 이 cache 로 연 SSTable 의 index lookup 통계
+}
+
+func newTableCache(capacity int, blockCache *cache.Cache, opts *Options) *tableCache {
+	return &tableCache{
+		capacity:   capacity,
+		lru:        list.New(),
+		readers:    make(map[int]*list.Element),
+		blockCache: blockCache,
+		opts:       opts,
+	}
+}
+
+// find 는 참조를 올린 meta 의 reader 를 반환한다. 열려있지 않으면 파일을 연다.
+// 다 쓰고 나면 unref 를 호출해야 한다
+func (c *tableCache) find(meta *storage.FileMetadata) (*tableReader, error) {
+	c.mu.Lock()
+	if e, ok := c.readers[meta.FileNum()]; ok {
+		c.lru.MoveToFront(e)
+		r := e.Value.(*tableReader)
+		r.ref()
+		c.mu.Unlock()
+		return r, nil
+	}
+	c.mu.Unlock()
+
+	// index 와 bloom filter 를 읽는 동안 다른 SSTable 읽기를 막지 않도록 잠금 없이 연다
+	r, err := c.open(meta)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.readers[meta.FileNum()]; ok {
+		// 그 사이 다른 goroutine 이 먼저 열었다
+		r.unref()
+		c.lru.MoveToFront(e)
+		r = e.Value.(*tableReader)
+		r.ref()
+		return r, nil
+	}
+	c.readers[meta.FileNum()] = c.lru.PushFront(r)
+	r.ref()
+	for c.lru.Len() > c.capacity {
+		c.remove(c.lru.Back())
+	}
+	return r, nil
+}
+
+func (c *tableCache) open(meta *storage.FileMetadata) (*tableReader, error) {
+	file, err := os.Open(meta.Path())
+	if err != nil {
+		return nil, err
+	}
+	r, err := openTableReader(file, meta, c.blockCache, &c.indexStats, c.opts)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// remove 는 c.mu 를 잡은 상태에서 호출된다
+func (c *tableCache) remove(e *list.Element) {
+	r := e.Value.(*tableReader)
+	c.lru.Remove(e)
+	delete(c.readers, r.meta.FileNum())
+	r.unref()
+}
+
+// evict 는 지워지는 SSTable 의 reader 를 내보낸다
+func (c *tableCache) evict(fileNum int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.readers[fileNum]; ok {
+		c.remove(e)
+	}
+}
+
+// openFiles 는 table cache 가 열어둔 파일 수
+func (c *tableCache) openFiles() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+func (c *tableCache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+}
@@ -0,0 +1,88 @@
+package lsm
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gptjddldi/lsm/db/encoder"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTableCache_BoundsOpenFiles(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{MaxOpenFiles: 2, L0CompactionTrigger: 100}
+	db, err := Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for f := 0; f < 5; f++ {
+		for i := 0; i < 100; i++ {
+			assert.NoError(t, db.Insert([]byte(fmt.Sprintf("key%d-%03d", f, i)), []byte(fmt.Sprintf("value%d-%03d", f, i))))
+		}
+		flushMutable(t, db)
+	}
+	assert.LessOrEqual(t, db.tables.openFiles(), 2)
+	db.Close()
+
+	// 다시 열 때는 manifest 의 key 범위로 version 을 만들고 파일은 처음 읽을 때 연다
+	db, err = Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	v := db.currentVersion()
+	if assert.Len(t, v.levels[0].sstables, 5) {
+		sst := v.levels[0].sstables[0]
+		assert.Equal(t, []byte("key0-000"), sst.minKey)
+		assert.Equal(t, []byte("key0-099"), sst.maxKey)
+		assert.Greater(t, sst.size, int64(0))
+		assert.Equal(t, uint64(100), sst.largestSeq)
+	}
+	v.unref()
+	assert.Equal(t, 0, db.tables.openFiles())
+
+	for f := 0; f < 5; f++ {
+		val, err := db.Get([]byte(fmt.Sprintf("key%d-050", f)))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("value%d-050", f)), val)
+		assert.LessOrEqual(t, db.tables.openFiles(), 2)
+	}
+
+	it := db.NewIterator(nil)
+	n := 0
+	for ok := it.SeekToFirst(); ok; ok = it.Next() {
+		n++
+	}
+	assert.NoError(t, it.Close())
+	assert.Equal(t, 500, n)
+	assert.LessOrEqual(t, db.tables.openFiles(), 2)
+}
+
+func TestTableCache_EvictedReaderStaysOpenWhileInUse(t *testing.T) {
+	db, err := Open(t.TempDir(), &Options{MaxOpenFiles: 1, L0CompactionTrigger: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for f := 0; f < 2; f++ {
+		assert.NoError(t, db.Insert([]byte(fmt.Sprintf("key%d", f)), []byte("value")))
+		flushMutable(t, db)
+	}
+
+	v := db.currentVersion()
+	defer v.unref()
+	first, second := v.levels[0].sstables[0], v.levels[0].sstables[1]
+
+	r, err := first.acquireReader()
+	assert.NoError(t, err)
+	// second 를 열면서 first 의 reader 가 cache 에서 밀려나지만 아직 잡고 있으므로 닫히지 않는다
+	_, err = second.getAt([]byte("key1"), encoder.MaxSequenceNumber, newBlockReadOptions(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, db.tables.openFiles())
+
+	val, err := r.get(encoder.MakeLookupKey([]byte("key0"), encoder.MaxSequenceNumber), newBlockReadOptions(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value"), val.Value())
+	r.unref()
+	assert.Equal(t, int32(0), r.refs.Load())
+}
@@ -4,56 +4,78 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"math"
 	"os"
 
-	"github.com/gptjddldi/lsm/db/encoder"
+	"github.com/gptjddldi/lsm/db/compare"
+	"github.com/gptjddldi/lsm/db/compression"
 )
 
-const (
-	maxBlockSize = 4 << 10
-)
-
-var BlockThreshold = int(math.Floor(maxBlockSize * 0.9))
+// ErrTableTooLarge 는 SSTable 의 data block 이 index 가 가리킬 수 있는 4GB 를 넘을 때 반환된다
+var ErrTableTooLarge = errors.New("sstable too large")
 
 type TempWriter struct {
-	bw *bufio.Writer
+	bw              *bufio.Writer
+	blockThreshold  int
+	restartInterval int
+	formatVersion   uint32
+	compression     compression.Type
+	bloomBitsPerKey int
+	prefixExtractor PrefixExtractor
+	comparator      compare.Comparator
 
-	indexLength  int
-	curOffset    int
-	nextOffset   uint32
-	writtenBytes int
+	useLearnedIndex   bool
+	learnedIndexModel LearnedIndexModel
 
-	dataBlockBuf *bytes.Buffer
-	indexBuf     *bytes.Buffer
-	BloomFilter  *BloomFilter
-	lastKey      []byte
+	curOffset int
 
-	footerBuf *bytes.Buffer
-	offsets   []uint32
+	dataBlock   *blockBuilder
+	indexBuf    *bytes.Buffer
+	BloomFilter *BloomFilter
+	lastEntry   *DataEntry
+	props       tableProperties
 }
 
-func NewTempWriter(file *os.File) *TempWriter {
+// NewTempWriter 는 opts 의 block 크기, bloom filter, level 의 압축 설정으로 SSTable 을 쓴다. opts 가 nil 이면 DefaultOptions
+func NewTempWriter(file *os.File, opts *Options, level int) *TempWriter {
+	if opts == nil {
+		opts = DefaultOptions()
+	}
 	return &TempWriter{
-		dataBlockBuf: bytes.NewBuffer(make([]byte, 0, maxBlockSize)),
-		bw:           bufio.NewWriter(file),
-		indexBuf:     bytes.NewBuffer(make([]byte, 0, maxBlockSize)),
-		BloomFilter:  NewBloomFilter(),
-		footerBuf:    bytes.NewBuffer(make([]byte, 0, 8)),
+		bw:                bufio.NewWriter(file),
+		blockThreshold:    opts.blockThreshold(),
+		restartInterval:   opts.BlockRestartInterval,
+		formatVersion:     opts.formatVersion(),
+		compression:       opts.compression(level),
+		bloomBitsPerKey:   opts.bloomBitsPerKey(level),
+		prefixExtractor:   opts.PrefixExtractor,
+		comparator:        opts.comparator(),
+		useLearnedIndex:   opts.UseLearnedIndex,
+		learnedIndexModel: opts.LearnedIndexModel,
+		indexBuf:          bytes.NewBuffer(make([]byte, 0, opts.BlockSize)),
 	}
 }
 
 // compaction / flush 시 호출
 func (tw *TempWriter) Write(entries []*DataEntry) error {
+	// formatVersion 은 생성 후 테스트에서 바꿀 수 있으므로 여기서 block layout 을 정한다
+	tw.dataBlock = newBlockBuilder(blockLayoutOf(tw.formatVersion), tw.restartInterval)
+	tw.BloomFilter = NewBloomFilter(tw.countFilterKeys(entries), tw.bloomBitsPerKey)
+	var lastPrefix []byte
 	for _, entry := range entries {
 		tw.BloomFilter.Add(entry.key)
-		n, err := tw.dataBlockBuf.Write(entry.toBytes())
-		if err != nil {
-			return err
+		if tw.prefixExtractor != nil && tw.prefixExtractor.InDomain(entry.key) {
+			if prefix := tw.prefixExtractor.Transform(entry.key); lastPrefix == nil || !bytes.Equal(prefix, lastPrefix) {
+				tw.BloomFilter.Add(prefix)
+				lastPrefix = prefix
+			}
 		}
-		tw.writtenBytes += n
-		tw.lastKey = entry.key
-		if tw.writtenBytes > BlockThreshold {
+		tw.props.add(entry)
+		tw.dataBlock.add(entry.internalKey(), entry.value)
+		tw.lastEntry = entry
+		if tw.dataBlock.estimatedSize() > tw.blockThreshold {
 			err := tw.flushDataBlock()
 			if err != nil {
 				return err
@@ -65,7 +87,8 @@ func (tw *TempWriter) Write(entries []*DataEntry) error {
 		return err
 	}
 
-	indexLen, err := tw.bw.ReadFrom(tw.indexBuf)
+	f := &footer{version: tw.formatVersion}
+	f.index, err = tw.writeBlock(tw.indexBuf.Bytes())
 	if err != nil {
 		return err
 	}
@@ -75,73 +98,189 @@ func (tw *TempWriter) Write(entries []*DataEntry) error {
 	if err != nil {
 		return err
 	}
-	bloomFilterLen, err := tw.bw.ReadFrom(bloomFilterBuf)
+	f.filter, err = tw.writeBlock(bloomFilterBuf.Bytes())
 	if err != nil {
 		return err
 	}
+	tw.props.filterSize = f.filter.length
+	tw.props.filterBits = uint64(tw.bloomBitsPerKey)
+
+	if err = tw.writeFooter(f); err != nil {
+		return err
+	}
+	return tw.bw.Flush()
+}
 
-	footer := tw.buildFooterBlock(indexLen, bloomFilterLen)
-	tw.footerBuf.Write(footer)
-	_, err = tw.bw.ReadFrom(tw.footerBuf)
+// countFilterKeys 는 bloom filter 에 들어갈 서로 다른 user key 와 prefix 의 개수. entries 는 정렬되어 있다
+func (tw *TempWriter) countFilterKeys(entries []*DataEntry) uint {
+	var n uint
+	var lastPrefix []byte
+	for i, entry := range entries {
+		if i > 0 && bytes.Equal(entries[i-1].key, entry.key) {
+			continue
+		}
+		n++
+		if tw.prefixExtractor != nil && tw.prefixExtractor.InDomain(entry.key) {
+			if prefix := tw.prefixExtractor.Transform(entry.key); lastPrefix == nil || !bytes.Equal(prefix, lastPrefix) {
+				n++
+				lastPrefix = prefix
+			}
+		}
+	}
+	return n
+}
+
+// writeFooter 는 version 1 이면 properties, metaindex block 을 쓰고 footer 를 쓴다
+func (tw *TempWriter) writeFooter(f *footer) error {
+	if f.version == formatVersion0 {
+		buf := make([]byte, footerSizeV0)
+		binary.LittleEndian.PutUint64(buf[:8], f.index.length)
+		binary.LittleEndian.PutUint64(buf[8:], f.filter.length)
+		_, err := tw.bw.Write(buf)
+		return err
+	}
+
+	var err error
+	f.properties, err = tw.writeBlock(tw.props.encode())
 	if err != nil {
 		return err
 	}
+	handles := map[string]blockHandle{
+		metaFilterName:     f.filter,
+		metaPropertiesName: f.properties,
+	}
+	learned, err := tw.learnedIndexBlock()
+	if err != nil {
+		return err
+	}
+	tw.props.indexType = indexTypeBinarySearch
+	if learned != nil {
+		if handles[metaLearnedIndexName], err = tw.writeBlock(learned); err != nil {
+			return err
+		}
+		tw.props.indexType = tw.learnedIndexModel.String()
+	}
+	tw.props.comparator = tw.comparator.Name()
+	if tw.prefixExtractor != nil {
+		tw.props.prefixExtractor = tw.prefixExtractor.Name()
+	}
+	if strings := tw.props.encodeStrings(); len(strings) > 0 {
+		if handles[metaStringPropertiesName], err = tw.writeBlock(strings); err != nil {
+			return err
+		}
+	}
+	f.metaindex, err = tw.writeBlock(encodeMetaIndex(handles))
+	if err != nil {
+		return err
+	}
+	_, err = tw.bw.Write(f.encode())
+	return err
+}
 
-	return nil
+// writeBlock 은 block 내용 뒤에 checksum 을 붙여 쓰고, checksum 을 뺀 block 의 handle 을 반환한다
+func (tw *TempWriter) writeBlock(contents []byte) (blockHandle, error) {
+	if _, err := tw.bw.Write(contents); err != nil {
+		return blockHandle{}, err
+	}
+	var trailer [blockTrailerSize]byte
+	binary.LittleEndian.PutUint32(trailer[:], blockChecksum(contents))
+	if _, err := tw.bw.Write(trailer[:]); err != nil {
+		return blockHandle{}, err
+	}
+	h := blockHandle{offset: uint64(tw.curOffset), length: uint64(len(contents))}
+	tw.curOffset += len(contents) + blockTrailerSize
+	return h, nil
+}
+
+// writeDataBlock 은 format version 3 이상이면 block 을 압축하고 compression type 을 붙여 쓴다.
+// 압축해도 1/8 이상 줄지 않으면 압축하지 않은 내용을 쓴다
+func (tw *TempWriter) writeDataBlock(raw []byte) (blockHandle, error) {
+	if tw.formatVersion < formatVersion3 {
+		return tw.writeBlock(raw)
+	}
+	contents, typ := raw, compression.None
+	if codec, _ := compression.Lookup(tw.compression); codec != nil {
+		compressed, err := codec.Compress(make([]byte, 0, len(raw)), raw)
+		if err != nil {
+			return blockHandle{}, err
+		}
+		if len(compressed) < len(raw)-len(raw)/8 {
+			contents, typ = compressed, tw.compression
+		}
+	}
+	h, err := tw.writeBlock(append(contents, byte(typ)))
+	if err != nil {
+		return blockHandle{}, err
+	}
+	// handle 의 length 에는 compression type 을 넣지 않는다
+	h.length--
+	return h, nil
 }
 
 func (tw *TempWriter) flushDataBlock() error {
-	if tw.writtenBytes == 0 {
+	if tw.dataBlock.empty() {
 		return nil
 	}
-	n, err := tw.bw.ReadFrom(tw.dataBlockBuf)
+	block := tw.dataBlock.finish()
+	// index entry 의 handle 은 uint32 라서 그 너머에 쓴 block 은 가리킬 수 없다.
+	// compression type 을 붙여도 block 은 1 byte 만 길어진다
+	if end := uint64(tw.curOffset) + uint64(len(block)) + 1 + blockTrailerSize; end > math.MaxUint32 {
+		return fmt.Errorf("%w: data block ends at offset %d", ErrTableTooLarge, end)
+	}
+	h, err := tw.writeDataBlock(block)
 	if err != nil {
 		return err
 	}
+	tw.dataBlock.reset()
+	tw.props.numDataBlocks++
 
-	entry := tw.buildIndexEntry()
-	_, err = tw.indexBuf.Write(entry)
+	_, err = tw.indexBuf.Write(tw.buildIndexEntry(h))
 	if err != nil {
 		return err
 	}
-	tw.writtenBytes = 0
-	tw.curOffset += int(n)
 	return nil
 }
 
-func (tw *TempWriter) buildIndexEntry() []byte {
+func (tw *TempWriter) buildIndexEntry(h blockHandle) []byte {
 	buf := make([]byte, 8)
-	binary.LittleEndian.PutUint32(buf[:4], uint32(tw.curOffset))
-	binary.LittleEndian.PutUint32(buf[4:], uint32(tw.writtenBytes))
+	binary.LittleEndian.PutUint32(buf[:4], uint32(h.offset))
+	binary.LittleEndian.PutUint32(buf[4:], uint32(h.length))
+	// index entry 의 key 는 block 마지막 entry 의 internal key
 	entry := &DataEntry{
-		key:    tw.lastKey,
+		key:    tw.lastEntry.key,
 		value:  buf,
-		opType: encoder.OpTypeSet,
+		opType: tw.lastEntry.opType,
+		seq:    tw.lastEntry.seq,
 	}
 	return entry.toBytes()
 }
 
-// footer block 에 넣어야 하는 건 index block 의 길이와 bloom filter 의 길이
-func (tw *TempWriter) buildFooterBlock(indexLen, bloomLen int64) []byte {
-	buf := make([]byte, 16)
-	binary.LittleEndian.PutUint64(buf[:8], uint64(indexLen))
-	binary.LittleEndian.PutUint64(buf[8:], uint64(bloomLen))
-	return buf
-}
-
-// { key length, value length, key, (opKind, value) }
+// { internal key length, value length, internal key, value }
 func (de *DataEntry) toBytes() []byte {
-	key, val, opType := de.key, de.value, de.opType
+	key, val := de.internalKey(), de.value
 
-	keyLen, valLen := len(key), len(val)+1
+	keyLen, valLen := len(key), len(val)
 	needed := 2*binary.MaxVarintLen64 + keyLen + valLen
 
 	buf := make([]byte, needed)
 	n := binary.PutUvarint(buf, uint64(keyLen))
 	n += binary.PutUvarint(buf[n:], uint64(valLen))
 	copy(buf[n:], key)
-	copy(buf[n+keyLen:], encoder.Encode(opType, val))
+	copy(buf[n+keyLen:], val)
 	used := n + keyLen + valLen
 
 	return buf[:used]
 }
+
+// learnedIndexBlock 은 index block 으로 학습한 모델의 meta block 내용을 반환한다.
+// 이 파일의 key 로 잰 모델의 오차가 커서 binary search 보다 나을 것이 없거나 learned index 를 쓰지 않으면 nil 을 반환한다
+func (tw *TempWriter) learnedIndexBlock() ([]byte, error) {
+	if !tw.useLearnedIndex {
+		return nil, nil
+	}
+	idx := trainLearnedIndex(parseIndexEntries(tw.indexBuf.Bytes()), tw.comparator, tw.learnedIndexModel)
+	if idx == nil {
+		return nil, nil
+	}
+	return idx.encode()
+}
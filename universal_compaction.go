@@ -0,0 +1,167 @@
+package lsm
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// CompactionStyle 은 SSTable 을 합치는 방식. 이미 있는 DB 도 다른 방식으로 다시 열 수 있다
+type CompactionStyle int
+
+const (
+	// LeveledCompaction 은 level 마다 목표 크기를 두고 SSTable 을 하나씩 아래 level 로 내린다
+	LeveledCompaction CompactionStyle = iota
+
+	// UniversalCompaction 은 크기가 비슷한 sorted run 을 한 번에 합친다.
+	// 같은 데이터를 다시 쓰는 횟수가 적은 대신 공간을 더 쓰고 읽을 run 이 많다
+	UniversalCompaction
+)
+
+func (s CompactionStyle) String() string {
+	switch s {
+	case LeveledCompaction:
+		return "leveled"
+	case UniversalCompaction:
+		return "universal"
+	default:
+		return fmt.Sprintf("CompactionStyle(%d)", int(s))
+	}
+}
+
+func (s CompactionStyle) valid() bool {
+	return s == LeveledCompaction || s == UniversalCompaction
+}
+
+// UniversalCompactionOptions 는 UniversalCompaction 의 설정. sorted run 이 L0CompactionTrigger 개 이상이면 compaction 한다.
+// Options 와 같이 0 인 필드는 기본값을 쓴다
+type UniversalCompactionOptions struct {
+	// 다음 (더 오래된) run 의 크기가 이제까지 고른 run 크기 합의 (100 + SizeRatio)% 이하면 함께 합친다. 1 이상, 기본값 1
+	SizeRatio int
+
+	// 크기 비율로 고를 때 한 번에 합치는 run 개수의 최소값. 2 이상, 기본값 2
+	MinMergeWidth int
+
+	// 한 번에 합치는 run 개수의 최대값. 기본값은 제한 없음
+	MaxMergeWidth int
+
+	// 가장 오래된 run 을 뺀 나머지 run 크기의 합이 가장 오래된 run 크기의 이 % 이상이면 모든 run 을 합친다. 1 이상, 기본값 200
+	MaxSizeAmplificationPercent int
+}
+
+func DefaultUniversalCompactionOptions() UniversalCompactionOptions {
+	return UniversalCompactionOptions{
+		SizeRatio:                   1,
+		MinMergeWidth:               2,
+		MaxMergeWidth:               math.MaxInt32,
+		MaxSizeAmplificationPercent: 200,
+	}
+}
+
+// sortedRun 은 key 범위가 겹치지 않는 SSTable 들. L0 는 SSTable 하나가, 나머지 level 은 level 전체가 run 하나다
+type sortedRun struct {
+	level    int
+	sstables []*SSTable
+	size     int
+}
+
+// sortedRuns 는 version 의 sorted run 을 최근 것부터 반환한다
+func (v *version) sortedRuns() []sortedRun {
+	l0 := append([]*SSTable(nil), v.levels[0].sstables...)
+	// L0 는 sequence 범위가 겹치지 않으므로 가장 큰 sequence 로 순서를 정한다.
+	// sequence 가 기록되지 않은 format version 0 파일은 file number 를 쓴다
+	sort.Slice(l0, func(i, j int) bool {
+		if l0[i].largestSeq != l0[j].largestSeq {
+			return l0[i].largestSeq > l0[j].largestSeq
+		}
+		return l0[i].meta.FileNum() > l0[j].meta.FileNum()
+	})
+	runs := make([]sortedRun, 0, len(l0)+len(v.levels)-1)
+	for _, sst := range l0 {
+		runs = append(runs, sortedRun{level: 0, sstables: []*SSTable{sst}, size: int(sst.size)})
+	}
+	for level := 1; level < len(v.levels); level++ {
+		if l := v.levels[level]; len(l.sstables) > 0 {
+			runs = append(runs, sortedRun{level: level, sstables: l.sstables, size: l.TotalSize()})
+		}
+	}
+	return runs
+}
+
+// pickUniversalCompaction 은 compactionMu 를 잡은 상태에서 호출된다.
+// 최근 run 부터 이어지는 run 들을 공간 증폭, 크기 비율, run 개수 순서로 확인해서 고른다.
+// 한 번에 하나의 universal compaction 만 한다
+func (db *DB) pickUniversalCompaction(v *version) *compaction {
+	runs := v.sortedRuns()
+	if len(runs) == 0 || len(runs) < db.opts.L0CompactionTrigger {
+		return nil
+	}
+	for _, r := range runs {
+		if db.anyCompacting(r.sstables) {
+			return nil
+		}
+	}
+
+	start, end := pickUniversalRuns(runs, db.opts.L0CompactionTrigger, &db.opts.Universal)
+	if end-start < 2 {
+		return nil
+	}
+	c := &compaction{outputLevel: universalOutputLevel(runs, end, db.opts.MaxLevels)}
+	for _, r := range runs[start:end] {
+		c.inputs = append(c.inputs, compactionInput{r.level, r.sstables})
+	}
+	return c
+}
+
+// pickUniversalRuns 는 합칠 run 의 범위 [start, end) 를 고른다. 고를 run 이 없으면 end-start 가 2 보다 작다
+func pickUniversalRuns(runs []sortedRun, trigger int, opts *UniversalCompactionOptions) (start, end int) {
+	n := len(runs)
+	if n < 2 {
+		return 0, 0
+	}
+
+	// 가장 오래된 run 에 비해 나머지가 너무 크면 전부 합쳐서 공간을 되찾는다
+	newer := 0
+	for _, r := range runs[:n-1] {
+		newer += r.size
+	}
+	if newer*100 >= runs[n-1].size*opts.MaxSizeAmplificationPercent {
+		return 0, n
+	}
+
+	// 이제까지 고른 run 보다 크게 크지 않은 다음 run 을 이어 붙인다
+	for start = 0; start < n; start++ {
+		sum := runs[start].size
+		for end = start + 1; end < n && end-start < opts.MaxMergeWidth; end++ {
+			if runs[end].size*100 > sum*(100+opts.SizeRatio) {
+				break
+			}
+			sum += runs[end].size
+		}
+		if end-start >= opts.MinMergeWidth {
+			return start, end
+		}
+	}
+
+	// 크기가 비슷한 run 이 없어도 run 이 trigger 보다 적어지도록 최근 run 들을 합친다
+	width := n - trigger + 2
+	if width > opts.MaxMergeWidth {
+		width = opts.MaxMergeWidth
+	}
+	if width > n {
+		width = n
+	}
+	return 0, width
+}
+
+// universalOutputLevel 은 runs[:end] 를 합친 run 을 둘 level.
+// 더 오래된 run 바로 위의 level 에 두어 level 순서와 run 의 순서가 어긋나지 않게 한다
+func universalOutputLevel(runs []sortedRun, end, maxLevels int) int {
+	if end == len(runs) {
+		return maxLevels - 1
+	}
+	if next := runs[end].level; next > 0 {
+		return next - 1
+	}
+	return 0
+}
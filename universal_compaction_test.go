@@ -0,0 +1,195 @@
+package lsm
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testRuns(sizes ...int) []sortedRun {
+	runs := make([]sortedRun, len(sizes))
+	for i, size := range sizes {
+		runs[i] = sortedRun{size: size}
+	}
+	return runs
+}
+
+func TestPickUniversalRuns(t *testing.T) {
+	opts := DefaultUniversalCompactionOptions()
+	for _, tc := range []struct {
+		name       string
+		sizes      []int
+		opts       func(o *UniversalCompactionOptions)
+		start, end int
+	}{
+		{"space amplification", []int{10, 10, 10}, nil, 0, 3},
+		{"similar sizes", []int{10, 10, 10, 100}, nil, 0, 3},
+		{"size ratio skips a small newest run", []int{10, 50, 50, 50, 1000}, nil, 1, 4},
+		{"wider size ratio", []int{10, 10, 25, 1000}, func(o *UniversalCompactionOptions) { o.SizeRatio = 30 }, 0, 3},
+		{"max merge width", []int{10, 10, 10, 10, 1000}, func(o *UniversalCompactionOptions) { o.MaxMergeWidth = 2 }, 0, 2},
+		{"min merge width", []int{10, 10, 100, 1000, 10000}, func(o *UniversalCompactionOptions) { o.MinMergeWidth = 3 }, 0, 3},
+		{"reduce sorted runs", []int{1, 10, 100, 1000, 10000}, nil, 0, 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			o := opts
+			if tc.opts != nil {
+				tc.opts(&o)
+			}
+			start, end := pickUniversalRuns(testRuns(tc.sizes...), 4, &o)
+			assert.Equal(t, tc.start, start)
+			assert.Equal(t, tc.end, end)
+		})
+	}
+}
+
+func TestUniversalOutputLevel(t *testing.T) {
+	runs := []sortedRun{{level: 0}, {level: 0}, {level: 3}, {level: 6}}
+	assert.Equal(t, 0, universalOutputLevel(runs, 1, 7))
+	assert.Equal(t, 2, universalOutputLevel(runs, 2, 7))
+	assert.Equal(t, 5, universalOutputLevel(runs, 3, 7))
+	assert.Equal(t, 6, universalOutputLevel(runs, 4, 7))
+}
+
+// checkSortedRuns 는 L0 를 뺀 level 의 SSTable 이 key 범위가 겹치지 않는지 확인한다
+func checkSortedRuns(t *testing.T, db *DB) {
+	v := db.currentVersion()
+	defer v.unref()
+	for level := 1; level < len(v.levels); level++ {
+		sstables := v.levels[level].sstables
+		for i, a := range sstables {
+			for _, b := range sstables[i+1:] {
+				assert.False(t, a.IsInKeyRange(b.minKey, b.maxKey), "level %d has overlapping SSTables", level)
+			}
+		}
+	}
+}
+
+func TestDB_UniversalCompaction(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{
+		MemtableSize:        4 << 10,
+		MaxLevels:           4,
+		L0CompactionTrigger: 3,
+		CompactionStyle:     UniversalCompaction,
+	}
+	db, err := Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 500
+	for round := 0; round < 4; round++ {
+		for i := 0; i < n; i++ {
+			assert.NoError(t, db.Insert([]byte(fmt.Sprintf("key%04d", i)), []byte(fmt.Sprintf("value%d-%04d", round, i))))
+		}
+		for i := round; i < n; i += 10 {
+			assert.NoError(t, db.Delete([]byte(fmt.Sprintf("key%04d", i))))
+		}
+	}
+	db.Close()
+
+	check := func(db *DB) {
+		for i := 0; i < n; i++ {
+			val, err := db.Get([]byte(fmt.Sprintf("key%04d", i)))
+			if i%10 == 3 {
+				assert.ErrorIs(t, err, ErrorKeyNotFound)
+				continue
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, []byte(fmt.Sprintf("value3-%04d", i)), val)
+		}
+		checkSortedRuns(t, db)
+	}
+
+	db, err = Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Close 가 마지막 flush 뒤의 compaction 을 기다리지는 않으므로 남은 compaction 을 마저 한다
+	for db.pickCompactionLevel() == 0 {
+		assert.NoError(t, db.compactLevel(0))
+	}
+	v := db.currentVersion()
+	assert.Less(t, len(v.sortedRuns()), opts.L0CompactionTrigger)
+	assert.NotEmpty(t, v.levels[opts.MaxLevels-1].sstables)
+	v.unref()
+	check(db)
+	db.Close()
+
+	// 같은 DB 를 leveled compaction 으로 다시 열어도 읽고 쓸 수 있다
+	leveled := *opts
+	leveled.CompactionStyle = LeveledCompaction
+	db, err = Open(dir, &leveled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(db)
+	for i := 0; i < n; i++ {
+		assert.NoError(t, db.Insert([]byte(fmt.Sprintf("key%04d", i)), []byte(fmt.Sprintf("value3-%04d", i))))
+	}
+	for i := 3; i < n; i += 10 {
+		assert.NoError(t, db.Delete([]byte(fmt.Sprintf("key%04d", i))))
+	}
+	db.Close()
+
+	db, err = Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	check(db)
+}
+
+func TestDB_UniversalCompactionKeepsNewerL0Runs(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{MaxLevels: 3, L0CompactionTrigger: 100, CompactionStyle: UniversalCompaction}
+	db, err := Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 가장 오래된 큰 run, 비슷한 크기의 run 셋, 가장 최근의 작은 run
+	for i := 0; i < 1000; i++ {
+		assert.NoError(t, db.Insert([]byte(fmt.Sprintf("old%04d", i)), []byte("value")))
+	}
+	assert.NoError(t, db.Insert([]byte("deleted"), []byte("value")))
+	flushMutable(t, db)
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 100; i++ {
+			assert.NoError(t, db.Insert([]byte(fmt.Sprintf("mid%04d", i)), []byte(fmt.Sprintf("value%d", round))))
+		}
+		assert.NoError(t, db.Delete([]byte("deleted")))
+		flushMutable(t, db)
+	}
+	assert.NoError(t, db.Insert([]byte("mid0000"), []byte("newest")))
+	flushMutable(t, db)
+	db.Close()
+
+	opts.L0CompactionTrigger = 5
+	db, err = Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	assert.NoError(t, db.compactLevel(0))
+
+	v := db.currentVersion()
+	runs := v.sortedRuns()
+	if assert.Len(t, runs, 3) {
+		for _, r := range runs {
+			assert.Equal(t, 0, r.level)
+		}
+	}
+	// 더 오래된 L0 run 에 같은 key 가 남아 있으므로 tombstone 을 지우지 않는다
+	assert.Equal(t, 2, countEntries(t, v, []byte("deleted")))
+	v.unref()
+
+	_, err = db.Get([]byte("deleted"))
+	assert.ErrorIs(t, err, ErrorKeyNotFound)
+	val, err := db.Get([]byte("mid0000"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("newest"), val)
+	val, err = db.Get([]byte("mid0001"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value2"), val)
+}
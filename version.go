@@ -0,0 +1,139 @@
+package lsm
+
+import (
+	"log"
+	"os"
+	"sync/atomic"
+)
+
+// version 은 특정 시점의 level 별 SSTable 목록이다. 한 번 설치된 version 은
+// 바뀌지 않고, flush / compaction 은 edit 를 적용한 새 version 을 설치한다.
+//
+// version 은 참조 카운트를 가진다. DB 가 현재 version 에 대해 하나를 갖고,
+// 읽기 작업은 끝날 때까지 하나를 더 잡는다. version 이 잡고 있는 SSTable 은
+// 더 이상 어떤 version 에도 속하지 않게 된 뒤에야 파일이 지워진다.
+type version struct {
+	levels []*level
+	refs   int32
+
+	// level 별 compact cursor. nil 이면 level 의 처음부터 고른다
+	compactCursors [][]byte
+}
+
+func newVersion(levels []*level) *version {
+	v := &version{levels: levels, refs: 1, compactCursors: make([][]byte, len(levels))}
+	for _, l := range levels {
+		for _, sst := range l.sstables {
+			sst.ref()
+		}
+	}
+	return v
+}
+
+func emptyLevels(n int) []*level {
+	levels := make([]*level, n)
+	for i := range levels {
+		levels[i] = &level{
+			sstables: make([]*SSTable, 0),
+		}
+	}
+	return levels
+}
+
+func (v *version) ref() {
+	atomic.AddInt32(&v.refs, 1)
+}
+
+func (v *version) unref() {
+	if atomic.AddInt32(&v.refs, -1) > 0 {
+		return
+	}
+	for _, l := range v.levels {
+		for _, sst := range l.sstables {
+			sst.unref()
+		}
+	}
+}
+
+// apply 는 edit 를 반영한 새 version 을 만든다. v 는 바뀌지 않는다
+func (v *version) apply(edit *versionEdit) *version {
+	deleted := make(map[*SSTable]bool, len(edit.deletedTables))
+	for _, sst := range edit.deletedTables {
+		deleted[sst] = true
+	}
+
+	levels := make([]*level, len(v.levels))
+	for i, l := range v.levels {
+		sstables := make([]*SSTable, 0, len(l.sstables))
+		for _, sst := range l.sstables {
+			if !deleted[sst] {
+				sstables = append(sstables, sst)
+			}
+		}
+		levels[i] = &level{sstables: sstables}
+	}
+	for i, sst := range edit.addedTables {
+		l := levels[edit.addedFiles[i].level]
+		l.sstables = append(l.sstables, sst)
+	}
+	nv := newVersion(levels)
+	copy(nv.compactCursors, v.compactCursors)
+	for _, c := range edit.compactCursors {
+		nv.compactCursors[c.level] = c.key
+	}
+	return nv
+}
+
+// isBaseLevelForKey 는 level 보다 아래 level 에 key 를 포함할 수 있는 SSTable 이 없는지 확인한다.
+// L0 로 쓰는 compaction 은 input 이 아닌 더 오래된 L0 SSTable 이 남아있을 수 있으므로 false 를 반환한다
+func (v *version) isBaseLevelForKey(level int, key []byte) bool {
+	if level == 0 {
+		return false
+	}
+	for l := level + 1; l < len(v.levels); l++ {
+		for _, sst := range v.levels[l].sstables {
+			if sst.IsInKeyRange(key, key) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// currentVersion 은 참조 카운트를 올린 현재 version 을 반환한다.
+// 다 쓰고 나면 unref 를 호출해야 한다
+func (db *DB) currentVersion() *version {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	db.current.ref()
+	return db.current
+}
+
+// installVersion 은 db.mu 를 잡은 상태에서 호출된다
+func (db *DB) installVersion(v *version) {
+	old := db.current
+	db.current = v
+	if old != nil {
+		old.unref()
+	}
+}
+
+func (s *SSTable) ref() {
+	atomic.AddInt32(&s.refs, 1)
+}
+
+// unref 는 마지막 참조가 사라진 obsolete SSTable 의 reader 를 내보내고 파일을 지운다
+func (s *SSTable) unref() {
+	if atomic.AddInt32(&s.refs, -1) > 0 || !s.obsolete.Load() {
+		return
+	}
+	if s.tables == nil {
+		s.reader.unref()
+		return
+	}
+	s.tables.evict(s.meta.FileNum())
+	if err := os.Remove(s.meta.Path()); err != nil {
+		log.Printf("Error deleting file: %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package lsm
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/gptjddldi/lsm/db/storage"
+	"github.com/gptjddldi/lsm/db/wal"
+)
+
+type WALOptions struct {
+	SyncMode     wal.SyncMode
+	SyncInterval time.Duration // wal.SyncInterval 일 때만 쓴다
+}
+
+func DefaultWALOptions() WALOptions {
+	return WALOptions{
+		SyncMode:     wal.SyncInterval,
+		SyncInterval: 100 * time.Millisecond,
+	}
+}
+
+// newMemtable 는 새 WAL segment 와 짝지어진 memtable 을 만든다
+func (db *DB) newMemtable() (*Memtable, error) {
+	meta := db.dataStorage.PrepareNewWALFile()
+	f, err := db.dataStorage.OpenFileForWriting(meta)
+	if err != nil {
+		return nil, err
+	}
+	m := NewMemtable(db.opts.MemtableSize, db.opts.Comparator)
+	m.walFileNum = meta.FileNum()
+	m.wal = wal.NewWriter(f, db.opts.WAL.SyncMode, db.opts.WAL.SyncInterval)
+	return m, nil
+}
+
+// removeWAL 은 memtable 이 L0 SSTable 로 내려간 뒤 호출된다
+func (db *DB) removeWAL(m *Memtable) error {
+	if m.wal == nil {
+		return nil
+	}
+	if err := m.wal.Close(); err != nil {
+		return err
+	}
+	return os.Remove(m.wal.Name())
+}
+
+// recoverWALs 는 남아있는 WAL segment 를 순서대로 memtable 로 재생하고
+// L0 SSTable 로 flush 한 뒤 segment 를 지운다
+func (db *DB) recoverWALs() error {
+	files, err := db.dataStorage.ListFiles()
+	if err != nil {
+		return err
+	}
+	logs := make([]*storage.FileMetadata, 0)
+	for _, f := range files {
+		if f.IsWAL() {
+			logs = append(logs, f)
+		}
+	}
+	sort.Slice(logs, func(i, j int) bool {
+		return logs[i].FileNum() < logs[j].FileNum()
+	})
+
+	for _, f := range logs {
+		m, err := db.replayWAL(f.Path())
+		if err != nil {
+			return err
+		}
+		m.walFileNum = f.FileNum()
+		if m.Size() > 0 {
+			if err := db.flushMemtable(m); err != nil {
+				return err
+			}
+		}
+		if err := os.Remove(f.Path()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *DB) replayWAL(path string) (*Memtable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader, err := wal.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+
+	m := NewMemtable(db.opts.MemtableSize, db.opts.Comparator)
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("replaying %s: %w", path, err)
+		}
+		// WAL 의 record 하나가 WriteBatch 하나
+		batch, err := newWriteBatchFromRepr(record)
+		if err != nil {
+			return nil, fmt.Errorf("replaying %s: %w", path, err)
+		}
+		if err := batch.insertInto(m); err != nil {
+			return nil, fmt.Errorf("replaying %s: %w", path, err)
+		}
+		if last := batch.sequence() + uint64(batch.Count()) - 1; last > db.lastSeq.Load() {
+			db.lastSeq.Store(last)
+		}
+	}
+	return m, nil
+}
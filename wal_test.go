@@ -0,0 +1,79 @@
+package lsm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gptjddldi/lsm/db/wal"
+	"github.com/stretchr/testify/assert"
+)
+
+// crash 는 memtable 을 flush 하지 않고 백그라운드 goroutine 만 멈춘다
+func crash(db *DB) {
+	db.cancel()
+	db.wg.Wait()
+}
+
+func TestDB_RecoverFromWAL(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, &Options{WAL: WALOptions{SyncMode: wal.SyncEveryWrite}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, db.Insert([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprintf("value%03d", i))))
+	}
+	assert.NoError(t, db.Delete([]byte("key050")))
+	crash(db)
+
+	db, err = Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 100; i++ {
+		val, err := db.Get([]byte(fmt.Sprintf("key%03d", i)))
+		if i == 50 {
+			assert.ErrorIs(t, err, ErrorKeyNotFound)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("value%03d", i)), val)
+	}
+}
+
+func TestDB_RecoverFromTornWAL(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, &Options{WAL: WALOptions{SyncMode: wal.SyncNone}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, db.Insert([]byte("key1"), []byte("value1")))
+	assert.NoError(t, db.Insert([]byte("key2"), []byte("value2")))
+	walPath := db.memtables.mutable.wal.Name()
+	crash(db)
+
+	info, err := os.Stat(walPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, os.Truncate(walPath, info.Size()-2))
+
+	db, err = Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	val, err := db.Get([]byte("key1"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value1"), val)
+	_, err = db.Get([]byte("key2"))
+	assert.ErrorIs(t, err, ErrorKeyNotFound)
+
+	logs, _ := filepath.Glob(filepath.Join(dir, "*.log"))
+	assert.Len(t, logs, 1) // 새 mutable memtable 의 WAL 만 남는다
+}